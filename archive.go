@@ -0,0 +1,342 @@
+package fs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Codec compresses and decompresses archives, as gzip does.
+type Codec struct {
+	Name string
+
+	// Magic is how the compressed data starts, to recognize it.
+	Magic []byte
+
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// Gzip is the gzip Codec, it's registered by default.
+var Gzip = Codec{
+	Name:  "gzip",
+	Magic: []byte{0x1f, 0x8b},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = []Codec{Gzip}
+)
+
+// RegisterCodec makes ExtractTar recognize the archives compressed with c,
+// as zstd ones given a Codec wrapping a zstd package. A Codec registered
+// under the name of another one replaces it.
+func RegisterCodec(c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	for i, other := range codecs {
+		if other.Name == c.Name {
+			codecs[i] = c
+			return
+		}
+	}
+	codecs = append(codecs, c)
+}
+
+// detectCodec returns the registered Codec the data of r is compressed
+// with, if any.
+func detectCodec(r *bufio.Reader) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	for _, c := range codecs {
+		if magic, _ := r.Peek(len(c.Magic)); len(c.Magic) > 0 && bytes.Equal(magic, c.Magic) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// ArchiveOption configures the extraction or writing of an archive.
+type ArchiveOption func(*archiveConfig)
+
+type archiveConfig struct {
+	codec  *Codec
+	unsafe bool
+}
+
+func newArchiveConfig(opts []ArchiveOption) *archiveConfig {
+	c := &archiveConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithCodec compresses a tar archive being written with c, or reads one
+// as compressed with c rather than recognizing how it's compressed.
+func WithCodec(c Codec) ArchiveOption {
+	return func(a *archiveConfig) {
+		a.codec = &c
+	}
+}
+
+// AllowUnsafePaths lets an archive being extracted hold entries escaping
+// the destination, with absolute names, ".." elements or going through a
+// symbolic link. They fail with ErrInsecurePath otherwise.
+func AllowUnsafePaths() ArchiveOption {
+	return func(a *archiveConfig) {
+		a.unsafe = true
+	}
+}
+
+// ErrInsecurePath is the error of the archive entries that would escape
+// the directory they're extracted to.
+var ErrInsecurePath = tar.ErrInsecurePath
+
+// extractor creates the entries of an archive under dest.
+type extractor struct {
+	o      OperatingSystem
+	dest   string
+	unsafe bool
+
+	// directories get their mode and time once their contents are
+	// extracted, which would change their time, and may need write
+	// permission
+	dirs []extractedDir
+}
+
+type extractedDir struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// path returns where the entry named name goes, failing if it escapes
+// dest.
+func (e *extractor) path(name string) (string, error) {
+	name = strings.TrimSuffix(filepath.FromSlash(name), string(filepath.Separator))
+	if e.unsafe {
+		return filepath.Join(e.dest, name), nil
+	}
+	if !filepath.IsLocal(name) {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+	}
+
+	// nor may it go through a link, which could point anywhere
+	path := e.dest
+	elems := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, elem := range elems {
+		if elem == "." {
+			break
+		}
+		path = filepath.Join(path, elem)
+		if fi, err := e.o.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+		}
+	}
+	return filepath.Join(e.dest, name), nil
+}
+
+// replace makes room for a new entry at path, removing what isn't a
+// directory there.
+func (e *extractor) replace(path string) error {
+	if err := e.o.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if fi, err := e.o.Lstat(path); err == nil && !fi.IsDir() {
+		return e.o.Remove(path)
+	}
+	return nil
+}
+
+func (e *extractor) dir(name string, mode os.FileMode, mtime time.Time, uid, gid int) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := e.isDir(name, path); err != nil {
+		return err
+	}
+	if err := e.o.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	e.chown(path, uid, gid)
+	e.dirs = append(e.dirs, extractedDir{path, mode, mtime})
+	return nil
+}
+
+func (e *extractor) file(name string, mode os.FileMode, mtime time.Time, uid, gid int, r io.Reader) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := e.replace(path); err != nil {
+		return err
+	}
+
+	f, err := e.o.OpenFile(path, O_WRONLY|O_CREATE|O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	e.chown(path, uid, gid)
+	if err := e.o.Chmod(path, mode); err != nil {
+		return err
+	}
+	return e.o.Chtimes(path, mtime, mtime)
+}
+
+func (e *extractor) symlink(name, target string, uid, gid int) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := e.replace(path); err != nil {
+		return err
+	}
+	if err := e.o.Symlink(target, path); err != nil {
+		return err
+	}
+	e.chown(path, uid, gid)
+	return nil
+}
+
+func (e *extractor) link(name, target string) error {
+	path, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	oldpath, err := e.path(target)
+	if err != nil {
+		return err
+	}
+	if err := e.replace(path); err != nil {
+		return err
+	}
+	return e.o.Link(oldpath, path)
+}
+
+// chown gives the entry at path its owner, where o lets us.
+func (e *extractor) chown(path string, uid, gid int) {
+	if uid >= 0 && gid >= 0 {
+		e.o.Lchown(path, uid, gid)
+	}
+}
+
+// isDir fails if the entry named name, at path, exists but isn't a
+// directory: a link there would have the directory's mode and time given to
+// what it points to.
+func (e *extractor) isDir(name, path string) error {
+	if e.unsafe {
+		return nil
+	}
+	if fi, err := e.o.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+	}
+	return nil
+}
+
+// finish gives the directories their mode and time, deepest first.
+func (e *extractor) finish() error {
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		d := e.dirs[i]
+		if err := e.isDir(d.path, d.path); err != nil {
+			return err
+		}
+		if err := e.o.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+		if err := e.o.Chtimes(d.path, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsupported is the error of the entries that are neither regular files,
+// directories nor links.
+func unsupported(name string) error {
+	return &os.PathError{Op: "extract", Path: name, Err: syscall.Errno(syscall.ENOTSUP)}
+}
+
+// archiveEntry is a file of a tree being archived.
+type archiveEntry struct {
+	path string // on the OperatingSystem
+	name string // in the archive, slash separated
+	fi   os.FileInfo
+	link string // the target of a symbolic link, or the entry a hard link shares its file with
+	hard bool
+}
+
+// walkArchive calls fn for the files of the tree rooted at root on o, in
+// lexical order. When hardLinks is set, a file already seen under another
+// name is reported as a hard link to it.
+func walkArchive(o OperatingSystem, root string, hardLinks bool, fn func(e archiveEntry) error) error {
+	type seenFile struct {
+		name string
+		fi   os.FileInfo
+	}
+	seen := map[int64][]seenFile{} // by size
+
+	return Walk(o, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		e := archiveEntry{path: path, name: filepath.ToSlash(rel), fi: fi}
+
+		switch {
+		case fi.IsDir():
+			e.name += "/"
+		case fi.Mode()&os.ModeSymlink != 0:
+			if e.link, err = o.Readlink(path); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular() && hardLinks:
+			for _, s := range seen[fi.Size()] {
+				if o.SameFile(s.fi, fi) {
+					e.link, e.hard = s.name, true
+					break
+				}
+			}
+			if !e.hard {
+				seen[fi.Size()] = append(seen[fi.Size()], seenFile{e.name, fi})
+			}
+		case !fi.Mode().IsRegular():
+			return unsupported(path)
+		}
+		return fn(e)
+	})
+}
+
+// owner returns the owner and group of fi, or -1 if unknown.
+func owner(fi os.FileInfo) (uid, gid int) {
+	if st, ok := sysStat(fi); ok {
+		return st.uid, st.gid
+	}
+	return -1, -1
+}
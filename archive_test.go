@@ -0,0 +1,239 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func releaseTree(t *testing.T) OperatingSystem {
+	t.Helper()
+
+	o, err := FromTxtar([]byte(`
+-- release/bin/app mode=0755 --
+#!/bin/sh
+-- release/etc/app.conf mode=0600 --
+debug=false
+-- release/share/ --
+-- release/current -> bin/app --
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Link("/release/etc/app.conf", "/release/etc/app.conf.default")
+	o.Chown("/release/etc/app.conf", 1000, 100)
+	for _, name := range []string{"/release/bin/app", "/release/etc/app.conf", "/release/share", "/release/etc", "/release/bin", "/release"} {
+		o.Chtimes(name, epoch, epoch)
+	}
+	return o
+}
+
+func Test_Tar_RoundTrip(t *testing.T) {
+	for _, opts := range [][]ArchiveOption{nil, {WithCodec(Gzip)}} {
+		src := releaseTree(t)
+		var buf bytes.Buffer
+		if err := WriteTar(src, "/release", &buf, opts...); err != nil {
+			t.Fatalf("failed to write, err: %v", err)
+		}
+		if opts != nil && !bytes.HasPrefix(buf.Bytes(), Gzip.Magic) {
+			t.Errorf("expected the archive to be compressed")
+		}
+
+		dst := FakeOS()
+		if err := ExtractTar(dst, &buf, "/opt/app"); err != nil {
+			t.Fatalf("failed to extract, err: %v", err)
+		}
+		dst.Chtimes("/opt/app", epoch, epoch) // the root itself isn't archived
+		if changes, err := Diff(src, dst, "/release", "/opt/app"); err != nil || len(changes) != 0 {
+			t.Errorf("expected the tree to be extracted as it was, got %v, err: %v", changes, err)
+		}
+
+		a, _ := dst.Stat("/opt/app/etc/app.conf")
+		b, _ := dst.Stat("/opt/app/etc/app.conf.default")
+		if !dst.SameFile(a, b) {
+			t.Errorf("expected the hard link to be kept")
+		}
+	}
+}
+
+func Test_Zip_RoundTrip(t *testing.T) {
+	src := releaseTree(t)
+	var buf bytes.Buffer
+	if err := WriteZip(src, "/release", &buf); err != nil {
+		t.Fatalf("failed to write, err: %v", err)
+	}
+
+	dst := FakeOS()
+	if err := ExtractZip(dst, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "/opt/app"); err != nil {
+		t.Fatalf("failed to extract, err: %v", err)
+	}
+	// zip keeps neither owners nor hard links
+	dst.Chtimes("/opt/app", epoch, epoch)
+	if changes, err := Diff(src, dst, "/release", "/opt/app", IgnoreAttrs(AttrOwner)); err != nil || len(changes) != 0 {
+		t.Errorf("expected the tree to be extracted as it was, got %v, err: %v", changes, err)
+	}
+}
+
+func Test_ExtractTar_Unsafe(t *testing.T) {
+	archive := func(headers ...*tar.Header) io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, h := range headers {
+			tw.WriteHeader(h)
+			io.WriteString(tw, strings.Repeat("x", int(h.Size)))
+		}
+		tw.Close()
+		return &buf
+	}
+
+	for name, r := range map[string]func() io.Reader{
+		"dotdot": func() io.Reader {
+			return archive(&tar.Header{Name: "../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		},
+		"absolute": func() io.Reader {
+			return archive(&tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		},
+		"symlink": func() io.Reader {
+			return archive(
+				&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+				&tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+			)
+		},
+		"symlink dir": func() io.Reader {
+			return archive(
+				&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+				&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0777},
+			)
+		},
+		"hardlink": func() io.Reader {
+			return archive(&tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"})
+		},
+	} {
+		o := FakeOS()
+		o.MkdirAll("/etc", 0755)
+		WriteFile(o, "/etc/passwd", []byte("root"), 0644)
+
+		if err := ExtractTar(o, r(), "/tmp/x"); !errors.Is(err, ErrInsecurePath) {
+			t.Errorf("%s: expected ErrInsecurePath, err: %v", name, err)
+		}
+		if got, _ := ReadFile(o, "/etc/passwd"); string(got) != "root" {
+			t.Errorf("%s: expected /etc/passwd to be left alone, got %q", name, got)
+		}
+		if fi, _ := o.Stat("/etc"); fi.Mode().Perm() != 0755 {
+			t.Errorf("%s: expected /etc to be left alone, got %v", name, fi.Mode())
+		}
+	}
+
+	o := FakeOS()
+	r := archive(&tar.Header{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	if err := ExtractTar(o, r, "/tmp/x", AllowUnsafePaths()); err != nil {
+		t.Errorf("failed to extract, err: %v", err)
+	}
+	if _, err := o.Stat("/tmp/escaped"); err != nil {
+		t.Errorf("expected the unsafe path to be allowed, err: %v", err)
+	}
+
+	r = archive(&tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644})
+	if err := ExtractTar(o, r, "/tmp/y"); !errors.Is(err, syscall.ENOTSUP) {
+		t.Errorf("expected ENOTSUP, err: %v", err)
+	}
+}
+
+func Test_ExtractTar_GlobalHeader(t *testing.T) {
+	// as written by git archive
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": "6c2bc4a1d5e3f0b2a7c8d9e0f1a2b3c4d5e6f7a8"},
+	})
+	tw.WriteHeader(&tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "app/README", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	io.WriteString(tw, "hello")
+	tw.Close()
+
+	o := FakeOS()
+	if err := ExtractTar(o, &buf, "/src"); err != nil {
+		t.Fatalf("failed to extract, err: %v", err)
+	}
+	if got, _ := ReadFile(o, "/src/app/README"); string(got) != "hello" {
+		t.Errorf("expected the file to be extracted, got %q", got)
+	}
+	if _, err := o.Lstat("/src/pax_global_header"); !os.IsNotExist(err) {
+		t.Errorf("expected the global header to be skipped, err: %v", err)
+	}
+}
+
+func Test_RegisterCodec(t *testing.T) {
+	// a codec that only marks its data, standing in for zstd
+	marked := Codec{
+		Name:  "test",
+		Magic: []byte("TEST"),
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(r, magic); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(r), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			_, err := io.WriteString(w, "TEST")
+			return nopWriteCloser{w}, err
+		},
+	}
+	RegisterCodec(marked)
+
+	src := releaseTree(t)
+	var buf bytes.Buffer
+	if err := WriteTar(src, "/release", &buf, WithCodec(marked)); err != nil {
+		t.Fatal(err)
+	}
+	dst := FakeOS()
+	if err := ExtractTar(dst, &buf, "/opt"); err != nil {
+		t.Fatalf("expected the codec to be recognized, err: %v", err)
+	}
+	if _, err := dst.Stat("/opt/bin/app"); err != nil {
+		t.Errorf("expected the archive to be extracted, err: %v", err)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func Test_Tar_Disk(t *testing.T) {
+	src := releaseTree(t)
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	WriteTar(src, "/release", &buf, WithCodec(Gzip))
+	if err := ExtractTar(DefaultOS(), &buf, dir); err != nil {
+		t.Fatalf("failed to extract to disk, err: %v", err)
+	}
+	if fi, err := os.Lstat(dir + "/current"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected a link on disk, err: %v", err)
+	}
+
+	buf.Reset()
+	if err := WriteTar(DefaultOS(), dir, &buf); err != nil {
+		t.Fatalf("failed to archive the disk, err: %v", err)
+	}
+	back := FakeOS()
+	if err := ExtractTar(back, &buf, "/release"); err != nil {
+		t.Fatal(err)
+	}
+	back.Chtimes("/release", epoch, epoch)
+	if changes, err := Diff(src, back, "/release", "/release", IgnoreAttrs(AttrOwner)); err != nil || len(changes) != 0 {
+		t.Errorf("expected the tree to go through the disk unchanged, got %v, err: %v", changes, err)
+	}
+}
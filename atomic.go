@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AtomicWriter writes a file replacing another one atomically: until it's
+// closed the data goes to a temporary file next to the one it replaces,
+// which is then synced and renamed over it. Readers see either all of the
+// old contents or all of the new ones, even if the program crashes along
+// the way.
+type AtomicWriter struct {
+	o      OperatingSystem
+	name   string
+	tmp    File
+	err    error // the first write error, failing Close
+	closed bool
+}
+
+// NewAtomicWriter returns an AtomicWriter replacing the file named name on
+// o. The new file keeps the mode and, where possible, the ownership of the
+// one it replaces, a file that doesn't exist yet is created with mode
+// 0644.
+func NewAtomicWriter(o OperatingSystem, name string) (*AtomicWriter, error) {
+	return newAtomicWriter(o, name, 0644)
+}
+
+func newAtomicWriter(o OperatingSystem, name string, perm os.FileMode) (*AtomicWriter, error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "." // rather than o.TempDir(), which may be another device
+	}
+	tmp, err := CreateTemp(o, dir, "."+base+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	w := &AtomicWriter{o: o, name: name, tmp: tmp}
+
+	mode := perm
+	fi, err := o.Stat(name)
+	if err == nil {
+		mode = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if st, ok := sysStat(fi); ok {
+		// only a privileged user may give a file away, which is fine
+		tmp.Chown(st.uid, st.gid)
+	}
+	return w, nil
+}
+
+// Name returns the name of the file being replaced.
+func (w *AtomicWriter) Name() string {
+	return w.name
+}
+
+// Write writes b to the temporary file. Once a Write failed, all the
+// following ones and Close fail with the same error.
+func (w *AtomicWriter) Write(b []byte) (n int, err error) {
+	if w.closed {
+		return 0, &os.PathError{Op: "write", Path: w.name, Err: os.ErrClosed}
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err = w.tmp.Write(b)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Close syncs the temporary file and renames it over the file being
+// replaced, then syncs the directory holding them so that the rename
+// itself is durable. The temporary file is removed if anything fails
+// before the rename, leaving the old file as it was.
+func (w *AtomicWriter) Close() error {
+	if w.closed {
+		return &os.PathError{Op: "close", Path: w.name, Err: os.ErrClosed}
+	}
+	w.closed = true
+
+	err := w.err
+	if err == nil {
+		err = w.tmp.Sync()
+	}
+	if err1 := w.tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = w.o.Rename(w.tmp.Name(), w.name)
+	}
+	if err != nil {
+		w.o.Remove(w.tmp.Name())
+		return err
+	}
+	return syncDir(w.o, filepath.Dir(w.name))
+}
+
+// Abort discards what was written, leaving the file being replaced as it
+// was. It does nothing once w is closed.
+func (w *AtomicWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.tmp.Close()
+	return w.o.Remove(w.tmp.Name())
+}
+
+// syncDir syncs the directory at path, making the changes to its entries
+// durable.
+func syncDir(o OperatingSystem, path string) error {
+	d, err := o.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// WriteFileAtomic writes data to the file named name on o as WriteFile
+// does, but atomically, see AtomicWriter. A file that doesn't exist yet is
+// created with perm, which unlike WriteFile isn't subject to the umask.
+func WriteFileAtomic(o OperatingSystem, name string, data []byte, perm os.FileMode) error {
+	w, err := newAtomicWriter(o, name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
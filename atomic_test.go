@@ -0,0 +1,138 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// faultyOS fails the operations listed in fail, the File ones included.
+type faultyOS struct {
+	OperatingSystem
+	fail map[string]error
+}
+
+func (o *faultyOS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := o.OperatingSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: f, fail: o.fail}, nil
+}
+
+func (o *faultyOS) Rename(oldname, newname string) error {
+	if err := o.fail["Rename"]; err != nil {
+		return err
+	}
+	return o.OperatingSystem.Rename(oldname, newname)
+}
+
+type faultyFile struct {
+	File
+	fail map[string]error
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if err := f.fail["File.Write"]; err != nil {
+		return 0, err
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if err := f.fail["File.Sync"]; err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func Test_WriteFileAtomic(t *testing.T) {
+	for name, o := range map[string]OperatingSystem{
+		"fake":    FakeOS(),
+		"default": DefaultOS(),
+	} {
+		dir := "/tmp"
+		if name == "default" {
+			dir = t.TempDir()
+		}
+		conf := filepath.Join(dir, "app.conf")
+
+		if err := WriteFileAtomic(o, conf, []byte("v1"), 0640); err != nil {
+			t.Fatalf("%s: failed to write, err: %v", name, err)
+		}
+		o.Chmod(conf, 0600)
+		if err := WriteFileAtomic(o, conf, []byte("v2"), 0644); err != nil {
+			t.Fatalf("%s: failed to replace, err: %v", name, err)
+		}
+
+		if got, _ := ReadFile(o, conf); string(got) != "v2" {
+			t.Errorf("%s: expected v2, got %q", name, got)
+		}
+		if fi, _ := o.Stat(conf); fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: expected the mode to be kept, got %v", name, fi.Mode())
+		}
+		if entries, _ := ReadDir(o, dir); len(entries) != 1 {
+			t.Errorf("%s: expected the temporary file to be gone, got %v", name, entries)
+		}
+	}
+}
+
+func Test_WriteFileAtomic_Faults(t *testing.T) {
+	eio := syscall.Errno(syscall.EIO)
+	for _, op := range []string{"File.Write", "File.Sync", "Rename"} {
+		fake := FakeOS()
+		WriteFile(fake, "/tmp/app.conf", []byte("v1"), 0644)
+		o := &faultyOS{OperatingSystem: fake, fail: map[string]error{op: eio}}
+
+		if err := WriteFileAtomic(o, "/tmp/app.conf", []byte("v2"), 0644); !errors.Is(err, eio) {
+			t.Errorf("%s: expected EIO, err: %v", op, err)
+		}
+		if got, _ := ReadFile(fake, "/tmp/app.conf"); string(got) != "v1" {
+			t.Errorf("%s: expected the old contents to be kept, got %q", op, got)
+		}
+		if names, _ := Glob(fake, "/tmp/.app.conf.tmp*"); len(names) != 0 {
+			t.Errorf("%s: expected the temporary file to be removed, got %v", op, names)
+		}
+	}
+}
+
+func Test_AtomicWriter(t *testing.T) {
+	o := FakeOS()
+	WriteFile(o, "/tmp/data.json", []byte("{}"), 0644)
+	o.Chown("/tmp/data.json", 1000, 1000)
+
+	w, err := NewAtomicWriter(o, "/tmp/data.json")
+	if err != nil {
+		t.Fatalf("failed to create, err: %v", err)
+	}
+	w.Write([]byte(`{"a":`))
+	if got, _ := ReadFile(o, "/tmp/data.json"); string(got) != "{}" {
+		t.Errorf("expected nothing to change before Close, got %q", got)
+	}
+	w.Write([]byte(`1}`))
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close, err: %v", err)
+	}
+
+	if got, _ := ReadFile(o, "/tmp/data.json"); string(got) != `{"a":1}` {
+		t.Errorf("unexpected contents %q", got)
+	}
+	fi, _ := o.Stat("/tmp/data.json")
+	if st, ok := sysStat(fi); !ok || st.uid != 1000 || st.gid != 1000 {
+		t.Errorf("expected the ownership to be kept, got %+v", fi.Sys())
+	}
+	if _, err := w.Write(nil); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected ErrClosed, err: %v", err)
+	}
+
+	w, _ = NewAtomicWriter(o, "/tmp/data.json")
+	w.Write([]byte("garbage"))
+	if err := w.Abort(); err != nil {
+		t.Errorf("failed to abort, err: %v", err)
+	}
+	if got, _ := ReadFile(o, "/tmp/data.json"); string(got) != `{"a":1}` {
+		t.Errorf("expected the aborted contents to be discarded, got %q", got)
+	}
+}
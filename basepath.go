@@ -0,0 +1,415 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxSymlinks is the number of symlinks that will be followed while
+// resolving a single path before giving up with ELOOP, matching Linux.
+const maxSymlinks = 40
+
+type basePathOS struct {
+	OperatingSystem
+	root string
+}
+
+// BasePath confines o to the subtree at root. Every path given to the
+// returned OperatingSystem is interpreted relative to root, and any attempt
+// to leave it, be it through "..", an absolute symlink or a chain of
+// relative symlinks, fails with EPERM. Paths handed back to the caller
+// (Getwd, TempDir, File.Name, the paths in errors and absolute symlink
+// targets) are virtual ones, Readlink fails with EPERM on an absolute
+// target outside of root.
+//
+// Symlinks are resolved by BasePath before the call reaches o, so a symlink
+// swapped in concurrently by someone with direct access to root can still
+// be followed by o.
+func BasePath(o OperatingSystem, root string) OperatingSystem {
+	if !filepath.IsAbs(root) {
+		if wd, err := o.Getwd(); err == nil {
+			root = filepath.Join(wd, root)
+		}
+	}
+	return &basePathOS{
+		OperatingSystem: o,
+		root:            filepath.Clean(root),
+	}
+}
+
+// virtual maps a path on the wrapped OperatingSystem to the path it has
+// inside of the base path, reporting false if it lies outside of it.
+func (b *basePathOS) virtual(real string) (string, bool) {
+	rel, err := filepath.Rel(b.root, real)
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(string(filepath.Separator), rel), true
+}
+
+// cwd returns the virtual working directory, which is the root if the real
+// one lies outside of it.
+func (b *basePathOS) cwd() string {
+	if wd, err := b.OperatingSystem.Getwd(); err == nil {
+		if v, ok := b.virtual(wd); ok {
+			return v
+		}
+	}
+	return string(filepath.Separator)
+}
+
+// resolve turns the virtual name into a path on the wrapped
+// OperatingSystem, resolving any symlinks along the way so that escapes can
+// be detected. The final element is only resolved if follow is set.
+func (b *basePathOS) resolve(op, name string, follow bool) (string, error) {
+	if name == "" {
+		return "", &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.ENOENT),
+		}
+	}
+
+	vpath := name
+	if !filepath.IsAbs(vpath) {
+		vpath = b.cwd() + string(filepath.Separator) + vpath
+	}
+
+	var (
+		escape = &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.EPERM),
+		}
+		resolved []string
+		pending  = strings.Split(vpath, string(filepath.Separator))
+		links    = 0
+	)
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", escape
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		resolved = append(resolved, elem)
+		if len(pending) == 0 && !follow {
+			break
+		}
+
+		real := filepath.Join(append([]string{b.root}, resolved...)...)
+		fi, err := b.OperatingSystem.Lstat(real)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// whatever doesn't exist yet can't be a symlink, let the
+			// wrapped OperatingSystem report on it
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{
+				Op:   op,
+				Path: name,
+				Err:  syscall.Errno(syscall.ELOOP),
+			}
+		}
+
+		target, err := b.OperatingSystem.Readlink(real)
+		if err != nil {
+			return "", renameErr(err, name)
+		}
+		if filepath.IsAbs(target) {
+			return "", escape
+		}
+
+		resolved = resolved[:len(resolved)-1]
+		pending = append(
+			strings.Split(target, string(filepath.Separator)),
+			pending...)
+	}
+
+	return filepath.Join(append([]string{b.root}, resolved...)...), nil
+}
+
+func (b *basePathOS) Chdir(dir string) error {
+	real, err := b.resolve("chdir", dir, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Chdir(real), dir)
+}
+
+func (b *basePathOS) Chmod(name string, mode os.FileMode) error {
+	real, err := b.resolve("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Chmod(real, mode), name)
+}
+
+func (b *basePathOS) Chown(name string, uid, gid int) error {
+	real, err := b.resolve("chown", name, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Chown(real, uid, gid), name)
+}
+
+func (b *basePathOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	real, err := b.resolve("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Chtimes(real, atime, mtime), name)
+}
+
+func (b *basePathOS) Getwd() (dir string, err error) {
+	return b.cwd(), nil
+}
+
+func (b *basePathOS) Lchown(name string, uid, gid int) error {
+	real, err := b.resolve("lchown", name, false)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Lchown(real, uid, gid), name)
+}
+
+func (b *basePathOS) Link(oldname, newname string) error {
+	realOld, err := b.resolve("link", oldname, false)
+	if err != nil {
+		return renameLinkErr(err, oldname, newname)
+	}
+	realNew, err := b.resolve("link", newname, false)
+	if err != nil {
+		return renameLinkErr(err, oldname, newname)
+	}
+	return renameLinkErr(
+		b.OperatingSystem.Link(realOld, realNew), oldname, newname)
+}
+
+func (b *basePathOS) Mkdir(name string, perm os.FileMode) error {
+	real, err := b.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Mkdir(real, perm), name)
+}
+
+func (b *basePathOS) MkdirAll(path string, perm os.FileMode) error {
+	real, err := b.resolve("mkdir", path, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.MkdirAll(real, perm), path)
+}
+
+func (b *basePathOS) Readlink(name string) (string, error) {
+	real, err := b.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := b.OperatingSystem.Readlink(real)
+	if err != nil {
+		return "", renameErr(err, name)
+	}
+	if filepath.IsAbs(target) {
+		v, ok := b.virtual(target)
+		if !ok {
+			// the target would give away where the root is
+			return "", &os.PathError{
+				Op:   "readlink",
+				Path: name,
+				Err:  syscall.Errno(syscall.EPERM),
+			}
+		}
+		return v, nil
+	}
+	return target, nil
+}
+
+func (b *basePathOS) Remove(name string) error {
+	real, err := b.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Remove(real), name)
+}
+
+func (b *basePathOS) RemoveAll(path string) error {
+	real, err := b.resolve("unlinkat", path, false)
+	if err != nil {
+		return err
+	}
+	if real == b.root {
+		// RemoveAll("/") would take the base path itself with it
+		return &os.PathError{
+			Op:   "unlinkat",
+			Path: path,
+			Err:  syscall.Errno(syscall.EPERM),
+		}
+	}
+	return renameErr(b.OperatingSystem.RemoveAll(real), path)
+}
+
+func (b *basePathOS) Rename(oldname, newname string) error {
+	realOld, err := b.resolve("rename", oldname, false)
+	if err != nil {
+		return renameLinkErr(err, oldname, newname)
+	}
+	realNew, err := b.resolve("rename", newname, false)
+	if err != nil {
+		return renameLinkErr(err, oldname, newname)
+	}
+	return renameLinkErr(
+		b.OperatingSystem.Rename(realOld, realNew), oldname, newname)
+}
+
+func (b *basePathOS) Symlink(oldname, newname string) error {
+	realNew, err := b.resolve("symlink", newname, false)
+	if err != nil {
+		return renameLinkErr(err, oldname, newname)
+	}
+
+	// absolute targets would be resolved against the real root, so store
+	// them relative to the link instead
+	target := oldname
+	if filepath.IsAbs(target) {
+		vnew, _ := b.virtual(realNew)
+		target, err = filepath.Rel(filepath.Dir(vnew), filepath.Clean(target))
+		if err != nil {
+			return renameLinkErr(err, oldname, newname)
+		}
+	}
+
+	return renameLinkErr(
+		b.OperatingSystem.Symlink(target, realNew), oldname, newname)
+}
+
+// TempDir returns the virtual path of the wrapped OperatingSystem's
+// temporary directory, or /tmp inside of the base path if that lies outside
+// of it.
+func (b *basePathOS) TempDir() string {
+	if v, ok := b.virtual(b.OperatingSystem.TempDir()); ok {
+		return v
+	}
+	return string(filepath.Separator) + "tmp"
+}
+
+func (b *basePathOS) Truncate(name string, size int64) error {
+	real, err := b.resolve("truncate", name, true)
+	if err != nil {
+		return err
+	}
+	return renameErr(b.OperatingSystem.Truncate(real, size), name)
+}
+
+func (b *basePathOS) Create(name string) (file File, err error) {
+	real, err := b.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.OperatingSystem.Create(real)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	return &namedFile{File: f, name: name}, nil
+}
+
+func (b *basePathOS) NewFile(fd uintptr, name string) File {
+	f := b.OperatingSystem.NewFile(fd, name)
+	if f == nil {
+		return nil
+	}
+	return &namedFile{File: f, name: name}
+}
+
+func (b *basePathOS) Open(name string) (file File, err error) {
+	real, err := b.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.OperatingSystem.Open(real)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	return &namedFile{File: f, name: name}, nil
+}
+
+func (b *basePathOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	real, err := b.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.OperatingSystem.OpenFile(real, flag, perm)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	return &namedFile{File: f, name: name}, nil
+}
+
+func (b *basePathOS) Lstat(name string) (fi os.FileInfo, err error) {
+	real, err := b.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = b.OperatingSystem.Lstat(real)
+	return fi, renameErr(err, name)
+}
+
+func (b *basePathOS) Stat(name string) (fi os.FileInfo, err error) {
+	real, err := b.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err = b.OperatingSystem.Stat(real)
+	return fi, renameErr(err, name)
+}
+
+// namedFile is a File that reports a different name than the one it was
+// opened with on the underlying OperatingSystem.
+type namedFile struct {
+	File
+	name string
+}
+
+func (f *namedFile) Name() string {
+	return f.name
+}
+
+// Watch watches name on the wrapped OperatingSystem, see Watchable. The
+// files are reported under their virtual paths.
+func (b *basePathOS) Watch(name string, recursive bool) (Watcher, error) {
+	real, err := b.resolve("watch", name, true)
+	if err != nil {
+		return nil, err
+	}
+	w, err := Watch(b.OperatingSystem, real, recursive)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	return relayWatch(watchSource{w, rebase(real, name)}), nil
+}
+
+// random draws from the wrapped OperatingSystem, see randomSource.
+func (b *basePathOS) random() uint32 {
+	return randomOf(b.OperatingSystem)
+}
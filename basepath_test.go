@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func newBasePath(t *testing.T) (OperatingSystem, string) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(root, "etc", "app.conf"), []byte("ok"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return BasePath(DefaultOS(), root), root
+}
+
+func Test_BasePath_Open(t *testing.T) {
+	b, _ := newBasePath(t)
+
+	f, err := b.Open("/etc/app.conf")
+	if err != nil {
+		t.Fatalf("expected to open /etc/app.conf, err: %v", err)
+	}
+	defer f.Close()
+
+	if f.Name() != "/etc/app.conf" {
+		t.Errorf("expected virtual file name, was: %q", f.Name())
+	}
+}
+
+func Test_BasePath_Create(t *testing.T) {
+	b, root := newBasePath(t)
+
+	f, err := b.Create("/etc/new.conf")
+	if err != nil {
+		t.Fatalf("expected to create /etc/new.conf, err: %v", err)
+	}
+	f.Close()
+
+	if _, err := os.Stat(filepath.Join(root, "etc", "new.conf")); err != nil {
+		t.Errorf("expected file to be created under the root, err: %v", err)
+	}
+}
+
+func Test_BasePath_DotDotEscape(t *testing.T) {
+	b, _ := newBasePath(t)
+
+	for _, name := range []string{"/../root/etc/app.conf", "../../etc/passwd"} {
+		if _, err := b.Stat(name); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("expected Stat(%q) to fail with EPERM, err: %v", name, err)
+		}
+	}
+
+	// staying inside is fine
+	if _, err := b.Stat("/etc/../etc/app.conf"); err != nil {
+		t.Errorf("expected Stat to succeed, err: %v", err)
+	}
+}
+
+func Test_BasePath_SymlinkEscape(t *testing.T) {
+	b, root := newBasePath(t)
+
+	if err := os.Symlink("/etc", filepath.Join(root, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../..", filepath.Join(root, "etc", "up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("up/..", filepath.Join(root, "etc", "chain")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/abs/passwd", "/etc/up/x", "/etc/chain"} {
+		if _, err := b.Open(name); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("expected Open(%q) to fail with EPERM, err: %v", name, err)
+		}
+	}
+
+	// Lstat doesn't follow the final symlink, so it's harmless
+	if _, err := b.Lstat("/abs"); err != nil {
+		t.Errorf("expected Lstat to succeed, err: %v", err)
+	}
+
+	// the target of /abs lies outside the root, /in points inside it
+	if target, err := b.Readlink("/abs"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected Readlink to fail with EPERM, got %q, err: %v", target, err)
+	}
+	if err := os.Symlink(filepath.Join(root, "etc"), filepath.Join(root, "in")); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := b.Readlink("/in"); err != nil || target != filepath.FromSlash("/etc") {
+		t.Errorf("expected the target to be made virtual, got %q, err: %v", target, err)
+	}
+}
+
+func Test_BasePath_Symlink(t *testing.T) {
+	b, root := newBasePath(t)
+
+	if err := b.Symlink("/etc/app.conf", "/link"); err != nil {
+		t.Fatalf("failed to create symlink, err: %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Join("etc", "app.conf") {
+		t.Errorf("expected target to be made relative, was: %q", target)
+	}
+
+	if _, err := b.Stat("/link"); err != nil {
+		t.Errorf("expected to be able to follow the link, err: %v", err)
+	}
+}
+
+func Test_BasePath_Getwd_TempDir(t *testing.T) {
+	b, _ := newBasePath(t)
+
+	if wd, err := b.Getwd(); err != nil || wd != "/" {
+		t.Errorf("expected Getwd to be \"/\", was: %q, err: %v", wd, err)
+	}
+	if tmp := b.TempDir(); tmp != "/tmp" {
+		t.Errorf("expected TempDir to be \"/tmp\", was: %q", tmp)
+	}
+}
+
+func Test_BasePath_ErrorPaths(t *testing.T) {
+	b, root := newBasePath(t)
+
+	_, err := b.Stat("/missing")
+	pe, ok := err.(*os.PathError)
+	if !ok {
+		t.Fatalf("expected *os.PathError, was: %v", err)
+	}
+	if pe.Path != "/missing" {
+		t.Errorf("expected error to use the virtual path, was: %q (root %q)",
+			pe.Path, root)
+	}
+}
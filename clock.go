@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, and lets time pass, for a FakeOS (see WithClock).
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+// RealClock returns the Clock of the time package.
+func RealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// FakeClock is a Clock only moving forward when told to, so that slow
+// operations can be simulated without slowing tests down.
+//
+// Sleep blocks until the clock is advanced past the end of the sleep,
+// unless the clock advances automatically, in which case Sleep moves the
+// clock forward and returns right away.
+type FakeClock struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	auto     bool
+	sleepers []*sleeper
+}
+
+type sleeper struct {
+	until time.Time
+	done  chan struct{}
+}
+
+// NewFakeClock returns a FakeClock set to start.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+// SetAutoAdvance sets whether Sleep advances the clock by itself.
+func (c *FakeClock) SetAutoAdvance(auto bool) {
+	c.lock.Lock()
+	c.auto = auto
+	c.lock.Unlock()
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	if c.auto {
+		c.advance(d)
+		c.lock.Unlock()
+		return
+	}
+	s := &sleeper{until: c.now.Add(d), done: make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.cond.Broadcast()
+	c.lock.Unlock()
+
+	<-s.done
+}
+
+// Advance moves the clock forward by d, waking up the goroutines whose
+// sleep is over.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	c.advance(d)
+	c.lock.Unlock()
+}
+
+// advance must be called with c.lock held.
+func (c *FakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+
+	sleeping := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if c.now.Before(s.until) {
+			sleeping = append(sleeping, s)
+		} else {
+			close(s.done)
+		}
+	}
+	c.sleepers = sleeping
+	c.cond.Broadcast()
+}
+
+// Sleepers returns how many goroutines are sleeping.
+func (c *FakeClock) Sleepers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.sleepers)
+}
+
+// BlockUntil waits until n goroutines are sleeping, e.g. stuck in a slow
+// call, so that a test knows when to advance the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+	c.lock.Unlock()
+}
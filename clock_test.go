@@ -0,0 +1,45 @@
+package fs
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func Test_FakeClock_Advance(t *testing.T) {
+	c := NewFakeClock(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Minute)
+		close(done)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("expected Sleep to go on until the clock is advanced enough")
+	default:
+	}
+
+	c.Advance(time.Second)
+	<-done
+	if c.Sleepers() != 0 {
+		t.Errorf("expected no more sleepers, got %d", c.Sleepers())
+	}
+	if got := c.Now(); !got.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("expected clock to be a minute later, was %v", got)
+	}
+}
+
+func Test_FakeClock_AutoAdvance(t *testing.T) {
+	c := NewFakeClock(epoch)
+	c.SetAutoAdvance(true)
+
+	c.Sleep(time.Hour)
+	if got := c.Now(); !got.Equal(epoch.Add(time.Hour)) {
+		t.Errorf("expected Sleep to advance the clock, was %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package fs
+
+import (
+	"context"
+	"os"
+)
+
+type osContextKey struct{}
+
+// WithOS returns a copy of ctx that carries o. Helpers that take a context
+// (OpenContext, StatContext, ...) will use o instead of the package level
+// OperatingSystem, which lets parallel tests each work against their own
+// FakeOS.
+func WithOS(ctx context.Context, o OperatingSystem) context.Context {
+	return context.WithValue(ctx, osContextKey{}, o)
+}
+
+// FromContext returns the OperatingSystem stored in ctx by WithOS. If ctx
+// doesn't carry one (or is nil) the package level OperatingSystem is
+// returned.
+func FromContext(ctx context.Context) OperatingSystem {
+	if ctx != nil {
+		if o, ok := ctx.Value(osContextKey{}).(OperatingSystem); ok && o != nil {
+			return o
+		}
+	}
+	return currOs
+}
+
+func ChdirContext(ctx context.Context, dir string) error {
+	return FromContext(ctx).Chdir(dir)
+}
+
+func ChmodContext(ctx context.Context, name string, mode os.FileMode) error {
+	return FromContext(ctx).Chmod(name, mode)
+}
+
+func CreateContext(ctx context.Context, name string) (File, error) {
+	return FromContext(ctx).Create(name)
+}
+
+func GetenvContext(ctx context.Context, key string) string {
+	return FromContext(ctx).Getenv(key)
+}
+
+func GetwdContext(ctx context.Context) (string, error) {
+	return FromContext(ctx).Getwd()
+}
+
+func LstatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return FromContext(ctx).Lstat(name)
+}
+
+func MkdirContext(ctx context.Context, name string, perm os.FileMode) error {
+	return FromContext(ctx).Mkdir(name, perm)
+}
+
+func MkdirAllContext(ctx context.Context, path string, perm os.FileMode) error {
+	return FromContext(ctx).MkdirAll(path, perm)
+}
+
+func OpenContext(ctx context.Context, name string) (File, error) {
+	return FromContext(ctx).Open(name)
+}
+
+func OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	return FromContext(ctx).OpenFile(name, flag, perm)
+}
+
+func ReadlinkContext(ctx context.Context, name string) (string, error) {
+	return FromContext(ctx).Readlink(name)
+}
+
+func RemoveContext(ctx context.Context, name string) error {
+	return FromContext(ctx).Remove(name)
+}
+
+func RemoveAllContext(ctx context.Context, path string) error {
+	return FromContext(ctx).RemoveAll(path)
+}
+
+func RenameContext(ctx context.Context, oldname, newname string) error {
+	return FromContext(ctx).Rename(oldname, newname)
+}
+
+func SetenvContext(ctx context.Context, key, value string) error {
+	return FromContext(ctx).Setenv(key, value)
+}
+
+func StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return FromContext(ctx).Stat(name)
+}
+
+func TempDirContext(ctx context.Context) string {
+	return FromContext(ctx).TempDir()
+}
@@ -0,0 +1,53 @@
+package fs
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func Test_FromContext_Fallback(t *testing.T) {
+	if o := FromContext(context.Background()); o != currOs {
+		t.Errorf("expected FromContext to fall back to currOs, was: %v", o)
+	}
+}
+
+func Test_WithOS(t *testing.T) {
+	f := FakeOS()
+	ctx := WithOS(context.Background(), f)
+	if o := FromContext(ctx); o != f {
+		t.Errorf("expected FromContext to return the injected OS, was: %v", o)
+	}
+}
+
+func Test_WithOS_Parallel(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		val := strconv.Itoa(i)
+		t.Run(val, func(t *testing.T) {
+			t.Parallel()
+			ctx := WithOS(context.Background(), FakeOS())
+			if err := SetenvContext(ctx, "SUBTEST", val); err != nil {
+				t.Fatalf("failed to Setenv, err: %v", err)
+			}
+			if v := GetenvContext(ctx, "SUBTEST"); v != val {
+				t.Errorf("expected $SUBTEST to be %q, was: %q", val, v)
+			}
+		})
+	}
+}
+
+func Test_FakeFile_UsesOwner(t *testing.T) {
+	f := FakeOS()
+	ctx := WithOS(context.Background(), f)
+	file, err := CreateContext(ctx, "/tmp/owned")
+	if err != nil {
+		t.Fatalf("failed to create file, err: %v", err)
+	}
+
+	if err := file.Chmod(0600); err != nil {
+		t.Errorf("expected Chmod to go through the owning OS, err: %v", err)
+	}
+	if _, err := currOs.Lstat("/tmp/owned"); err == nil {
+		t.Errorf("file should not have leaked into the package level OS")
+	}
+}
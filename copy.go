@@ -0,0 +1,356 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// CopyOption configures CopyFile, CopyTree, Move and SyncTree.
+type CopyOption func(*copier)
+
+// PreserveMode gives copies the exact mode of the original, special bits
+// included, rather than its permissions subject to the umask.
+func PreserveMode() CopyOption {
+	return func(c *copier) {
+		c.mode = true
+	}
+}
+
+// PreserveOwner gives copies the owner and group of the original, where
+// the destination lets us: failing to is not an error.
+func PreserveOwner() CopyOption {
+	return func(c *copier) {
+		c.owner = true
+	}
+}
+
+// PreserveTimes gives copies the modification time of the original.
+func PreserveTimes() CopyOption {
+	return func(c *copier) {
+		c.times = true
+	}
+}
+
+// PreserveSymlinks copies symbolic links as links, rather than copying
+// what they point to.
+func PreserveSymlinks() CopyOption {
+	return func(c *copier) {
+		c.symlinks = true
+	}
+}
+
+// CompareContent makes SyncTree compare files by the hash of their
+// contents, rather than by size and modification time.
+func CompareContent() CopyOption {
+	return func(c *copier) {
+		c.content = true
+	}
+}
+
+type copier struct {
+	src, dst                     OperatingSystem
+	mode, owner, times, symlinks bool
+
+	// for SyncTree
+	sync, content bool
+}
+
+func newCopier(src, dst OperatingSystem, opts []CopyOption) *copier {
+	c := &copier{src: src, dst: dst}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// CopyFile copies the file src on srcOS to dst on dstOS, replacing dst if
+// it exists. The copy gets the permissions of src, subject to the umask,
+// unless other attributes are preserved through opts.
+func CopyFile(srcOS OperatingSystem, src string, dstOS OperatingSystem, dst string, opts ...CopyOption) error {
+	c := newCopier(srcOS, dstOS, opts)
+	fi, err := c.stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &os.PathError{Op: "copy", Path: src, Err: syscall.Errno(syscall.EISDIR)}
+	}
+	return c.copy(src, fi, dst)
+}
+
+// CopyTree copies the file tree rooted at src on srcOS to dst on dstOS,
+// as CopyFile does for each file. Directories are created as needed, the
+// files already under dst are replaced or left alone. Symbolic links are
+// followed unless PreserveSymlinks is given, a link leading back into the
+// tree fails with ELOOP, as does copying src into itself on the same
+// OperatingSystem.
+func CopyTree(srcOS OperatingSystem, src string, dstOS OperatingSystem, dst string, opts ...CopyOption) error {
+	return newCopier(srcOS, dstOS, opts).tree(src, dst)
+}
+
+// SyncTree makes the tree rooted at dst on dstOS a copy of the one at src
+// on srcOS, as CopyTree does, but only copies the files that changed: the
+// ones whose size or modification time differ, or whose contents differ
+// with CompareContent. Modification times are always preserved, so that
+// the next sync finds the files unchanged. Files under dst that aren't
+// under src are left alone.
+func SyncTree(srcOS OperatingSystem, src string, dstOS OperatingSystem, dst string, opts ...CopyOption) error {
+	c := newCopier(srcOS, dstOS, opts)
+	c.sync, c.times = true, true
+	return c.tree(src, dst)
+}
+
+// Move moves src on srcOS to dst on dstOS, renaming it when both are on
+// the same OperatingSystem. When they aren't, or when the rename fails
+// with EXDEV, src is copied, preserving all of its attributes, then
+// removed.
+func Move(srcOS OperatingSystem, src string, dstOS OperatingSystem, dst string, opts ...CopyOption) error {
+	if srcOS == dstOS {
+		err := srcOS.Rename(src, dst)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+
+	all := []CopyOption{PreserveMode(), PreserveOwner(), PreserveTimes(), PreserveSymlinks()}
+	c := newCopier(srcOS, dstOS, append(all, opts...))
+	fi, err := c.stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = c.tree(src, dst)
+	} else {
+		err = c.copy(src, fi, dst)
+	}
+	if err != nil {
+		return err
+	}
+	return srcOS.RemoveAll(src)
+}
+
+func (c *copier) stat(name string) (os.FileInfo, error) {
+	if c.symlinks {
+		return c.src.Lstat(name)
+	}
+	return c.src.Stat(name)
+}
+
+func (c *copier) tree(src, dst string) error {
+	if c.src == c.dst {
+		inside, err := isWithin(c.src, src, dst)
+		if err != nil {
+			return err
+		}
+		if inside {
+			return &os.PathError{Op: "copy", Path: dst, Err: syscall.Errno(syscall.ELOOP)}
+		}
+	}
+
+	var opts []WalkOption
+	if !c.symlinks {
+		opts = append(opts, FollowSymlinks())
+	}
+
+	// directories get their attributes once their contents are copied,
+	// which would change their modification time, and may need write
+	// permission
+	type dir struct {
+		name string
+		fi   os.FileInfo
+	}
+	var dirs []dir
+	err := Walk(c.src, src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if !fi.IsDir() {
+			return c.copy(path, fi, target)
+		}
+		if err := c.dst.MkdirAll(target, fi.Mode().Perm()|0700); err != nil {
+			return err
+		}
+		dirs = append(dirs, dir{target, fi})
+		return nil
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if !c.mode && d.fi.Mode().Perm()&0700 != 0700 {
+			if err := c.dst.Chmod(d.name, d.fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if err := c.attrs(d.name, d.fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy copies the file src, whose FileInfo is fi, to dst.
+func (c *copier) copy(src string, fi os.FileInfo, dst string) error {
+	if c.sync {
+		if same, err := c.same(src, fi, dst); same || err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return c.copyLink(src, fi, dst)
+	case !fi.Mode().IsRegular():
+		return &os.PathError{Op: "copy", Path: src, Err: syscall.Errno(syscall.ENOTSUP)}
+	}
+
+	in, err := c.src.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// opening a symbolic link at dst would write through it, a directory
+	// is left for OpenFile to fail with EISDIR
+	if dfi, err := c.dst.Lstat(dst); err == nil && !dfi.Mode().IsRegular() && !dfi.IsDir() {
+		if err := c.dst.Remove(dst); err != nil {
+			return err
+		}
+	}
+	out, err := c.dst.OpenFile(dst, O_WRONLY|O_CREATE|O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return c.attrs(dst, fi)
+}
+
+func (c *copier) copyLink(src string, fi os.FileInfo, dst string) error {
+	target, err := c.src.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if _, err := c.dst.Lstat(dst); err == nil {
+		if err := c.dst.Remove(dst); err != nil {
+			return err
+		}
+	}
+	if err := c.dst.Symlink(target, dst); err != nil {
+		return err
+	}
+	return c.attrs(dst, fi)
+}
+
+// attrs gives dst the attributes of fi preserved by c.
+func (c *copier) attrs(dst string, fi os.FileInfo) error {
+	link := fi.Mode()&os.ModeSymlink != 0
+	if c.owner {
+		if st, ok := sysStat(fi); ok {
+			c.dst.Lchown(dst, st.uid, st.gid)
+		}
+	}
+	if link {
+		return nil // the mode and times of a link can't be changed
+	}
+
+	if c.mode {
+		mode := fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := c.dst.Chmod(dst, mode); err != nil {
+			return err
+		}
+	}
+	if c.times {
+		if err := c.dst.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// same returns whether dst is already a copy of src, whose FileInfo is fi.
+func (c *copier) same(src string, fi os.FileInfo, dst string) (bool, error) {
+	dfi, err := c.dst.Lstat(dst)
+	if err != nil || dfi.Mode().Type() != fi.Mode().Type() {
+		return false, nil
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := c.src.Readlink(src)
+		if err != nil {
+			return false, err
+		}
+		dtarget, err := c.dst.Readlink(dst)
+		return err == nil && target == dtarget, nil
+	}
+	if dfi.Size() != fi.Size() {
+		return false, nil
+	}
+	if !c.content {
+		return dfi.ModTime().Equal(fi.ModTime()), nil
+	}
+
+	sum, err := hashFile(c.src, src)
+	if err != nil {
+		return false, err
+	}
+	dsum, err := hashFile(c.dst, dst)
+	return err == nil && bytes.Equal(sum, dsum), nil
+}
+
+func hashFile(o OperatingSystem, name string) ([]byte, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// isWithin reports whether path is dir or lies under it on o, relative
+// paths being taken from the working directory of o.
+func isWithin(o OperatingSystem, dir, path string) (bool, error) {
+	abs := func(name string) (string, error) {
+		if filepath.IsAbs(name) {
+			return filepath.Clean(name), nil
+		}
+		wd, err := o.Getwd()
+		return filepath.Join(wd, name), err
+	}
+	dir, err := abs(dir)
+	if err != nil {
+		return false, err
+	}
+	if path, err = abs(path); err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
@@ -0,0 +1,201 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func copySource(t *testing.T) OperatingSystem {
+	t.Helper()
+
+	o := FakeOS()
+	o.MkdirAll("/src/bin", 0755)
+	o.MkdirAll("/src/secret", 0500)
+	WriteFile(o, "/src/README", []byte("hello"), 0644)
+	WriteFile(o, "/src/bin/run", []byte("#!/bin/sh"), 0755)
+	o.Chmod("/src/bin/run", 0755|os.ModeSetuid)
+	o.Symlink("bin/run", "/src/run")
+	o.Chown("/src/README", 1000, 1000)
+	o.Chtimes("/src/README", epoch, epoch)
+	return o
+}
+
+func treeNames(t *testing.T, o OperatingSystem, root string) []string {
+	t.Helper()
+
+	var names []string
+	err := Walk(o, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %s, err: %v", root, err)
+	}
+	return names
+}
+
+func Test_CopyFile(t *testing.T) {
+	src, dst := copySource(t), FakeOS()
+
+	if err := CopyFile(src, "/src/README", dst, "/tmp/README"); err != nil {
+		t.Fatalf("failed to copy, err: %v", err)
+	}
+	fi, _ := dst.Stat("/tmp/README")
+	if st, _ := sysStat(fi); fi.ModTime().Equal(epoch) || st.uid == 1000 {
+		t.Errorf("expected the times and owner not to be preserved by default")
+	}
+
+	err := CopyFile(src, "/src/README", dst, "/tmp/README", PreserveOwner(), PreserveTimes())
+	if err != nil {
+		t.Fatalf("failed to copy, err: %v", err)
+	}
+	fi, _ = dst.Stat("/tmp/README")
+	if st, _ := sysStat(fi); !fi.ModTime().Equal(epoch) || st.uid != 1000 {
+		t.Errorf("expected the times and owner to be preserved, got %v and %+v", fi.ModTime(), st)
+	}
+
+	// links are followed unless preserved
+	CopyFile(src, "/src/run", dst, "/tmp/run")
+	CopyFile(src, "/src/run", dst, "/tmp/link", PreserveSymlinks(), PreserveMode())
+	if got, _ := ReadFile(dst, "/tmp/run"); string(got) != "#!/bin/sh" {
+		t.Errorf("expected the link to be followed, got %q", got)
+	}
+	if target, err := dst.Readlink("/tmp/link"); target != "bin/run" {
+		t.Errorf("expected the link to be copied, err: %v", err)
+	}
+
+	if err := CopyFile(src, "/src/bin", dst, "/tmp/bin"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("expected EISDIR, err: %v", err)
+	}
+}
+
+func Test_CopyTree_DiskAndBack(t *testing.T) {
+	src, disk := copySource(t), DefaultOS()
+	dir := t.TempDir()
+
+	if err := CopyTree(src, "/src", disk, dir, PreserveSymlinks(), PreserveMode()); err != nil {
+		t.Fatalf("failed to materialize the fake, err: %v", err)
+	}
+	back := FakeOS()
+	if err := CopyTree(disk, dir, back, "/copy", PreserveSymlinks(), PreserveMode()); err != nil {
+		t.Fatalf("failed to seed a fake from disk, err: %v", err)
+	}
+
+	want := treeNames(t, src, "/src")
+	if got := treeNames(t, back, "/copy"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if fi, _ := back.Stat("/copy/secret"); fi.Mode().Perm() != 0500 {
+		t.Errorf("expected the directory mode to be kept, got %v", fi.Mode())
+	}
+	if fi, _ := back.Lstat("/copy/bin/run"); fi.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("expected the setuid bit to be kept, got %v", fi.Mode())
+	}
+}
+
+func Test_CopyTree_Loop(t *testing.T) {
+	src := copySource(t)
+	src.Symlink("/src", "/src/bin/up")
+
+	err := CopyTree(src, "/src", FakeOS(), "/dst")
+	if !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("expected ELOOP, err: %v", err)
+	}
+}
+
+func Test_CopyTree_IntoItself(t *testing.T) {
+	src := copySource(t)
+	src.Chdir("/src")
+
+	for _, dst := range []string{"/src", "/src/bin/copy", "bin/copy", "/src/../src/copy"} {
+		err := CopyTree(src, "/src", src, dst)
+		if !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("copying into %s: expected ELOOP, err: %v", dst, err)
+		}
+	}
+	if err := CopyTree(src, "/src", src, "/src-copy"); err != nil {
+		t.Errorf("failed to copy next to the tree, err: %v", err)
+	}
+}
+
+func Test_CopyTree_OverSymlink(t *testing.T) {
+	src, dst := copySource(t), FakeOS()
+	dst.MkdirAll("/dst", 0755)
+	dst.MkdirAll("/etc", 0755)
+	WriteFile(dst, "/etc/passwd", []byte("root"), 0644)
+	dst.Symlink("/etc/passwd", "/dst/README")
+
+	if err := CopyTree(src, "/src", dst, "/dst"); err != nil {
+		t.Fatalf("failed to copy, err: %v", err)
+	}
+	if got, _ := ReadFile(dst, "/etc/passwd"); string(got) != "root" {
+		t.Errorf("expected the link target to be left alone, got %q", got)
+	}
+	if fi, err := dst.Lstat("/dst/README"); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("expected the link to be replaced by a file, got %v, err: %v", fi, err)
+	}
+}
+
+func Test_Move(t *testing.T) {
+	m, root, data := newMountTable(t)
+	root.MkdirAll("/etc/app", 0755)
+	WriteFile(root, "/etc/app/app.conf", []byte("debug"), 0600)
+
+	// across mounts, the rename fails with EXDEV
+	if err := Move(m, "/etc/app", m, "/data/app"); err != nil {
+		t.Fatalf("failed to move, err: %v", err)
+	}
+	if _, err := root.Stat("/etc/app"); !os.IsNotExist(err) {
+		t.Errorf("expected the source to be removed, err: %v", err)
+	}
+	if fi, err := data.Stat("/app/app.conf"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the file to be moved with its mode, err: %v", err)
+	}
+
+	if err := Move(m, "/data/app", m, "/data/app.old"); err != nil {
+		t.Errorf("failed to rename, err: %v", err)
+	}
+	if err := Move(root, "/missing", data, "/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected ErrNotExist, err: %v", err)
+	}
+}
+
+func Test_SyncTree(t *testing.T) {
+	src, dst := copySource(t), FakeOS()
+	if err := SyncTree(src, "/src", dst, "/dst"); err != nil {
+		t.Fatalf("failed to sync, err: %v", err)
+	}
+
+	// the same size and time hide a change from the default comparison
+	WriteFile(dst, "/dst/README", []byte("HELLO"), 0644)
+	dst.Chtimes("/dst/README", epoch, epoch)
+	WriteFile(src, "/src/bin/run", []byte("#!/bin/bash"), 0755)
+	later := epoch.Add(time.Hour)
+	src.Chtimes("/src/bin/run", later, later)
+
+	if err := SyncTree(src, "/src", dst, "/dst"); err != nil {
+		t.Fatalf("failed to sync, err: %v", err)
+	}
+	if got, _ := ReadFile(dst, "/dst/bin/run"); string(got) != "#!/bin/bash" {
+		t.Errorf("expected the changed file to be copied, got %q", got)
+	}
+	if got, _ := ReadFile(dst, "/dst/README"); string(got) != "HELLO" {
+		t.Errorf("expected the file to be left alone, got %q", got)
+	}
+
+	if err := SyncTree(src, "/src", dst, "/dst", CompareContent()); err != nil {
+		t.Fatalf("failed to sync, err: %v", err)
+	}
+	if got, _ := ReadFile(dst, "/dst/README"); string(got) != "hello" {
+		t.Errorf("expected the contents to be compared, got %q", got)
+	}
+}
@@ -11,9 +11,9 @@ type defaultOS struct {
 
 func DefaultOS() OperatingSystem {
 	d := &defaultOS{
-		Stdin:  os.Stdin,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+		Stdin:  newDefaultFile(os.Stdin),
+		Stdout: newDefaultFile(os.Stdout),
+		Stderr: newDefaultFile(os.Stderr),
 	}
 	return d
 }
@@ -168,23 +168,27 @@ func (d *defaultOS) Truncate(name string, size int64) error {
 
 //
 func (d *defaultOS) Create(name string) (file File, err error) {
-	return os.Create(name)
+	f, err := os.Create(name)
+	return newDefaultFile(f), err
 }
 
 func (d *defaultOS) NewFile(fd uintptr, name string) File {
-	return os.NewFile(fd, name)
+	return newDefaultFile(os.NewFile(fd, name))
 }
 
 func (d *defaultOS) Open(name string) (file File, err error) {
-	return os.Open(name)
+	f, err := os.Open(name)
+	return newDefaultFile(f), err
 }
 
 func (d *defaultOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
-	return os.OpenFile(name, flag, perm)
+	f, err := os.OpenFile(name, flag, perm)
+	return newDefaultFile(f), err
 }
 
 func (d *defaultOS) Pipe() (r File, w File, err error) {
-	return os.Pipe()
+	rf, wf, err := os.Pipe()
+	return newDefaultFile(rf), newDefaultFile(wf), err
 }
 
 //
@@ -0,0 +1,33 @@
+package fs
+
+import "os"
+
+// defaultFile is an *os.File given the File methods os.File lacks.
+type defaultFile struct {
+	*os.File
+}
+
+// newDefaultFile wraps f, a nil f gives a nil File rather than a File
+// holding a nil pointer.
+func newDefaultFile(f *os.File) File {
+	if f == nil {
+		return nil
+	}
+	return &defaultFile{File: f}
+}
+
+func (f *defaultFile) Lock() error {
+	return f.flock(LOCK_EX)
+}
+
+func (f *defaultFile) RLock() error {
+	return f.flock(LOCK_SH)
+}
+
+func (f *defaultFile) TryLock() error {
+	return f.flock(LOCK_EX | LOCK_NB)
+}
+
+func (f *defaultFile) Unlock() error {
+	return f.flock(LOCK_UN)
+}
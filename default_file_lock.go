@@ -0,0 +1,72 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockRange locks the n bytes at off through fcntl, see lockRange for the
+// kind of lock used.
+func (f *defaultFile) LockRange(off, n int64, how int) error {
+	lk := &syscall.Flock_t{Whence: int16(SEEK_SET), Start: off, Len: n}
+	switch how &^ LOCK_NB {
+	case LOCK_SH:
+		lk.Type = syscall.F_RDLCK
+	case LOCK_EX:
+		lk.Type = syscall.F_WRLCK
+	case LOCK_UN:
+		lk.Type = syscall.F_UNLCK
+	default:
+		return &os.PathError{
+			Op:   "fcntl",
+			Path: f.Name(),
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
+	}
+
+	err := f.control(func(fd uintptr) error {
+		return lockRange(fd, lk, how&LOCK_NB == 0)
+	})
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		err = syscall.EWOULDBLOCK
+	}
+	if err != nil {
+		return &os.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+func (f *defaultFile) flock(how int) error {
+	err := f.control(func(fd uintptr) error {
+		return syscall.Flock(int(fd), how)
+	})
+	if err != nil {
+		return &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+// control runs op on the descriptor of f, again if it's interrupted by a
+// signal while waiting for a lock. f.Fd isn't used as it would make f
+// blocking.
+func (f *defaultFile) control(op func(fd uintptr) error) error {
+	rc, err := f.File.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var opErr error
+	err = rc.Control(func(fd uintptr) {
+		for {
+			if opErr = op(fd); opErr != syscall.EINTR {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,28 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockRange fails with ENOTSUP, files can only be locked on Linux, macOS
+// and the BSDs.
+func (f *defaultFile) LockRange(off, n int64, how int) error {
+	return &os.PathError{
+		Op:   "fcntl",
+		Path: f.Name(),
+		Err:  syscall.Errno(syscall.ENOTSUP),
+	}
+}
+
+// flock fails with ENOTSUP, files can only be locked on Linux, macOS and
+// the BSDs.
+func (f *defaultFile) flock(how int) error {
+	return &os.PathError{
+		Op:   "flock",
+		Path: f.Name(),
+		Err:  syscall.Errno(syscall.ENOTSUP),
+	}
+}
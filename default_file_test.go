@@ -0,0 +1,51 @@
+package fs
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_DefaultOS_Lock(t *testing.T) {
+	o := DefaultOS()
+	name := filepath.Join(t.TempDir(), "app.lock")
+	a, err := o.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := o.OpenFile(name, O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := a.Lock(); err != nil {
+		t.Fatalf("failed to lock, err: %v", err)
+	}
+	if err := b.TryLock(); !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK, err: %v", err)
+	}
+	a.Unlock()
+	if err := b.TryLock(); err != nil {
+		t.Errorf("failed to lock, err: %v", err)
+	}
+
+	if err := a.LockRange(0, 100, LOCK_EX); err != nil {
+		t.Fatalf("failed to lock the range, err: %v", err)
+	}
+
+	// elsewhere range locks are held by the process, so b doesn't conflict
+	err = b.LockRange(50, 10, LOCK_SH|LOCK_NB)
+	if runtime.GOOS == "linux" && !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK, err: %v", err)
+	}
+}
+
+func Test_DefaultOS_NilFile(t *testing.T) {
+	f, err := DefaultOS().Open(filepath.Join(t.TempDir(), "missing"))
+	if err == nil || f != nil {
+		t.Errorf("expected a nil File along with the error, got %#v, err: %v", f, err)
+	}
+}
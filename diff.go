@@ -0,0 +1,523 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChangeKind says how an entry differs between two trees.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota + 1
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Attr is a set of attributes of a file that may differ between two trees.
+type Attr uint32
+
+const (
+	AttrType    Attr = 1 << iota // regular file, directory, link...
+	AttrContent                  // of regular files
+	AttrMode                     // permissions and special bits
+	AttrOwner                    // user and group
+	AttrModTime
+	AttrTarget // of symbolic links
+)
+
+func (a Attr) String() string {
+	var attrs []string
+	for _, at := range []struct {
+		attr Attr
+		name string
+	}{
+		{AttrType, "type"},
+		{AttrContent, "content"},
+		{AttrMode, "mode"},
+		{AttrOwner, "owner"},
+		{AttrModTime, "mtime"},
+		{AttrTarget, "target"},
+	} {
+		if a&at.attr != 0 {
+			attrs = append(attrs, at.name)
+		}
+	}
+	return strings.Join(attrs, "|")
+}
+
+// Change is a single entry that differs between two trees.
+type Change struct {
+	Kind ChangeKind
+	Path string
+
+	// Attrs are the attributes that differ for a Modified entry, Diff sets
+	// them but OverlayOS.Diff doesn't.
+	Attrs Attr
+}
+
+func (c Change) String() string {
+	if c.Attrs != 0 {
+		return fmt.Sprintf("%v %s (%v)", c.Kind, c.Path, c.Attrs)
+	}
+	return c.Kind.String() + " " + c.Path
+}
+
+// DiffOption configures Diff.
+type DiffOption func(*differ)
+
+// IgnoreAttrs makes Diff ignore the given attributes, an entry differing
+// only by those isn't reported.
+func IgnoreAttrs(attrs Attr) DiffOption {
+	return func(d *differ) {
+		d.ignore |= attrs
+	}
+}
+
+type differ struct {
+	a, b         OperatingSystem
+	rootA, rootB string
+	ignore       Attr
+	changes      []Change
+}
+
+// Diff compares the tree rooted at rootA on a with the one rooted at rootB
+// on b, reporting what was added, removed or modified going from the
+// first to the second. Paths are relative to the roots and reported in
+// lexical order, the entries of an added or removed directory included.
+// Symbolic links aren't followed, their mode and modification time aren't
+// compared. Diff fails if neither root exists, or if an entry can't be
+// looked up, listed or read.
+func Diff(a, b OperatingSystem, rootA, rootB string, opts ...DiffOption) ([]Change, error) {
+	d := &differ{a: a, b: b, rootA: rootA, rootB: rootB}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.diff("."); err != nil {
+		return nil, err
+	}
+	return d.changes, nil
+}
+
+func (d *differ) diff(path string) error {
+	fa, errA := d.a.Lstat(filepath.Join(d.rootA, path))
+	fb, errB := d.b.Lstat(filepath.Join(d.rootB, path))
+	switch {
+	case errA != nil && !os.IsNotExist(errA):
+		return errA
+	case errB != nil && !os.IsNotExist(errB):
+		return errB
+	case errA != nil && errB != nil:
+		if path == "." {
+			return errA
+		}
+		return nil // removed from both while comparing
+	case errA != nil:
+		return d.all(Added, d.b, d.rootB, path, fb)
+	case errB != nil:
+		return d.all(Removed, d.a, d.rootA, path, fa)
+	}
+
+	attrs, err := d.compare(path, fa, fb)
+	if err != nil {
+		return err
+	}
+	if attrs &^= d.ignore; attrs != 0 {
+		d.changes = append(d.changes, Change{Kind: Modified, Path: path, Attrs: attrs})
+	}
+
+	switch {
+	case fa.IsDir() && fb.IsDir():
+		infosA, err := readDir(d.a, filepath.Join(d.rootA, path))
+		if err != nil {
+			return err
+		}
+		infosB, err := readDir(d.b, filepath.Join(d.rootB, path))
+		if err != nil {
+			return err
+		}
+		for _, name := range mergeNames(infosA, infosB) {
+			if err := d.diff(filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	case fa.IsDir():
+		return d.children(Removed, d.a, d.rootA, path)
+	case fb.IsDir():
+		return d.children(Added, d.b, d.rootB, path)
+	}
+	return nil
+}
+
+// all reports path, which only exists on o, and everything under it.
+func (d *differ) all(kind ChangeKind, o OperatingSystem, root, path string, fi os.FileInfo) error {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path})
+	if fi.IsDir() {
+		return d.children(kind, o, root, path)
+	}
+	return nil
+}
+
+func (d *differ) children(kind ChangeKind, o OperatingSystem, root, path string) error {
+	infos, err := readDir(o, filepath.Join(root, path))
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if err := d.all(kind, o, root, filepath.Join(path, fi.Name()), fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare returns the attributes differing between fa and fb, the
+// FileInfos of path in each tree.
+func (d *differ) compare(path string, fa, fb os.FileInfo) (Attr, error) {
+	if fa.Mode().Type() != fb.Mode().Type() {
+		return AttrType, nil
+	}
+
+	var attrs Attr
+	link := fa.Mode()&os.ModeSymlink != 0
+	if !link && fa.Mode() != fb.Mode() {
+		attrs |= AttrMode
+	}
+	if !link && !fa.ModTime().Equal(fb.ModTime()) {
+		attrs |= AttrModTime
+	}
+	sa, okA := sysStat(fa)
+	sb, okB := sysStat(fb)
+	if okA && okB && (sa.uid != sb.uid || sa.gid != sb.gid) {
+		attrs |= AttrOwner
+	}
+
+	switch {
+	case link && d.ignore&AttrTarget == 0:
+		ta, err := d.a.Readlink(filepath.Join(d.rootA, path))
+		if err != nil {
+			return 0, err
+		}
+		tb, err := d.b.Readlink(filepath.Join(d.rootB, path))
+		if err != nil {
+			return 0, err
+		}
+		if ta != tb {
+			attrs |= AttrTarget
+		}
+	case fa.Mode().IsRegular() && d.ignore&AttrContent == 0:
+		if fa.Size() != fb.Size() {
+			attrs |= AttrContent
+			break
+		}
+		same, err := sameContent(d.a, filepath.Join(d.rootA, path), d.b, filepath.Join(d.rootB, path))
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			attrs |= AttrContent
+		}
+	}
+	return attrs, nil
+}
+
+// mergeNames returns the names of a and b, both sorted, without duplicates.
+func mergeNames(a, b []os.FileInfo) []string {
+	var names []string
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].Name() < b[0].Name()):
+			names, a = append(names, a[0].Name()), a[1:]
+		case len(a) == 0 || b[0].Name() < a[0].Name():
+			names, b = append(names, b[0].Name()), b[1:]
+		default:
+			names, a, b = append(names, a[0].Name()), a[1:], b[1:]
+		}
+	}
+	return names
+}
+
+// FormatDiff renders changes, as returned by Diff, one per line. The
+// contents of the text files that were added, removed or modified follow
+// their line as a unified diff.
+func FormatDiff(a, b OperatingSystem, rootA, rootB string, changes []Change) string {
+	var buf strings.Builder
+	for _, c := range changes {
+		buf.WriteString(c.String())
+		buf.WriteByte('\n')
+		if c.Kind == Modified && c.Attrs&AttrContent == 0 {
+			continue
+		}
+
+		var old, new []byte
+		if c.Kind != Added {
+			if !isRegular(a, filepath.Join(rootA, c.Path)) {
+				continue
+			}
+			old, _ = ReadFile(a, filepath.Join(rootA, c.Path))
+		}
+		if c.Kind != Removed {
+			if !isRegular(b, filepath.Join(rootB, c.Path)) {
+				continue
+			}
+			new, _ = ReadFile(b, filepath.Join(rootB, c.Path))
+		}
+		buf.WriteString(UnifiedDiff(c.Path, old, new))
+	}
+	return buf.String()
+}
+
+func isRegular(o OperatingSystem, path string) bool {
+	fi, err := o.Lstat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// UnifiedDiff renders the changes going from old to new, the contents of
+// the file named name, as a unified diff with 3 lines of context. Binary
+// contents are only reported as differing.
+func UnifiedDiff(name string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		return fmt.Sprintf("Binary files a/%s and b/%s differ\n", name, name)
+	}
+
+	edits := diffLines(splitLines(old), splitLines(new))
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", name, name)
+	for _, h := range hunks(edits, 3) {
+		h.write(&buf, edits)
+	}
+	return buf.String()
+}
+
+// splitLines splits b into lines, each keeping its line feed.
+func splitLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		lines, b = append(lines, string(b[:i])), b[i:]
+	}
+	return lines
+}
+
+// lineEdit is a line of a diff: kept (' '), removed ('-') or added ('+').
+type lineEdit struct {
+	op   byte
+	line string
+}
+
+// maxEdits bounds the lines diffLines removes and adds, past which finding
+// the fewest edits takes too long and it replaces every line that differs.
+const maxEdits = 1000
+
+// diffLines returns the edits turning a into b, the fewest there are as
+// found by Myers' algorithm.
+func diffLines(a, b []string) []lineEdit {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]lineEdit, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	edits = append(edits, shortestEdits(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	return edits
+}
+
+// shortestEdits returns the fewest edits turning a into b, or replaces all
+// of a with b if there would be more than maxEdits of them.
+//
+// Going through the edit graph, where moving right removes a line of a,
+// down adds one of b and diagonally keeps a line both share, v[k] is how
+// far right the furthest path with d edits got on diagonal k = x-y. The v
+// of each d are kept to walk the path back once it reaches the end.
+func shortestEdits(a, b []string) []lineEdit {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int // v[-d:d+1] for each d
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1] // down
+			} else {
+				x = v[off+k-1] + 1 // right
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		if k := n - m; k >= -d && k <= d && v[off+k] >= n {
+			return backtrack(a, b, trace)
+		}
+	}
+
+	edits := make([]lineEdit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, lineEdit{'-', line})
+	}
+	for _, line := range b {
+		edits = append(edits, lineEdit{'+', line})
+	}
+	return edits
+}
+
+// backtrack returns the edits of the path reaching the end of a and b in
+// len(trace)-1 edits, see shortestEdits.
+func backtrack(a, b []string, trace [][]int) []lineEdit {
+	var edits []lineEdit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // indexed by k+d-1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		// the lines kept after the edit, then the edit itself
+		midX, midY := prevX+1, prevY
+		if prevK == k+1 {
+			midX, midY = prevX, prevY+1
+		}
+		for x > midX && y > midY {
+			edits = append(edits, lineEdit{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if prevK == k+1 {
+			edits = append(edits, lineEdit{'+', b[prevY]})
+		} else {
+			edits = append(edits, lineEdit{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		edits = append(edits, lineEdit{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// hunk is a range of edits, with the lines it starts at in each file.
+type hunk struct {
+	start, end     int // in the edits
+	lineA, lineB   int // 1-based
+	countA, countB int
+}
+
+// hunks groups the changed edits with context lines of context around
+// them, merging the groups whose context overlap.
+func hunks(edits []lineEdit, context int) []hunk {
+	var hs []hunk
+	for i, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		start, end := i-context, i+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+		if n := len(hs); n > 0 && start <= hs[n-1].end {
+			hs[n-1].end = end
+		} else {
+			hs = append(hs, hunk{start: start, end: end})
+		}
+	}
+
+	lineA, lineB, k := 1, 1, 0
+	for i, e := range edits {
+		if k == len(hs) {
+			break
+		}
+		h := &hs[k]
+		if i == h.start {
+			h.lineA, h.lineB = lineA, lineB
+		}
+		if e.op != '+' {
+			lineA++
+			if i >= h.start {
+				h.countA++
+			}
+		}
+		if e.op != '-' {
+			lineB++
+			if i >= h.start {
+				h.countB++
+			}
+		}
+		if i == h.end-1 {
+			k++
+		}
+	}
+	return hs
+}
+
+func (h hunk) write(buf *strings.Builder, edits []lineEdit) {
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.lineA, h.countA), hunkRange(h.lineB, h.countB))
+	for _, e := range edits[h.start:h.end] {
+		buf.WriteByte(e.op)
+		buf.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,262 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func diffTrees(t *testing.T) (a, b OperatingSystem) {
+	t.Helper()
+
+	a, b = FakeOS(), FakeOS()
+	for _, o := range []OperatingSystem{a, b} {
+		o.MkdirAll("/app/static", 0755)
+		WriteFile(o, "/app/main.go", []byte("package main\n\nfunc main() {\n}\n"), 0644)
+		WriteFile(o, "/app/README", []byte("readme\n"), 0644)
+		o.Symlink("main.go", "/app/link")
+		o.Chtimes("/app/README", epoch, epoch)
+	}
+
+	WriteFile(a, "/app/static/old.css", []byte("body {}\n"), 0644)
+	WriteFile(b, "/app/main.go", []byte("package main\n\nfunc main() {\n\tprintln()\n}\n"), 0644)
+	b.Chmod("/app/README", 0600)
+	b.Chown("/app/README", 1000, 1000)
+	b.Remove("/app/link")
+	b.Symlink("README", "/app/link")
+	b.MkdirAll("/app/static/img", 0755)
+	b.Create("/app/static/img/logo.png")
+	return a, b
+}
+
+func Test_Diff(t *testing.T) {
+	a, b := diffTrees(t)
+
+	got, err := Diff(a, b, "/app", "/app", IgnoreAttrs(AttrModTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Kind: Modified, Path: "README", Attrs: AttrMode | AttrOwner},
+		{Kind: Modified, Path: "link", Attrs: AttrTarget},
+		{Kind: Modified, Path: "main.go", Attrs: AttrContent},
+		{Kind: Added, Path: "static/img"},
+		{Kind: Added, Path: "static/img/logo.png"},
+		{Kind: Removed, Path: "static/old.css"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got, err := Diff(a, a, "/app", "/app"); err != nil || len(got) != 0 {
+		t.Errorf("expected a tree not to differ from itself, got %v, err: %v", got, err)
+	}
+
+	// a directory replaced by a file
+	b.RemoveAll("/app/static")
+	b.Create("/app/static")
+	got, err = Diff(a, b, "/app", "/app", IgnoreAttrs(AttrModTime|AttrContent|AttrMode|AttrOwner|AttrTarget))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Change{
+		{Kind: Modified, Path: "static", Attrs: AttrType},
+		{Kind: Removed, Path: "static/old.css"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+// failingOS fails op, Lstat or File.Readdir, with err.
+type failingOS struct {
+	OperatingSystem
+	op  string
+	err error
+}
+
+func (o *failingOS) Lstat(name string) (os.FileInfo, error) {
+	if o.op == "Lstat" {
+		return nil, o.err
+	}
+	return o.OperatingSystem.Lstat(name)
+}
+
+func (o *failingOS) Open(name string) (File, error) {
+	f, err := o.OperatingSystem.Open(name)
+	if err != nil || o.op != "File.Readdir" {
+		return f, err
+	}
+	return &failingFile{File: f, err: o.err}, nil
+}
+
+type failingFile struct {
+	File
+	err error
+}
+
+func (f *failingFile) Readdir(n int) ([]os.FileInfo, error) {
+	return nil, f.err
+}
+
+func Test_Diff_Errors(t *testing.T) {
+	a, b := diffTrees(t)
+	if _, err := Diff(a, b, "/missing", "/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected ENOENT comparing missing roots, err: %v", err)
+	}
+
+	eio := syscall.Errno(syscall.EIO)
+	for _, op := range []string{"Lstat", "File.Readdir"} {
+		faulty := &failingOS{OperatingSystem: b, op: op, err: eio}
+		if _, err := Diff(a, faulty, "/app", "/app"); !errors.Is(err, eio) {
+			t.Errorf("%s: expected EIO, err: %v", op, err)
+		}
+	}
+}
+
+func Test_FormatDiff(t *testing.T) {
+	a, b := diffTrees(t)
+
+	changes, err := Diff(a, b, "/app", "/app", IgnoreAttrs(AttrModTime|AttrOwner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"modified README (mode)",
+		"modified link (target)",
+		"modified main.go (content)",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -1,4 +1,5 @@",
+		" package main",
+		" ",
+		" func main() {",
+		"+\tprintln()",
+		" }",
+		"added static/img",
+		"added static/img/logo.png",
+		"removed static/old.css",
+		"--- a/static/old.css",
+		"+++ b/static/old.css",
+		"@@ -1 +0,0 @@",
+		"-body {}",
+		"",
+	}, "\n")
+	if got := FormatDiff(a, b, "/app", "/app", changes); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_UnifiedDiff(t *testing.T) {
+	var old, new []string
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i)
+		old = append(old, line)
+		switch i {
+		case 2:
+			new = append(new, "two")
+		case 18:
+		default:
+			new = append(new, line)
+		}
+	}
+	new = append(new, "end")
+
+	got := UnifiedDiff("f", []byte(strings.Join(old, "\n")+"\n"), []byte(strings.Join(new, "\n")))
+	want := strings.Join([]string{
+		"--- a/f",
+		"+++ b/f",
+		"@@ -1,5 +1,5 @@",
+		" x",
+		"-xx",
+		"+two",
+		" xxx",
+		" xxxx",
+		" xxxxx",
+		"@@ -15,6 +15,6 @@",
+		" " + strings.Repeat("x", 15),
+		" " + strings.Repeat("x", 16),
+		" " + strings.Repeat("x", 17),
+		"-" + strings.Repeat("x", 18),
+		" " + strings.Repeat("x", 19),
+		" " + strings.Repeat("x", 20),
+		"+end",
+		`\ No newline at end of file`,
+		"",
+	}, "\n")
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	if got := UnifiedDiff("bin", []byte{0, 1}, []byte{0, 2}); got != "Binary files a/bin and b/bin differ\n" {
+		t.Errorf("unexpected binary diff %q", got)
+	}
+}
+
+func Test_diffLines(t *testing.T) {
+	// lcs is the length of the longest common subsequence of a and b
+	lcs := func(a, b []string) int {
+		prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+		for i := range a {
+			for j := range b {
+				switch {
+				case a[i] == b[j]:
+					cur[j+1] = prev[j] + 1
+				case prev[j+1] >= cur[j]:
+					cur[j+1] = prev[j+1]
+				default:
+					cur[j+1] = cur[j]
+				}
+			}
+			prev, cur = cur, prev
+		}
+		return prev[len(b)]
+	}
+
+	r := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		l := make([]string, r.Intn(30))
+		for i := range l {
+			l[i] = string(rune('a' + r.Intn(4)))
+		}
+		return l
+	}
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		var gotA, gotB []string
+		edited := 0
+		for _, e := range diffLines(a, b) {
+			if e.op != '+' {
+				gotA = append(gotA, e.line)
+			}
+			if e.op != '-' {
+				gotB = append(gotB, e.line)
+			}
+			if e.op != ' ' {
+				edited++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("the edits of %q to %q don't apply", a, b)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edited != want {
+			t.Errorf("%q to %q: %d edits, expected %d", a, b, edited, want)
+		}
+	}
+
+	// past maxEdits, the lines that differ are replaced
+	var a, b []string
+	for i := 0; i < maxEdits; i++ {
+		a, b = append(a, fmt.Sprint("a", i)), append(b, fmt.Sprint("b", i))
+	}
+	a, b = append(a, "same"), append(b, "same")
+	edits := diffLines(a, b)
+	if len(edits) != 2*maxEdits+1 || edits[0].op != '-' || edits[maxEdits].op != '+' || edits[2*maxEdits].op != ' ' {
+		t.Errorf("expected %d lines to be replaced, got %d edits", maxEdits, len(edits))
+	}
+}
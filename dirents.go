@@ -0,0 +1,33 @@
+package fs
+
+import (
+	"io"
+	"os"
+)
+
+// nextDirents pops the next n entries off of *dirents following the rules
+// of File.Readdir: n <= 0 returns everything left, n > 0 returns io.EOF
+// once there is nothing left.
+func nextDirents(dirents *[]os.FileInfo, n int) ([]os.FileInfo, error) {
+	count := n
+	if count <= 0 || count > len(*dirents) {
+		count = len(*dirents)
+	}
+
+	fi := (*dirents)[:count]
+	*dirents = (*dirents)[count:]
+	if len(fi) == 0 && n > 0 {
+		return fi, io.EOF
+	}
+	return fi, nil
+}
+
+// direntNames returns the names of fis, for implementing Readdirnames on
+// top of Readdir.
+func direntNames(fis []os.FileInfo, err error) ([]string, error) {
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
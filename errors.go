@@ -0,0 +1,24 @@
+package fs
+
+import "os"
+
+// renameErr replaces the path in a *os.PathError with name, for the
+// wrappers reporting errors under the paths their callers used.
+func renameErr(err error, name string) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return &os.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+// renameLinkErr replaces the paths in a *os.LinkError with oldname and
+// newname, turning a *os.PathError into one along the way.
+func renameLinkErr(err error, oldname, newname string) error {
+	switch e := err.(type) {
+	case *os.LinkError:
+		return &os.LinkError{Op: e.Op, Old: oldname, New: newname, Err: e.Err}
+	case *os.PathError:
+		return &os.LinkError{Op: e.Op, Old: oldname, New: newname, Err: e.Err}
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExpvarMetrics is a Metrics exporting what it's given through expvar, as
+// a map holding:
+//
+//	calls          calls by operation
+//	bytes_read     bytes read by operation
+//	bytes_written  bytes written by operation
+//	errors         errors by errno
+//	latency        latency histograms by operation
+//	prefix_latency latency histograms by path prefix
+type ExpvarMetrics struct {
+	m *expvar.Map
+
+	calls, read, written, errors *expvar.Map
+	latency, prefixLatency       *expvar.Map
+
+	// lock serializes the creation of histograms
+	lock sync.Mutex
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics published under name, like
+// expvar.NewMap it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	e := &ExpvarMetrics{
+		m:             expvar.NewMap(name),
+		calls:         new(expvar.Map).Init(),
+		read:          new(expvar.Map).Init(),
+		written:       new(expvar.Map).Init(),
+		errors:        new(expvar.Map).Init(),
+		latency:       new(expvar.Map).Init(),
+		prefixLatency: new(expvar.Map).Init(),
+	}
+	e.m.Set("calls", e.calls)
+	e.m.Set("bytes_read", e.read)
+	e.m.Set("bytes_written", e.written)
+	e.m.Set("errors", e.errors)
+	e.m.Set("latency", e.latency)
+	e.m.Set("prefix_latency", e.prefixLatency)
+	return e
+}
+
+// Map returns the map published.
+func (e *ExpvarMetrics) Map() *expvar.Map {
+	return e.m
+}
+
+func (e *ExpvarMetrics) CountCall(op string) {
+	e.calls.Add(op, 1)
+}
+
+func (e *ExpvarMetrics) CountBytes(op string, read bool, n int) {
+	if read {
+		e.read.Add(op, int64(n))
+	} else {
+		e.written.Add(op, int64(n))
+	}
+}
+
+func (e *ExpvarMetrics) CountError(op, errno string) {
+	e.errors.Add(errno, 1)
+}
+
+func (e *ExpvarMetrics) ObserveLatency(op, prefix string, d time.Duration) {
+	e.histogram(e.latency, op).observe(d)
+	if prefix != "" {
+		e.histogram(e.prefixLatency, prefix).observe(d)
+	}
+}
+
+func (e *ExpvarMetrics) histogram(m *expvar.Map, key string) *histogram {
+	if h, ok := m.Get(key).(*histogram); ok {
+		return h
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if h, ok := m.Get(key).(*histogram); ok {
+		return h
+	}
+	h := &histogram{}
+	m.Set(key, h)
+	return h
+}
+
+// histogramBounds are the upper bounds of the buckets of a histogram, the
+// last bucket holds everything slower.
+var histogramBounds = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// histogram is an expvar.Var counting latencies into histogramBounds.
+type histogram struct {
+	lock    sync.Mutex
+	count   int64
+	sum     time.Duration
+	buckets [7]int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+
+	h.lock.Lock()
+	h.count++
+	h.sum += d
+	h.buckets[i]++
+	h.lock.Unlock()
+}
+
+// String returns the histogram as JSON, e.g.
+//
+//	{"count": 3, "sum_ns": 1200, "buckets": {"10µs": 3, "100µs": 0, ...}}
+func (h *histogram) String() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	buckets := make([]string, len(h.buckets))
+	for i, n := range h.buckets {
+		bound := "+Inf"
+		if i < len(histogramBounds) {
+			bound = histogramBounds[i].String()
+		}
+		buckets[i] = fmt.Sprintf("%q: %d", bound, n)
+	}
+	return fmt.Sprintf(`{"count": %d, "sum_ns": %d, "buckets": {%s}}`,
+		h.count, int64(h.sum), strings.Join(buckets, ", "))
+}
@@ -1,10 +1,10 @@
 package fs
 
 import (
-	"errors"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,13 +16,25 @@ var (
 	host = "fs-" + strconv.FormatInt(rand.Int63(), 10)
 )
 
+// fakeOS is the OperatingSystem returned by FakeOS. It's safe for
+// concurrent use, its locks being taken in this order:
+//
+//   - the lock of an open file (fakeHandle), guarding its offset
+//   - lock, guarding the names of the files and the advisory locks, and
+//     the copying of the files shared with clones or snapshots (see own)
+//   - the locks of the inodes (fakeFile), by inode number, guarding their
+//     attributes and contents
+//   - the lock of the watches
+//
+// so that reading or writing an open file only locks its inode.
 type fakeOS struct {
 	lock                  *sync.Mutex
 	envLock               *sync.RWMutex
 	Stdin, Stdout, Stderr File
 	envVars               map[string]string
-	files                 map[string]*fakeFile
+	names                 *fakeNames // of the files, see fakeNames
 	cwd                   string
+	nextFd                int
 
 	// ??? where should this go?
 	tmpDir string
@@ -36,111 +48,404 @@ type fakeOS struct {
 	// other info
 	pagesize  int
 	pid, ppid int
+
+	// watches are told about every change, see Watch
+	watches watchList
+
+	// unlocked is signaled whenever a file lock is released, see Lock
+	unlocked *sync.Cond
+
+	// the generation of the files d may change in place, read atomically,
+	// and the view its open files follow their copies in, see own
+	gen  uint64
+	view *fakeView
+
+	// simulated time, see WithClock and WithLatency
+	clock               Clock
+	latencies           map[string]Latency
+	readRate, writeRate int64
+	randLock            sync.Mutex
+	rand                *rand.Rand
+
+	// the directory files are loaded from, see WithBacking, and whether
+	// it's shared with clones or snapshots, see ownBacking
+	backing       *backing
+	backingShared bool
+
+	// switches between goroutines at each call, see WithScheduler
+	sched *Scheduler
+}
+
+// FakeOption configures a FakeOS.
+type FakeOption func(*fakeOS)
+
+// WithClock makes a FakeOS take the time from c, and let it pass on c when
+// simulating latency. The real clock is used by default.
+func WithClock(c Clock) FakeOption {
+	return func(d *fakeOS) {
+		d.clock = c
+	}
 }
 
-func FakeOS() OperatingSystem {
-	tmpDir := string(filepath.Separator) + "tmp"
+// WithSeed seeds the random source a FakeOS draws from, so that runs can
+// be reproduced. The seed is 1 by default.
+func WithSeed(seed int64) FakeOption {
+	return func(d *fakeOS) {
+		d.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+func FakeOS(opts ...FakeOption) OperatingSystem {
+	var (
+		root   = string(filepath.Separator)
+		tmpDir = root + "tmp"
+
+		// TODO(ttacon): better values for these?
+		uid = 501 // no idea what a good value for this is
+		// maybe grab the real one?
+		gid = 20 //  this is staff on macs? better value?
+	)
 	d := &fakeOS{
 		lock:    new(sync.Mutex),
 		envLock: new(sync.RWMutex),
-		Stdin:   os.Stdin,
-		Stdout:  os.Stdout,
-		Stderr:  os.Stderr,
+		Stdin:   newDefaultFile(os.Stdin),
+		Stdout:  newDefaultFile(os.Stdout),
+		Stderr:  newDefaultFile(os.Stderr),
 		envVars: map[string]string{},
-		files: map[string]*fakeFile{
-			// TODO(ttacon): add cwd file?
-			tmpDir: &fakeFile{ /*TODO(ttacon): add info*/ },
-		},
-		tmpDir: tmpDir,
+		names:   newNames(),
+		gen:     newGen(),
+		view:    &fakeView{},
+		cwd:     root,
+		nextFd:  3,
+		tmpDir:  tmpDir,
 
-		// TODO(ttacon): better values for these?
-		uid: 501, // no idea what a good value for this is
-		// maybe grab the real one?
-		gid: 20, //  this is staff on macs? better value?
+		uid: uid,
+		gid: gid,
 
 		// TODO(ttacon): prepopulate with values?
 		groups: map[int][]int{
 			501: []int{20},
 		},
 
+		pagesize: 4096,
+
 		// interestingly, for any go program pid = ppid +3
 		pid:  18012,
 		ppid: 18009,
+
+		clock:     RealClock(),
+		latencies: map[string]Latency{},
+		rand:      rand.New(rand.NewSource(1)),
+	}
+	d.unlocked = sync.NewCond(d.lock)
+	for _, opt := range opts {
+		opt(d)
+	}
+	var (
+		now = d.now()
+		top = newDir(0755, 0, 0, now)
+		tmp = newDir(0777, 0, 0, now)
+	)
+	tmp.mode |= os.ModeSticky
+	d.add(root, top)
+	d.add(tmpDir, tmp)
+	if d.backing != nil {
+		d.attach(top)
 	}
 	return d
 }
 
+// now returns the time on d's clock.
+func (d *fakeOS) now() time.Time {
+	return d.clock.Now()
+}
+
+// abs returns the clean absolute version of name, relative names are
+// resolved against the current working directory. Must be called with
+// d.lock held.
+func (d *fakeOS) abs(name string) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(d.cwd, name)
+	}
+	return filepath.Clean(name)
+}
+
+// resolve returns the path name refers to once all the symlinks in it have
+// been followed, the last element is only followed if follow is set. The
+// path returned doesn't necessarily exist. Must be called with d.lock held.
+func (d *fakeOS) resolve(op, name string, follow bool) (string, error) {
+	var (
+		resolved = string(filepath.Separator)
+		pending  = strings.Split(d.abs(name), string(filepath.Separator))
+		links    = 0
+	)
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		parent, _ := d.names.get(resolved)
+		if parent != nil && !parent.isDir {
+			return "", &os.PathError{
+				Op:   op,
+				Path: name,
+				Err:  syscall.Errno(syscall.ENOTDIR),
+			}
+		}
+
+		if err := d.load(resolved, elem); err != nil {
+			return "", backingErr(op, name, err)
+		}
+		next := filepath.Join(resolved, elem)
+		f, ok := d.names.get(next)
+		if !ok || !f.isLink || (len(pending) == 0 && !follow) {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{
+				Op:   op,
+				Path: name,
+				Err:  syscall.Errno(syscall.ELOOP),
+			}
+		}
+		if filepath.IsAbs(f.pointsTo) {
+			resolved = string(filepath.Separator)
+		}
+		pending = append(
+			strings.Split(f.pointsTo, string(filepath.Separator)),
+			pending...)
+	}
+	return resolved, nil
+}
+
+// lookup resolves name and returns the file it refers to. Must be called with
+// d.lock held.
+func (d *fakeOS) lookup(op, name string, follow bool) (string, *fakeFile, error) {
+	path, err := d.resolve(op, name, follow)
+	if err != nil {
+		return "", nil, err
+	}
+
+	f, ok := d.names.get(path)
+	if !ok {
+		return "", nil, &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.ENOENT),
+		}
+	}
+	return path, f, nil
+}
+
+// parentDir checks that the directory path would live in exists. Must be
+// called with d.lock held.
+func (d *fakeOS) parentDir(op, name, path string) error {
+	parent, ok := d.names.get(filepath.Dir(path))
+	if !ok {
+		return &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.ENOENT),
+		}
+	}
+	if !parent.isDir {
+		return &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.ENOTDIR),
+		}
+	}
+	return nil
+}
+
+// children returns the sorted paths of the entries in the directory at
+// path, failing if they can't be loaded from the backing directory. Must be
+// called with d.lock held.
+func (d *fakeOS) children(path string) ([]string, error) {
+	if err := d.list(path); err != nil {
+		return nil, err
+	}
+
+	var children []string
+	d.names.each(func(k string, _ *fakeFile) {
+		if k != path && filepath.Dir(k) == path {
+			children = append(children, k)
+		}
+	})
+	sort.Strings(children)
+	return children, nil
+}
+
+// add gives f the name path, f being new or owned by d (see own). Must be
+// called with d.lock held.
+func (d *fakeOS) add(path string, f *fakeFile) {
+	d.names.set(path, f)
+	f.lock.Lock()
+	if f.gen == 0 {
+		f.gen = d.generation()
+	}
+	if f.path == "" {
+		f.path = path
+	}
+	f.lock.Unlock()
+}
+
+// forget makes the files that were named by removed, names already taken
+// out of d.names, report their changes under another of their names if
+// they have one left. Must be called with d.lock held.
+func (d *fakeOS) forget(removed map[string]*fakeFile) {
+	var (
+		renamed = map[*fakeFile]string{}
+		linked  = false
+	)
+	for path, f := range removed {
+		if f.path == path {
+			renamed[f] = ""
+			linked = linked || f.linked
+		}
+	}
+	if len(renamed) == 0 {
+		return
+	}
+	if linked {
+		d.names.each(func(path string, f *fakeFile) {
+			if name, ok := renamed[f]; ok && (name == "" || path < name) {
+				renamed[f] = path
+			}
+		})
+	}
+	for f, path := range renamed {
+		f = d.own(d.view, f)
+		f.lock.Lock()
+		f.path = path
+		f.lock.Unlock()
+	}
+}
+
+// descendants returns the paths of everything below path. Must be called
+// with d.lock held.
+func (d *fakeOS) descendants(path string) []string {
+	prefix := path + string(filepath.Separator)
+	if path == string(filepath.Separator) {
+		prefix = path
+	}
+
+	var found []string
+	d.names.each(func(k string, _ *fakeFile) {
+		if k != path && strings.HasPrefix(k, prefix) {
+			found = append(found, k)
+		}
+	})
+	sort.Strings(found)
+	return found
+}
+
+// linkErr builds the *os.LinkError returned by calls taking two paths, err
+// may be an errno or the *os.PathError returned by one of the helpers above.
+func linkErr(op, oldname, newname string, err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return &os.LinkError{
+		Op:  op,
+		Old: oldname,
+		New: newname,
+		Err: err,
+	}
+}
+
 func (d *fakeOS) Chdir(dir string) error {
+	d.delay("Chdir")
 	d.lock.Lock()
-	if _, ok := d.files[dir]; !ok {
+	path, f, err := d.lookup("chdir", dir, true)
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+	if !f.isDir {
 		d.lock.Unlock()
 		return &os.PathError{
 			Op:   "chdir",
 			Path: dir,
-			Err:  syscall.Errno(syscall.ENOENT),
+			Err:  syscall.Errno(syscall.ENOTDIR),
 		}
 	}
 
-	d.cwd = dir
+	d.cwd = path
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Chmod(name string, mode os.FileMode) error {
+	d.delay("Chmod")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("chmod", name, true)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "chmod",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return err
 	}
-	f.mode = mode
+	f = d.own(d.view, f)
+	f.lock.Lock()
+	f.mode = f.mode&os.ModeType | mode&^os.ModeType
+	f.change = d.now()
+	d.notify(path, EventChmod)
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Chown(name string, uid, gid int) error {
+	d.delay("Chown")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("chown", name, true)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "chmod",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return err
 	}
+	f = d.own(d.view, f)
 
+	f.lock.Lock()
 	f.uid, f.gid = uid, gid
+	f.change = d.now()
+	d.notify(path, EventChmod)
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	d.delay("Chtimes")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("chtimes", name, true)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "chmod",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return err
 	}
+	f = d.own(d.view, f)
 
+	f.lock.Lock()
 	f.access, f.modify = atime, mtime
+	f.change = d.now()
+	d.notify(path, EventChmod)
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return nil
 }
@@ -224,6 +529,8 @@ func (d *fakeOS) Getuid() int {
 
 func (d *fakeOS) Getwd() (dir string, err error) {
 	// is this err non-nil on permission switching induced issues?
+	d.lock.Lock()
+	defer d.lock.Unlock()
 	return d.cwd, nil
 }
 
@@ -248,126 +555,157 @@ func (d *fakeOS) IsPermission(err error) bool {
 }
 
 func (d *fakeOS) Lchown(name string, uid, gid int) error {
+	d.delay("Lchown")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("lchown", name, false)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "chmod",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return err
 	}
+	f = d.own(d.view, f)
 
+	f.lock.Lock()
 	f.uid, f.gid = uid, gid
+	f.change = d.now()
+	d.notify(path, EventChmod)
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Link(oldname, newname string) error {
+	d.delay("Link")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[oldname]
+	oldPath, err := d.resolve("link", oldname, false)
+	if err != nil {
+		d.lock.Unlock()
+		return linkErr("link", oldname, newname, err)
+	}
+	f, ok := d.names.get(oldPath)
 	if !ok {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "link",
-			Path: oldname,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return linkErr("link", oldname, newname, syscall.Errno(syscall.ENOENT))
+	}
+	if f.isDir {
+		d.lock.Unlock()
+		return linkErr("link", oldname, newname, syscall.Errno(syscall.EPERM))
 	}
 
-	if _, ok := d.files[newname]; !ok {
+	newPath, err := d.resolve("link", newname, false)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "link",
-			Path: newname,
-			Err:  syscall.Errno(syscall.EEXIST),
-		}
+		return linkErr("link", oldname, newname, err)
+	}
+	if _, ok := d.names.get(newPath); ok {
+		d.lock.Unlock()
+		return linkErr("link", oldname, newname, syscall.Errno(syscall.EEXIST))
+	}
+	if err := d.parentDir("link", newname, newPath); err != nil {
+		d.lock.Unlock()
+		return linkErr("link", oldname, newname, err)
 	}
 
-	d.files[newname] = f
+	f = d.own(d.view, f)
+	d.names.set(newPath, f)
+	f.lock.Lock()
+	f.change = d.now()
+	f.linked = true
+	if f.path == "" {
+		f.path = newPath
+	}
+	f.lock.Unlock()
+	d.notify(newPath, EventCreate)
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Mkdir(name string, perm os.FileMode) error {
-	base := filepath.Dir(name)
+	d.delay("Mkdir")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[base]
-	if !ok {
+	path, err := d.resolve("mkdir", name, false)
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+	if _, ok := d.names.get(path); ok {
 		d.lock.Unlock()
 		return &os.PathError{
 			Op:   "mkdir",
-			Path: base,
-			Err:  syscall.Errno(syscall.ENOENT),
+			Path: name,
+			Err:  syscall.Errno(syscall.EEXIST),
 		}
 	}
-
-	now := time.Now()
-	d.files[name] = &fakeFile{
-		access: now,
-		modify: now,
-		change: now,
-		isDir:  true,
-		mode:   os.ModeDir,
-		info:   nil, // TODO(ttacon): do it
-		uid:    f.uid,
-		gid:    f.gid,
+	if err := d.parentDir("mkdir", name, path); err != nil {
+		d.lock.Unlock()
+		return err
 	}
+
+	d.add(path, newDir(perm, d.uid, d.gid, d.now()))
+	d.notify(path, EventCreate)
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) MkdirAll(path string, perm os.FileMode) error {
-	pieces := filepath.SplitList(path)
+	d.delay("MkdirAll")
 	d.lock.Lock()
-	curr := string(filepath.Separator)
-	now := time.Now()
+	var (
+		curr   = string(filepath.Separator)
+		pieces = strings.Split(d.abs(path), string(filepath.Separator))
+	)
 	for _, piece := range pieces {
-		curr = filepath.Join(curr, piece)
-		if _, ok := d.files[curr]; ok {
+		if piece == "" {
 			continue
 		}
-		d.files[curr] = &fakeFile{
-			access: now,
-			modify: now,
-			change: now,
-			isDir:  true,
-			mode:   perm,
-			info:   nil, // TODO(ttaco): do it
-			uid:    d.uid,
-			gid:    d.gid,
+
+		next, f, err := d.lookup("mkdir", filepath.Join(curr, piece), true)
+		if err == nil {
+			if !f.isDir {
+				d.lock.Unlock()
+				return &os.PathError{
+					Op:   "mkdir",
+					Path: path,
+					Err:  syscall.Errno(syscall.ENOTDIR),
+				}
+			}
+			curr = next
+			continue
+		}
+		if next, err = d.resolve("mkdir", filepath.Join(curr, piece), true); err != nil {
+			d.lock.Unlock()
+			return err
 		}
+
+		d.add(next, newDir(perm, d.uid, d.gid, d.now()))
+		d.notify(next, EventCreate)
+		curr = next
 	}
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Readlink(name string) (string, error) {
+	d.delay("Readlink")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	_, f, err := d.lookup("readlink", name, false)
+	if err != nil {
 		d.lock.Unlock()
-		return "", &os.PathError{
-			Op:   "readlink",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return "", err
 	}
 
-	if f.pointsTo == "" {
+	if !f.isLink {
 		d.lock.Unlock()
 		return "", &os.PathError{
 			Op:   "readlink",
@@ -382,66 +720,173 @@ func (d *fakeOS) Readlink(name string) (string, error) {
 }
 
 func (d *fakeOS) Remove(name string) error {
+	d.delay("Remove")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	_, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("remove", name, false)
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+
+	if f.isDir {
+		children, err := d.children(path)
+		if err != nil {
+			d.lock.Unlock()
+			return backingErr("remove", name, err)
+		}
+		if len(children) > 0 {
+			d.lock.Unlock()
+			return &os.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  syscall.Errno(syscall.ENOTEMPTY),
+			}
+		}
+	}
+	if path == string(filepath.Separator) {
 		d.lock.Unlock()
 		return &os.PathError{
 			Op:   "remove",
 			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
+			Err:  syscall.Errno(syscall.EBUSY),
 		}
 	}
 
-	delete(d.files, name)
+	d.names.remove(path)
+	d.forget(map[string]*fakeFile{path: f})
+	d.notify(path, EventRemove)
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) RemoveAll(path string) error {
-	// TODO(ttacon): make this not so lazy - will require changing how "files"
-	// are scored
-	path = strings.TrimSuffix(path, "/")
-	for k, _ := range d.files {
-		if strings.HasPrefix(k, path) {
-			delete(d.files, k)
-		}
+	d.delay("RemoveAll")
+	d.lock.Lock()
+	resolved, err := d.resolve("unlinkat", path, false)
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+
+	removed := map[string]*fakeFile{}
+	descendants := d.descendants(resolved)
+	for i := len(descendants) - 1; i >= 0; i-- {
+		removed[descendants[i]], _ = d.names.get(descendants[i])
+		d.names.remove(descendants[i])
+		d.notify(descendants[i], EventRemove)
+	}
+	if f, ok := d.names.get(resolved); ok && resolved != string(filepath.Separator) {
+		removed[resolved] = f
+		d.names.remove(resolved)
+		d.notify(resolved, EventRemove)
 	}
+	d.forget(removed)
+	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Rename(oldname, newname string) error {
+	d.delay("Rename")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[oldname]
-	if !ok {
+	oldPath, f, err := d.lookup("rename", oldname, false)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "rename",
-			Path: oldname,
-			Err:  syscall.Errno(syscall.ENOENT),
+		return linkErr("rename", oldname, newname, err)
+	}
+	newPath, err := d.resolve("rename", newname, false)
+	if err != nil {
+		d.lock.Unlock()
+		return linkErr("rename", oldname, newname, err)
+	}
+	if oldPath == newPath {
+		d.lock.Unlock()
+		return nil
+	}
+	if err := d.parentDir("rename", newname, newPath); err != nil {
+		d.lock.Unlock()
+		return linkErr("rename", oldname, newname, err)
+	}
+	if f.isDir && strings.HasPrefix(newPath, oldPath+string(filepath.Separator)) {
+		d.lock.Unlock()
+		return linkErr("rename", oldname, newname, syscall.Errno(syscall.EINVAL))
+	}
+
+	target, ok := d.names.get(newPath)
+	if ok {
+		switch {
+		case f.isDir && !target.isDir:
+			d.lock.Unlock()
+			return linkErr("rename", oldname, newname, syscall.Errno(syscall.ENOTDIR))
+		case !f.isDir && target.isDir:
+			d.lock.Unlock()
+			return linkErr("rename", oldname, newname, syscall.Errno(syscall.EISDIR))
+		case target.isDir:
+			children, err := d.children(newPath)
+			if err == nil && len(children) > 0 {
+				err = syscall.Errno(syscall.ENOTEMPTY)
+			}
+			if err != nil {
+				d.lock.Unlock()
+				return linkErr("rename", oldname, newname, err)
+			}
 		}
 	}
 
-	delete(d.files, oldname)
-	d.files[newname] = f
+	// move everything below a directory along with it
+	for _, k := range d.descendants(oldPath) {
+		moved := newPath + strings.TrimPrefix(k, oldPath)
+		g, _ := d.names.get(k)
+		if g.path == k {
+			g = d.own(d.view, g)
+			g.lock.Lock()
+			g.path = moved
+			g.lock.Unlock()
+		}
+		d.names.set(moved, g)
+		d.names.remove(k)
+	}
+	f = d.own(d.view, f)
+	if target != nil {
+		target = d.own(d.view, target)
+	}
+	d.names.remove(oldPath)
+	d.names.set(newPath, f)
+
+	// the file replaced loses a name, which changes it too
+	unlock := lockFiles(f, target)
+	now := d.now()
+	f.change = now
+	if f.path == oldPath {
+		f.path = newPath
+	}
+	if target != nil {
+		target.change = now
+	}
+	unlock()
+	if target != nil {
+		d.forget(map[string]*fakeFile{newPath: target})
+	}
+	d.notify(oldPath, EventRename)
+	d.notify(newPath, EventCreate)
 
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) SameFile(fi1, fi2 os.FileInfo) bool {
-	ff1, ok1 := fi1.(*fakeFile)
-	ff2, ok2 := fi2.(*fakeFile)
+	ff1, ok1 := fi1.(*fakeFileInfo)
+	ff2, ok2 := fi2.(*fakeFileInfo)
 	if !ok1 || !ok2 {
 		return false
 	}
-	return ff1 == ff2
+	// the same inode may have been copied, see own
+	return ff1.file.ino == ff2.file.ino
 }
 
 func (d *fakeOS) Setenv(key, value string) error {
@@ -452,44 +897,30 @@ func (d *fakeOS) Setenv(key, value string) error {
 }
 
 func (d *fakeOS) Symlink(oldname, newname string) error {
+	d.delay("Symlink")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[oldname]
-	if !ok {
+	path, err := d.resolve("symlink", newname, false)
+	if err != nil {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "symlink",
-			Path: oldname,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return linkErr("symlink", oldname, newname, err)
 	}
-
-	// TODO(ttacon): this needs to be able to differentiate between
-	// hard and soft links (Link() vs Symlink())
-	if _, ok := d.files[newname]; !ok {
+	if _, ok := d.names.get(path); ok {
 		d.lock.Unlock()
-		return &os.PathError{
-			Op:   "symlink",
-			Path: newname,
-			Err:  syscall.Errno(syscall.EEXIST),
-		}
+		return linkErr("symlink", oldname, newname, syscall.Errno(syscall.EEXIST))
 	}
-
-	now := time.Now()
-	d.files[newname] = &fakeFile{
-		access:   now,
-		modify:   now,
-		change:   now,
-		isDir:    false,
-		mode:     f.mode | os.ModeSymlink,
-		info:     nil, // TODO(ttacon): do it
-		uid:      f.uid,
-		gid:      f.gid,
-		pointsTo: oldname,
+	if err := d.parentDir("symlink", newname, path); err != nil {
+		d.lock.Unlock()
+		return linkErr("symlink", oldname, newname, err)
 	}
 
+	f := newFakeFile(os.ModeSymlink|os.ModePerm, d.uid, d.gid, d.now())
+	f.pointsTo = oldname
+	d.add(path, f)
+	d.notify(path, EventCreate)
+
 	d.lock.Unlock()
 	return nil
 }
@@ -499,138 +930,174 @@ func (d *fakeOS) TempDir() string {
 }
 
 func (d *fakeOS) Truncate(name string, size int64) error {
+	d.delay("Truncate")
+	return d.truncate(name, size)
+}
+
+func (d *fakeOS) truncate(name string, size int64) error {
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("truncate", name, true)
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+	if f.isDir {
 		d.lock.Unlock()
 		return &os.PathError{
-			Op:   "symlink",
+			Op:   "truncate",
 			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
+			Err:  syscall.Errno(syscall.EISDIR),
 		}
 	}
-
-	fSize := int64(len(f.content))
-
-	if size > fSize {
+	if size < 0 {
 		d.lock.Unlock()
-		return nil
+		return &os.PathError{
+			Op:   "truncate",
+			Path: name,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
 	}
 
-	newContent := make([]byte, len(f.content), cap(f.content))
-	copy(newContent, f.content[:size])
-	f.content = newContent
+	f = d.own(d.view, f)
+	f.lock.Lock()
+	if f.lazy != nil {
+		// load the contents without d.lock held, then start over
+		f.lock.Unlock()
+		o := d.backing.o
+		d.lock.Unlock()
+		if err := d.fill(o, f); err != nil {
+			return backingErr("truncate", name, err)
+		}
+		return d.truncate(name, size)
+	}
+	f.resize(size)
+	now := d.now()
+	f.modify, f.change = now, now
+	d.notify(path, EventWrite)
+	f.lock.Unlock()
 
 	d.lock.Unlock()
 	return nil
 }
 
 func (d *fakeOS) Create(name string) (file File, err error) {
-	d.lock.Lock()
-	// TODO(ttacon): need to be able to simulate if the current
-	// user has permission to do this or not
-
-	if _, ok := d.files[name]; ok {
-		d.lock.Unlock()
-		return nil, &os.PathError{
-			Op:   "create",
-			Path: name,
-			Err:  syscall.Errno(syscall.EEXIST),
-		}
-	}
-
-	now := time.Now()
-	f := &fakeFile{
-		access: now,
-		modify: now,
-		change: now,
-		isDir:  true,
-		mode:   os.ModePerm,
-		info:   nil, // TODO(ttacon): do it
-		uid:    d.uid,
-		gid:    d.gid,
-	}
-	d.files[name] = f
-
-	d.lock.Unlock()
-	return f, nil
+	d.delay("Create")
+	return d.openFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
 }
 
 func (d *fakeOS) NewFile(fd uintptr, name string) File {
 	// TODO(ttacon): swalllow fd?
+	f := newFakeFile(0666, d.uid, d.gid, d.now())
+	f.path = name
 	d.lock.Lock()
-	// TODO(ttacon): need to be able to simulate if the current
-	// user has permission to do this or not
-
-	now := time.Now()
-	f := &fakeFile{
-		access: now,
-		modify: now,
-		change: now,
-		isDir:  true,
-		mode:   os.ModePerm,
-		info:   nil, // TODO(ttacon): do it
-		uid:    d.uid,
-		gid:    d.gid,
+	defer d.lock.Unlock()
+	f.gen = d.generation()
+	d.view.open++
+	return &fakeHandle{
+		fd:       int(fd),
+		name:     name,
+		file:     f,
+		view:     d.view,
+		rdwrFlag: O_RDWR,
+		owner:    d,
 	}
-	d.files[name] = f
-
-	d.lock.Unlock()
-	return f
 }
 
 func (d *fakeOS) Open(name string) (file File, err error) {
+	d.delay("Open")
+	return d.openFile(name, O_RDONLY, 0)
+}
+
+func (d *fakeOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	d.delay("OpenFile")
+	return d.openFile(name, flag, perm)
+}
+
+func (d *fakeOS) openFile(name string, flag int, perm os.FileMode) (File, error) {
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	excl := flag&O_CREATE != 0 && flag&O_EXCL != 0
+	path, err := d.resolve("open", name, !excl)
+	if err != nil {
+		d.lock.Unlock()
+		return nil, err
+	}
+
+	writable := flag&(O_WRONLY|O_RDWR) != 0
+	f, ok := d.names.get(path)
+	switch {
+	case ok && excl:
 		d.lock.Unlock()
 		return nil, &os.PathError{
 			Op:   "open",
 			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
+			Err:  syscall.Errno(syscall.EEXIST),
 		}
-	}
-
-	d.lock.Unlock()
-	return f, nil
-}
-
-func (d *fakeOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
-	d.lock.Lock()
-	// TODO(ttacon): need to be able to simulate if the current
-	// user has permission to do this or not
-
-	if _, ok := d.files[name]; ok {
+	case ok && f.isDir && writable:
 		d.lock.Unlock()
 		return nil, &os.PathError{
-			Op:   "newfile",
+			Op:   "open",
 			Path: name,
-			Err:  syscall.Errno(syscall.EEXIST),
+			Err:  syscall.Errno(syscall.EISDIR),
+		}
+	case ok:
+		truncate := flag&O_TRUNC != 0 && writable
+		if truncate {
+			f = d.own(d.view, f)
+		}
+		f.lock.Lock()
+		if truncate {
+			f.lazy = nil // no need to read what's truncated
+			f.resize(0)
+			now := d.now()
+			f.modify, f.change = now, now
+			d.notify(path, EventWrite)
+		} else if !f.isDir && f.lazy != nil {
+			// load the contents without d.lock held, then start over
+			f.lock.Unlock()
+			o := d.backing.o
+			d.lock.Unlock()
+			if err := d.fill(o, f); err != nil {
+				return nil, backingErr("open", name, err)
+			}
+			return d.openFile(name, flag, perm)
+		}
+		f.lock.Unlock()
+	case flag&O_CREATE == 0:
+		d.lock.Unlock()
+		return nil, &os.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  syscall.Errno(syscall.ENOENT),
 		}
+	default:
+		if err := d.parentDir("open", name, path); err != nil {
+			d.lock.Unlock()
+			return nil, err
+		}
+		f = newFakeFile(perm&^os.ModeType, d.uid, d.gid, d.now())
+		d.add(path, f)
+		d.notify(path, EventCreate)
 	}
 
-	// TODO(ttacon): how is this different from Open()?
-	now := time.Now()
-	f := &fakeFile{
-		access: now,
-		modify: now,
-		change: now,
-		isDir:  true,
-		mode:   os.ModePerm,
-		info:   nil, // TODO(ttacon): do it
-		uid:    d.uid,
-		gid:    d.gid,
+	h := &fakeHandle{
+		fd:       d.nextFd,
+		name:     name,
+		file:     f,
+		view:     d.view,
+		rdwrFlag: flag,
+		owner:    d,
 	}
-	d.files[name] = f
+	d.view.open++
+	d.nextFd++
 
 	d.lock.Unlock()
-	return f, nil
+	return h, nil
 }
 
 func (d *fakeOS) Pipe() (r File, w File, err error) {
@@ -638,58 +1105,39 @@ func (d *fakeOS) Pipe() (r File, w File, err error) {
 }
 
 func (d *fakeOS) Lstat(name string) (fi os.FileInfo, err error) {
+	d.delay("Lstat")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	f, ok := d.files[name]
-	if !ok {
+	path, f, err := d.lookup("lstat", name, false)
+	if err != nil {
 		d.lock.Unlock()
-		return nil, &os.PathError{
-			Op:   "lstat",
-			Path: name,
-			Err:  syscall.Errno(syscall.ENOENT),
-		}
+		return nil, err
 	}
 
-	toReturn := f.info
+	f.lock.Lock()
+	toReturn := f.info(filepath.Base(path))
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return toReturn, nil
 }
 
 func (d *fakeOS) Stat(name string) (fi os.FileInfo, err error) {
+	d.delay("Stat")
 	d.lock.Lock()
 	// TODO(ttacon): need to be able to simulate if the current
 	// user has permission to do this or not
 
-	// we refuse to let the user be horrid and create a loop
-	var (
-		seen     = make(map[string]struct{})
-		toReturn os.FileInfo
-	)
-	for {
-		if _, ok := seen[name]; ok {
-			// TODO(ttacon): identify correct error message
-			return nil, errors.New("link cycle detected, failing")
-		}
-
-		f, ok := d.files[name]
-		if !ok {
-			d.lock.Unlock()
-			return nil, &os.PathError{
-				Op:   "stat",
-				Path: name,
-				Err:  syscall.Errno(syscall.ENOENT),
-			}
-		}
-
-		toReturn = f.info
-		if len(f.pointsTo) == 0 {
-			break
-		}
-		seen[name] = struct{}{}
-		name = f.pointsTo
+	path, f, err := d.lookup("stat", name, true)
+	if err != nil {
+		d.lock.Unlock()
+		return nil, err
 	}
+
+	f.lock.Lock()
+	toReturn := f.info(filepath.Base(path))
+	f.lock.Unlock()
 	d.lock.Unlock()
 	return toReturn, nil
 }
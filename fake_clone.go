@@ -0,0 +1,298 @@
+package fs
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Cloneable is implemented by the OperatingSystems able to copy their files
+// cheaply, as FakeOS. Copies share the contents of the files they hold with
+// the original until either writes them.
+type Cloneable interface {
+	// Clone returns an independent copy of the OperatingSystem: its files,
+	// working directory and environment. Open files and watches aren't
+	// copied, the clone of a FakeOS shares its Scheduler if any.
+	Clone() OperatingSystem
+
+	// Snapshot records the files, working directory and environment of
+	// the OperatingSystem, for Restore.
+	Snapshot() *Snapshot
+
+	// Restore brings the files, working directory and environment back to
+	// what they were when s was taken, s may be restored any number of
+	// times. Files already open keep referring to what they did, watches
+	// aren't told about the changes.
+	Restore(s *Snapshot)
+}
+
+// Snapshot is the state of a FakeOS recorded by Snapshot.
+type Snapshot struct {
+	names   *fakeNames
+	backing *backing
+	cwd     string
+	env     map[string]string
+}
+
+// Clone returns a copy of d, see Cloneable. d and the copy share their
+// files, each copying a file the first time it changes it, see own.
+func (d *fakeOS) Clone() OperatingSystem {
+	d.lock.Lock()
+	names := d.share()
+	c := &fakeOS{
+		lock:    new(sync.Mutex),
+		envLock: new(sync.RWMutex),
+		Stdin:   d.Stdin,
+		Stdout:  d.Stdout,
+		Stderr:  d.Stderr,
+		envVars: d.env(),
+		names:   names.layer(),
+		gen:     newGen(),
+		view:    &fakeView{},
+		cwd:     d.cwd,
+		nextFd:  d.nextFd,
+		tmpDir:  d.tmpDir,
+
+		uid:    d.uid,
+		gid:    d.gid,
+		groups: d.groups,
+
+		pagesize: d.pagesize,
+		pid:      d.pid,
+		ppid:     d.ppid,
+
+		clock:     d.clock,
+		latencies: map[string]Latency{},
+		readRate:  d.readRate,
+		writeRate: d.writeRate,
+		backing:   d.backing,
+		sched:     d.sched,
+
+		backingShared: d.backing != nil,
+	}
+	d.lock.Unlock()
+
+	for op, l := range d.latencies {
+		c.latencies[op] = l
+	}
+	// seeded from d, so that runs seeded WithSeed can still be reproduced
+	c.rand = rand.New(rand.NewSource(int64(d.random())))
+	c.unlocked = sync.NewCond(c.lock)
+	return c
+}
+
+// Snapshot records the state of d, see Cloneable.
+func (d *fakeOS) Snapshot() *Snapshot {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return &Snapshot{
+		names:   d.share(),
+		backing: d.backing,
+		cwd:     d.cwd,
+		env:     d.env(),
+	}
+}
+
+// Restore brings d back to s, see Cloneable.
+func (d *fakeOS) Restore(s *Snapshot) {
+	d.lock.Lock()
+	d.names, d.backing, d.cwd = s.names.layer(), s.backing, s.cwd
+	d.backingShared = s.backing != nil
+	atomic.StoreUint64(&d.gen, newGen())
+	// the files open before follow their copies in the view they were
+	// opened in, which d leaves
+	d.view = &fakeView{}
+	d.lock.Unlock()
+
+	d.envLock.Lock()
+	d.envVars = map[string]string{}
+	for k, v := range s.env {
+		d.envVars[k] = v
+	}
+	d.envLock.Unlock()
+}
+
+// env returns a copy of the environment of d.
+func (d *fakeOS) env() map[string]string {
+	d.envLock.RLock()
+	defer d.envLock.RUnlock()
+	env := make(map[string]string, len(d.envVars))
+	for k, v := range d.envVars {
+		env[k] = v
+	}
+	return env
+}
+
+// fakeView is what the files open in a fakeOS see of it: they follow the
+// copies made of their files in the view they were opened in, see own.
+// Clones start in a view of their own, and Restore moves to a new one.
+type fakeView struct {
+	open int // the files open in the view, guarded by the lock of the fakeOS
+}
+
+// lastGen is the last generation handed out by newGen.
+var lastGen uint64
+
+// newGen returns a new generation, for a fakeOS to change its files in, see
+// own.
+func newGen() uint64 {
+	return atomic.AddUint64(&lastGen, 1)
+}
+
+// generation returns the generation of the files d may change in place.
+func (d *fakeOS) generation() uint64 {
+	return atomic.LoadUint64(&d.gen)
+}
+
+// share freezes the files of d, and the backing directory they're loaded
+// from if any, returning their names for a clone or snapshot to start from.
+// d goes on in a new generation, copying the files it changes. Must be
+// called with d.lock held.
+func (d *fakeOS) share() *fakeNames {
+	names := d.names.frozen()
+	d.names = names.layer()
+	atomic.StoreUint64(&d.gen, newGen())
+	d.backingShared = d.backing != nil
+	return names
+}
+
+// own returns the file f is now in the view v of d, copying it first if
+// it's shared with clones or snapshots, so that d may change it. The copy
+// takes the place of f in d if v is d's view, and the files open on f in v
+// follow it, if there are any. Must be called with d.lock held, and not the lock of f.
+func (d *fakeOS) own(v *fakeView, f *fakeFile) *fakeFile {
+	f = current(v, f)
+	gen := d.generation()
+	if f.gen == gen {
+		return f
+	}
+
+	f.lock.Lock()
+	c := f.clone(v)
+	c.gen = gen
+	if v.open > 0 {
+		if f.copies == nil {
+			f.copies = map[*fakeView]*fakeFile{}
+		}
+		f.copies[v] = c
+	}
+	f.lock.Unlock()
+
+	if v == d.view {
+		d.replace(f, c)
+	}
+	return c
+}
+
+// current returns the copy of f made last in the view v, or f if there's
+// none.
+func current(v *fakeView, f *fakeFile) *fakeFile {
+	for {
+		f.lock.Lock()
+		c := f.copies[v]
+		f.lock.Unlock()
+		if c == nil {
+			return f
+		}
+		f = c
+	}
+}
+
+// replace gives c the names of f in d, and its place in the backing
+// directory. Must be called with d.lock held.
+func (d *fakeOS) replace(f, c *fakeFile) {
+	if !f.linked {
+		// f.path is its only name
+		if g, _ := d.names.get(f.path); g == f {
+			d.names.set(f.path, c)
+		}
+		return
+	}
+
+	var paths []string
+	d.names.each(func(path string, g *fakeFile) {
+		if g == f {
+			paths = append(paths, path)
+		}
+	})
+	for _, path := range paths {
+		d.names.set(path, c)
+	}
+	if d.backing == nil {
+		return
+	}
+	for key, g := range d.backing.inodes {
+		if g == f {
+			d.ownBacking().inodes[key] = c
+			break
+		}
+	}
+}
+
+// ownBacking returns the backing directory of d, copying it first if it's
+// shared with clones or snapshots. Must be called with d.lock held.
+func (d *fakeOS) ownBacking() *backing {
+	if !d.backingShared {
+		return d.backing
+	}
+	b := d.backing
+	d.backing = &backing{
+		o:      b.o,
+		dir:    b.dir,
+		inodes: make(map[[2]uint64]*fakeFile, len(b.inodes)),
+		report: LoadReport{
+			Stat:   append([]string(nil), b.report.Stat...),
+			Read:   append([]string(nil), b.report.Read...),
+			Listed: append([]string(nil), b.report.Listed...),
+		},
+	}
+	for key, f := range b.inodes {
+		d.backing.inodes[key] = f
+	}
+	d.backingShared = false
+	return d.backing
+}
+
+// clone returns a copy of f for the view v, sharing its contents. It keeps
+// the locks held by the files open in v. Must be called with f.lock held.
+func (f *fakeFile) clone(v *fakeView) *fakeFile {
+	c := &fakeFile{
+		ino:      f.ino,
+		access:   f.access,
+		modify:   f.modify,
+		change:   f.change,
+		isDir:    f.isDir,
+		isLink:   f.isLink,
+		mode:     f.mode,
+		uid:      f.uid,
+		gid:      f.gid,
+		pointsTo: f.pointsTo,
+		data:     f.data.share(),
+		path:     f.path,
+		linked:   f.linked,
+	}
+	for h, l := range f.flocks {
+		if h.view == v {
+			if c.flocks == nil {
+				c.flocks = map[*fakeHandle]int{}
+			}
+			c.flocks[h] = l
+		}
+	}
+	for _, r := range f.ranges {
+		if r.owner.view == v {
+			c.ranges = append(c.ranges, r)
+		}
+	}
+	if f.lazy != nil {
+		lazy := *f.lazy
+		if f.lazy.seen != nil {
+			lazy.seen = make(map[string]bool, len(f.lazy.seen))
+			for name := range f.lazy.seen {
+				lazy.seen[name] = true
+			}
+		}
+		c.lazy = &lazy
+	}
+	return c
+}
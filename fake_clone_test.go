@@ -0,0 +1,271 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// cloneFixture returns a FakeOS holding a tree large enough for sharing to
+// matter.
+func cloneFixture(t *testing.T) OperatingSystem {
+	t.Helper()
+	o := FakeOS()
+	for i := 0; i < 10; i++ {
+		dir := fmt.Sprintf("/data/%d", i)
+		if err := o.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 10; j++ {
+			content := bytes.Repeat([]byte{byte('a' + j)}, 3*chunkSize)
+			if err := WriteFile(o, fmt.Sprintf("%s/%d", dir, j), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := o.Link("/data/0/0", "/data/link"); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func Test_FakeOS_Clone(t *testing.T) {
+	o := cloneFixture(t)
+	o.Setenv("HOME", "/data")
+	c := o.(Cloneable).Clone()
+
+	if changes, err := Diff(o, c, "/", "/"); err != nil || len(changes) != 0 {
+		t.Fatalf("the clone differs: %v, err: %v", changes, err)
+	}
+	if c.Getenv("HOME") != "/data" {
+		t.Errorf("the environment wasn't cloned")
+	}
+
+	// changes on either side don't show on the other
+	if err := WriteFile(c, "/data/1/1", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Chmod("/data/2/2", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveAll("/data/3"); err != nil {
+		t.Fatal(err)
+	}
+	c.Setenv("HOME", "/")
+
+	changes, err := Diff(o, c, "/", "/", IgnoreAttrs(AttrModTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"modified data/1/1 (content)",
+		"modified data/2/2 (mode)",
+		"removed data/3",
+	}
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("removed data/3/%d", i))
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("got changes %v, expected %v", changes, want)
+	}
+	if o.Getenv("HOME") != "/data" {
+		t.Errorf("changing the environment of the clone changed the original")
+	}
+}
+
+func Test_FakeOS_Clone_Chunks(t *testing.T) {
+	o := cloneFixture(t)
+	c := o.(Cloneable).Clone()
+
+	// writing part of a shared chunk copies it, on either side
+	f, err := c.OpenFile("/data/0/1", O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("XY"), chunkSize-1)
+	f.Close()
+	if err := o.Truncate("/data/0/2", chunkSize+1); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := ReadFile(c, "/data/0/1")
+	want := bytes.Repeat([]byte("b"), 3*chunkSize)
+	copy(want[chunkSize-1:], "XY")
+	if !bytes.Equal(got, want) {
+		t.Errorf("the clone doesn't read what it wrote")
+	}
+	if got, _ := ReadFile(o, "/data/0/1"); !bytes.Equal(got, bytes.Repeat([]byte("b"), 3*chunkSize)) {
+		t.Errorf("writing the clone changed the original")
+	}
+	if got, _ := ReadFile(c, "/data/0/2"); !bytes.Equal(got, bytes.Repeat([]byte("c"), 3*chunkSize)) {
+		t.Errorf("truncating the original changed the clone")
+	}
+
+	// hard links stay links in the clone
+	if err := WriteFile(c, "/data/link", []byte("through the link"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ReadFile(c, "/data/0/0"); string(got) != "through the link" {
+		t.Errorf("the clone's hard link reads %q", got)
+	}
+	if got, _ := ReadFile(o, "/data/0/0"); string(got) == "through the link" {
+		t.Errorf("writing the clone's hard link changed the original")
+	}
+}
+
+func Test_FakeOS_Snapshot(t *testing.T) {
+	o := cloneFixture(t)
+	s := o.(Cloneable).Snapshot()
+
+	steps := []func() error{
+		func() error { return o.RemoveAll("/data") },
+		func() error { return WriteFile(o, "/data/0/0", []byte("x"), 0644) },
+		func() error { return o.Rename("/data/1", "/data/moved") },
+		func() error { return o.Chdir("/data/2") },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		o.(Cloneable).Restore(s)
+
+		if changes, err := Diff(o, cloneFixture(t), "/", "/", IgnoreAttrs(AttrModTime)); err != nil || len(changes) != 0 {
+			t.Errorf("step %d: restoring left %v, err: %v", i, changes, err)
+		}
+		if wd, _ := o.Getwd(); wd != "/" {
+			t.Errorf("step %d: restoring left the working directory at %s", i, wd)
+		}
+	}
+}
+
+func Test_FakeOS_Clone_Subtests(t *testing.T) {
+	fixture := cloneFixture(t).(Cloneable)
+	for i := 0; i < 10; i++ {
+		i := i
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			o := fixture.Clone()
+			name := fmt.Sprintf("/data/%d/%d", i, i)
+			if err := WriteFile(o, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.RemoveAll(fmt.Sprintf("/data/%d", (i+1)%10)); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := ReadFile(o, name); string(got) != name {
+				t.Errorf("read %q", got)
+			}
+		})
+	}
+}
+
+func Test_FakeOS_Clone_Shares(t *testing.T) {
+	o := cloneFixture(t)
+	c := o.(Cloneable).Clone()
+
+	get := func(o OperatingSystem, path string) *fakeFile {
+		f, _ := o.(*fakeOS).names.get(path)
+		return f
+	}
+	if get(o, "/data/0/1") != get(c, "/data/0/1") {
+		t.Fatalf("the clone didn't share the inode")
+	}
+
+	// writing copies the inode on the side that writes only
+	before := get(o, "/data/0/1")
+	if err := WriteFile(c, "/data/0/1", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if get(o, "/data/0/1") != before {
+		t.Errorf("writing the clone replaced the original's inode")
+	}
+	if get(c, "/data/0/1") == before {
+		t.Errorf("writing the clone didn't copy the inode")
+	}
+	if get(c, "/data/0/2") != get(o, "/data/0/2") {
+		t.Errorf("writing the clone copied another inode")
+	}
+
+	// the names don't pile up layer after layer
+	for i := 0; i < 3*maxNamesDepth; i++ {
+		o = o.(Cloneable).Clone()
+		o.(Cloneable).Snapshot()
+	}
+	if depth := o.(*fakeOS).names.depth; depth > maxNamesDepth {
+		t.Errorf("the names are %d layers deep", depth)
+	}
+}
+
+func Test_FakeOS_Clone_OpenFiles(t *testing.T) {
+	o := cloneFixture(t)
+	f, err := o.OpenFile("/data/0/1", O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	c := o.(Cloneable).Clone()
+
+	// a file opened before cloning writes the original
+	if _, err := f.WriteAt([]byte("XY"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ReadFile(o, "/data/0/1"); string(got[:2]) != "XY" {
+		t.Errorf("the original reads %q", got[:2])
+	}
+	if got, _ := ReadFile(c, "/data/0/1"); string(got[:2]) != "bb" {
+		t.Errorf("the clone reads %q", got[:2])
+	}
+	got := make([]byte, 2)
+	if _, err := f.ReadAt(got, 0); err != nil || string(got) != "XY" {
+		t.Errorf("the open file reads %q, err: %v", got, err)
+	}
+
+	// its lock is still held in the original, not in the clone
+	h, err := o.Open("/data/0/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.TryLock(); err == nil {
+		t.Errorf("the original's file isn't locked anymore")
+	}
+	g, err := c.Open("/data/0/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err := g.TryLock(); err != nil {
+		t.Errorf("the clone's file is locked: %v", err)
+	}
+}
+
+func Test_FakeOS_Restore_OpenFiles(t *testing.T) {
+	o := cloneFixture(t)
+	s := o.(Cloneable).Snapshot()
+	f, err := o.OpenFile("/data/0/1", O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	o.(Cloneable).Restore(s)
+
+	// a file opened before restoring keeps to what it opened
+	if _, err := f.WriteAt([]byte("XY"), 0); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 2)
+	if _, err := f.ReadAt(got, 0); err != nil || string(got) != "XY" {
+		t.Errorf("the open file reads %q, err: %v", got, err)
+	}
+	if got, _ := ReadFile(o, "/data/0/1"); string(got[:2]) != "bb" {
+		t.Errorf("writing the open file changed what was restored: %q", got[:2])
+	}
+	o.(Cloneable).Restore(s)
+	if got, _ := ReadFile(o, "/data/0/1"); string(got[:2]) != "bb" {
+		t.Errorf("writing the open file changed the snapshot: %q", got[:2])
+	}
+}
@@ -0,0 +1,188 @@
+package fs
+
+import (
+	"io"
+	"sort"
+)
+
+// chunkSize is how much of the contents of a fakeFile is allocated at once,
+// as a filesystem block would be.
+const chunkSize = 4096
+
+// fakeData is the contents of a fakeFile, stored as chunks so that what
+// was never written (a hole) costs nothing and reads as zeroes.
+//
+// The chunks may be shared with the copies of the fakeFile made by Clone
+// or Snapshot, they're copied before being written.
+type fakeData struct {
+	size   int64
+	chunks map[int64][]byte // by index, each chunkSize long
+
+	// shared is set when chunks, and all the chunks in it, are shared.
+	// Once chunks is copied, owned holds the chunks that aren't.
+	shared bool
+	owned  map[int64]bool
+}
+
+// share returns a copy of c sharing its chunks.
+func (c *fakeData) share() fakeData {
+	if c.chunks == nil {
+		return fakeData{size: c.size}
+	}
+	c.shared, c.owned = true, nil
+	return fakeData{size: c.size, chunks: c.chunks, shared: true}
+}
+
+// unshare copies chunks if it's shared, not the chunks in it.
+func (c *fakeData) unshare() {
+	if !c.shared {
+		return
+	}
+	chunks := make(map[int64][]byte, len(c.chunks))
+	for i, chunk := range c.chunks {
+		chunks[i] = chunk
+	}
+	c.chunks, c.shared, c.owned = chunks, false, map[int64]bool{}
+}
+
+// chunk returns the chunk at index i to be written, allocating it or
+// copying it as needed.
+func (c *fakeData) chunk(i int64) []byte {
+	c.unshare()
+	if c.chunks == nil {
+		c.chunks = map[int64][]byte{}
+	}
+	chunk := c.chunks[i]
+	switch {
+	case chunk == nil:
+		chunk = make([]byte, chunkSize)
+	case c.owned != nil && !c.owned[i]:
+		chunk = append([]byte(nil), chunk...)
+	default:
+		return chunk
+	}
+	c.chunks[i] = chunk
+	if c.owned != nil {
+		c.owned[i] = true
+	}
+	return chunk
+}
+
+func (c *fakeData) len() int64 {
+	return c.size
+}
+
+// blocks returns the number of 512 byte blocks allocated, as stat(2)
+// reports them.
+func (c *fakeData) blocks() int64 {
+	return int64(len(c.chunks)) * chunkSize / 512
+}
+
+// resize grows (with a hole) or shrinks c to size bytes.
+func (c *fakeData) resize(size int64) {
+	if size < c.size {
+		c.unshare()
+		for i := range c.chunks {
+			if i*chunkSize >= size {
+				delete(c.chunks, i)
+			}
+		}
+		// what's cut off reads as zeroes if c grows again
+		if c.chunks[size/chunkSize] != nil && size%chunkSize != 0 {
+			zero(c.chunk(size / chunkSize)[size%chunkSize:])
+		}
+	}
+	c.size = size
+}
+
+// writeAt writes b at off, allocating chunks and growing c as needed.
+func (c *fakeData) writeAt(b []byte, off int64) {
+	end := off + int64(len(b))
+	for pos := off; len(b) > 0; {
+		n := copy(c.chunk(pos / chunkSize)[pos%chunkSize:], b)
+		b, pos = b[n:], pos+int64(n)
+	}
+	if end > c.size {
+		c.size = end
+	}
+}
+
+// readAt reads from off into b as io.ReaderAt does, short of the end of c.
+func (c *fakeData) readAt(b []byte, off int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	if left := c.size - off; int64(len(b)) > left {
+		b = b[:left]
+	}
+	for pos, rest := off, b; len(rest) > 0; {
+		var n int
+		if chunk := c.chunks[pos/chunkSize]; chunk != nil {
+			n = copy(rest, chunk[pos%chunkSize:])
+		} else {
+			n = len(rest)
+			if room := chunkSize - pos%chunkSize; int64(n) > room {
+				n = int(room)
+			}
+			zero(rest[:n])
+		}
+		rest, pos = rest[n:], pos+int64(n)
+	}
+	return len(b), nil
+}
+
+// seekData returns the offset of the first data at or after off, as
+// lseek(2) with SEEK_DATA, or false if there is none.
+func (c *fakeData) seekData(off int64) (int64, bool) {
+	if off >= c.size {
+		return 0, false
+	}
+	if c.chunks[off/chunkSize] != nil {
+		return off, true
+	}
+	for _, i := range c.indexes() {
+		if start := i * chunkSize; start > off && start < c.size {
+			return start, true
+		}
+	}
+	return 0, false
+}
+
+// seekHole returns the offset of the first hole at or after off, as lseek(2)
+// with SEEK_HOLE, the end of c counting as one, or false if off is past the
+// end.
+func (c *fakeData) seekHole(off int64) (int64, bool) {
+	if off >= c.size {
+		return 0, false
+	}
+	for i := off / chunkSize; i*chunkSize < c.size; i++ {
+		if c.chunks[i] == nil {
+			if start := i * chunkSize; start > off {
+				return start, true
+			}
+			return off, true
+		}
+	}
+	return c.size, true
+}
+
+// indexes returns the indexes of the allocated chunks, in order.
+func (c *fakeData) indexes() []int64 {
+	indexes := make([]int64, 0, len(c.chunks))
+	for i := range c.chunks {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i] < indexes[j]
+	})
+	return indexes
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
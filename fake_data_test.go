@@ -0,0 +1,141 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+)
+
+func Test_FakeOS_Sparse(t *testing.T) {
+	o := FakeOS()
+	f, err := o.Create("/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(10 << 30); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("hello"), 5<<30); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := o.Stat("/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := sysStat(fi)
+	if !ok {
+		t.Fatalf("no stat in %T", fi.Sys())
+	}
+	if fi.Size() != 10<<30 || st.blocks != chunkSize/512 {
+		t.Errorf("got size %d and %d blocks", fi.Size(), st.blocks)
+	}
+
+	b := make([]byte, 8)
+	if _, err := f.ReadAt(b, 5<<30-3); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("\x00\x00\x00hello"); !bytes.Equal(b, want) {
+		t.Errorf("read %q, expected %q", b, want)
+	}
+	if n, err := f.ReadAt(b, 10<<30-2); n != 2 || err != io.EOF {
+		t.Errorf("read %d, %v at the end", n, err)
+	}
+}
+
+func Test_FakeOS_Sparse_Seek(t *testing.T) {
+	o := FakeOS()
+	f, err := o.Create("/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// data in the second and the fourth chunks, then a hole to the end
+	f.WriteAt([]byte("a"), chunkSize+10)
+	f.WriteAt([]byte("b"), 3*chunkSize)
+	f.Truncate(6 * chunkSize)
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+		err    error
+	}{
+		{0, SEEK_DATA, chunkSize, nil},
+		{chunkSize + 100, SEEK_DATA, chunkSize + 100, nil},
+		{2 * chunkSize, SEEK_DATA, 3 * chunkSize, nil},
+		{4 * chunkSize, SEEK_DATA, 0, syscall.ENXIO},
+		{0, SEEK_HOLE, 0, nil},
+		{chunkSize, SEEK_HOLE, 2 * chunkSize, nil},
+		{3*chunkSize + 1, SEEK_HOLE, 4 * chunkSize, nil},
+		{6 * chunkSize, SEEK_HOLE, 0, syscall.ENXIO},
+	}
+	for _, test := range tests {
+		got, err := f.Seek(test.offset, test.whence)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("Seek(%d, %d): expected %v, got %d, %v", test.offset, test.whence, test.err, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Seek(%d, %d): got %d, %v, expected %d", test.offset, test.whence, got, err, test.want)
+		}
+	}
+}
+
+func Test_FakeOS_Sparse_Shrink(t *testing.T) {
+	o := FakeOS()
+	content := bytes.Repeat([]byte("x"), 2*chunkSize+100)
+	if err := WriteFile(o, "/f", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Truncate("/f", chunkSize+10); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Truncate("/f", 3*chunkSize); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadFile(o, "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Repeat([]byte("x"), chunkSize+10), make([]byte, 2*chunkSize-10)...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("what was cut off doesn't read as zeroes")
+	}
+
+	fi, _ := o.Stat("/f")
+	if st, _ := sysStat(fi); st.blocks != 2*chunkSize/512 {
+		t.Errorf("got %d blocks, expected %d", st.blocks, 2*chunkSize/512)
+	}
+}
+
+func Test_FakeOS_Append_Chunks(t *testing.T) {
+	o := FakeOS()
+	f, err := o.OpenFile("/log", O_WRONLY|O_CREATE|O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte("0123456789abcdefghijklmnopqrstuvwxyz\n")
+	var want []byte
+	for i := 0; i < 1000; i++ {
+		f.Write(line)
+		want = append(want, line...)
+	}
+	f.Close()
+
+	data, err := ReadFile(o, "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("read %d bytes back, expected %d", len(data), len(want))
+	}
+}
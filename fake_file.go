@@ -1,207 +1,576 @@
 package fs
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// fakeFile is the inode of a file living in a fakeOS, several names (hard
+// links) and open handles may refer to the same fakeFile.
 type fakeFile struct {
-	fd                     int
-	name                   string
+	// lock guards the attributes and the contents of the file, see fakeOS
+	// for the order locks are taken in
+	lock sync.Mutex
+	ino  uint64
+
 	access, modify, change time.Time
-	isDir                  bool
-	rdwrFlag               int
+	isDir, isLink          bool // never change
 	mode                   os.FileMode
-	info                   os.FileInfo
 	uid, gid               int
 	pointsTo               string // for links
-	currPos                int64
-	content                []byte
+	data                   fakeData
+
+	// advisory locks, by the handle holding them, see fake_lock.go. They
+	// are guarded by the lock of the fakeOS.
+	flocks map[*fakeHandle]int
+	ranges []fakeRange
+
+	// what's left to load from the backing directory, see WithBacking
+	lazy *lazyFile
+
+	// path is the name the changes made through open files are reported
+	// under: the one the file was created or last renamed as, another of
+	// its names once that one is removed, or none. It's changed holding
+	// both the lock of the fakeOS and lock, so that either is enough to
+	// read it.
+	path string
+
+	// linked is set once the file has, or had, several names.
+	linked bool
+
+	// gen is the generation of the fakeOS that may change the file in
+	// place. Clones and snapshots share the files of older generations,
+	// which are copied before being changed, see fakeOS.own.
+	gen uint64
+
+	// copies holds the copies made of the file, by the view of the fakeOS
+	// that made them, for the files open in that view to follow. It's
+	// guarded by lock.
+	copies map[*fakeView]*fakeFile
 }
 
-func newFakeFile(
-	name string,
-	mode os.FileMode,
-	info os.FileInfo,
-	rdwrFlag int) *fakeFile {
-	now := time.Now()
-	return &fakeFile{
-		name:     name,
-		access:   now,
-		modify:   now,
-		change:   now,
-		rdwrFlag: rdwrFlag,
-		info:     info,
-		mode:     mode,
-		uid:      0, // TODO(ttacon): query os singleton?
-		gid:      0, // TODO(ttacon): query os singleton?
-	}
-}
+// lastIno numbers the fakeFiles as they're created.
+var lastIno uint64
 
-func newDir(name string, info os.FileInfo) *fakeFile {
-	now := time.Now()
+func newFakeFile(mode os.FileMode, uid, gid int, now time.Time) *fakeFile {
 	return &fakeFile{
-		name:   name,
+		ino:    atomic.AddUint64(&lastIno, 1),
 		access: now,
 		modify: now,
 		change: now,
-		isDir:  true,
-		// rdwrFlag?
-		info: info,
-		mode: os.ModeDir,
-		uid:  0, // TODO(ttacon): query os singleton?
-		gid:  0, // TODO(ttacon): query os singleton?
+		isLink: mode&os.ModeSymlink != 0,
+		mode:   mode,
+		uid:    uid,
+		gid:    gid,
 	}
 }
 
-func (f *fakeFile) Chdir() error {
-	return currOs.Chdir(f.name)
+func newDir(perm os.FileMode, uid, gid int, now time.Time) *fakeFile {
+	f := newFakeFile(os.ModeDir|perm&os.ModePerm, uid, gid, now)
+	f.isDir = true
+	return f
+}
+
+// lockFiles locks files in the order of their inode numbers, skipping the
+// nil ones and locking those appearing twice once. It returns what unlocks
+// them.
+func lockFiles(files ...*fakeFile) (unlock func()) {
+	var locked []*fakeFile
+	for _, f := range files {
+		if f != nil {
+			locked = append(locked, f)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].ino < locked[j].ino
+	})
+	for i, f := range locked {
+		if i > 0 && locked[i-1] == f {
+			continue
+		}
+		f.lock.Lock()
+	}
+	return func() {
+		for i, f := range locked {
+			if i > 0 && locked[i-1] == f {
+				continue
+			}
+			f.lock.Unlock()
+		}
+	}
+}
+
+// resize grows (with a hole) or shrinks the content of f to size bytes.
+func (f *fakeFile) resize(size int64) {
+	f.data.resize(size)
+}
+
+// writeAt writes b at off, growing the file as needed.
+func (f *fakeFile) writeAt(b []byte, off int64, now time.Time) {
+	f.data.writeAt(b, off)
+	f.modify, f.change = now, now
+}
+
+// info returns a snapshot of f as an os.FileInfo named name. Must be called
+// with f.lock held.
+func (f *fakeFile) info(name string) os.FileInfo {
+	size := f.data.len()
+	switch {
+	case f.isLink:
+		size = int64(len(f.pointsTo))
+	case !f.isDir && f.lazy != nil:
+		size = f.lazy.size
+	}
+	return &fakeFileInfo{
+		name:    name,
+		size:    size,
+		mode:    f.mode,
+		modTime: f.modify,
+		file:    f,
+		sys:     f.sys(size),
+	}
+}
+
+// fakeFileInfo is the os.FileInfo returned by a fakeOS.
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	file    *fakeFile
+	sys     interface{} // the inode, ownership, size and allocation, see sys
+}
+
+func (fi *fakeFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fakeFileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fakeFileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+
+func (fi *fakeFileInfo) ModTime() time.Time {
+	return fi.modTime
 }
 
-func (f *fakeFile) Chmod(mode os.FileMode) error {
-	return currOs.Chmod(f.name, mode)
+func (fi *fakeFileInfo) IsDir() bool {
+	return fi.mode.IsDir()
 }
 
-func (f *fakeFile) Chown(uid, gid int) error {
-	return currOs.Chown(f.name, uid, gid)
+func (fi *fakeFileInfo) Sys() interface{} {
+	return fi.sys
 }
 
 const O_CLOSED = -1
 
-func (f *fakeFile) Close() error {
-	// TODO(ttacon): Can this return an error?
+// fakeHandle is an open file in a fakeOS, it's what implements File.
+type fakeHandle struct {
+	fd   int
+	name string    // as given to Open
+	file *fakeFile // as opened, see lockFile
+	view *fakeView // of the owner, when it was opened
+
+	// lock guards currPos and dirents. rdwrFlag is only changed by Close,
+	// holding both lock and the lock of the owner, so that either is enough
+	// to read it.
+	lock     sync.Mutex
+	rdwrFlag int
+	currPos  int64
+	dirents  []os.FileInfo // what's left to be returned by Readdir
+
+	// owner is the fakeOS the file lives in, file level operations that
+	// need to go back through the OS (Chdir, ...) use it.
+	owner *fakeOS
+}
+
+// lockFile locks the file f has open as it is now, the copy of it made last
+// in the view of f if any (see fakeOS.own), and returns it.
+func (f *fakeHandle) lockFile() *fakeFile {
+	file := f.file
+	file.lock.Lock()
+	for file.copies[f.view] != nil {
+		c := file.copies[f.view]
+		file.lock.Unlock()
+		file = c
+		file.lock.Lock()
+	}
+	return file
+}
+
+// lockOwnFile is lockFile for changing the file, which is copied first if
+// it's shared with clones or snapshots.
+func (f *fakeHandle) lockOwnFile() *fakeFile {
+	for {
+		file := f.lockFile()
+		if file.gen == f.owner.generation() {
+			return file
+		}
+		file.lock.Unlock()
+		f.owner.lock.Lock()
+		f.owner.own(f.view, file)
+		f.owner.lock.Unlock()
+	}
+}
+
+// check returns an error if f is closed, or not open for reading (or
+// writing if write is set). Must be called with f.lock or the owner's lock
+// held.
+func (f *fakeHandle) check(op string, write bool) error {
+	if f.rdwrFlag == O_CLOSED {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+
+	access := f.rdwrFlag & (O_RDONLY | O_WRONLY | O_RDWR)
+	if (write && access == O_RDONLY) || (!write && access == O_WRONLY) {
+		return &os.PathError{
+			Op:   op,
+			Path: f.name,
+			Err:  syscall.Errno(syscall.EBADF),
+		}
+	}
+	return nil
+}
+
+// closed returns whether f was closed.
+func (f *fakeHandle) closed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.rdwrFlag == O_CLOSED
+}
+
+// access checks f as check does.
+func (f *fakeHandle) access(op string, write bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.check(op, write)
+}
+
+func (f *fakeHandle) Chdir() error {
+	if f.closed() {
+		return &os.PathError{Op: "chdir", Path: f.name, Err: os.ErrClosed}
+	}
+	file := f.lockFile()
+	path := file.path
+	file.lock.Unlock()
+	if path == "" {
+		return &os.PathError{Op: "chdir", Path: f.name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	return f.owner.Chdir(path)
+}
+
+func (f *fakeHandle) Chmod(mode os.FileMode) error {
+	f.owner.delay("File.Chmod")
+	if f.closed() {
+		return &os.PathError{Op: "chmod", Path: f.name, Err: os.ErrClosed}
+	}
+	file := f.lockOwnFile()
+	file.mode = file.mode&os.ModeType | mode&^os.ModeType
+	file.change = f.owner.now()
+	f.owner.notify(file.path, EventChmod)
+	file.lock.Unlock()
+	return nil
+}
+
+func (f *fakeHandle) Chown(uid, gid int) error {
+	f.owner.delay("File.Chown")
+	if f.closed() {
+		return &os.PathError{Op: "chown", Path: f.name, Err: os.ErrClosed}
+	}
+	file := f.lockOwnFile()
+	file.uid, file.gid = uid, gid
+	file.change = f.owner.now()
+	f.owner.notify(file.path, EventChmod)
+	file.lock.Unlock()
+	return nil
+}
+
+func (f *fakeHandle) Close() error {
+	f.owner.delay("File.Close")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.rdwrFlag == O_CLOSED {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.owner.lock.Lock()
 	f.rdwrFlag = O_CLOSED
+	f.releaseLocks()
+	f.view.open--
+	f.owner.lock.Unlock()
 	return nil
 }
 
-func (f *fakeFile) Fd() uintptr {
+func (f *fakeHandle) Fd() uintptr {
+	if f.closed() {
+		return ^uintptr(0)
+	}
 	return uintptr(f.fd)
 }
 
-func (f *fakeFile) Name() string {
+func (f *fakeHandle) Name() string {
 	return f.name
 }
 
-func (f *fakeFile) Read(b []byte) (n int, err error) {
-	desired := int64(len(b))
-	toRead := desired
-
-	if desired > int64(len(f.content))-f.currPos {
-		toRead = int64(len(f.content)) - f.currPos
+func (f *fakeHandle) Read(b []byte) (n int, err error) {
+	f.owner.delay("File.Read")
+	f.lock.Lock()
+	if err := f.check("read", false); err != nil {
+		f.lock.Unlock()
+		return 0, err
+	}
+	if f.file.isDir {
+		f.lock.Unlock()
+		return 0, &os.PathError{
+			Op:   "read",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.EISDIR),
+		}
 	}
 
-	copy(b, f.content[f.currPos:f.currPos+toRead])
-	f.currPos += toRead
-	return int(toRead), nil
+	file := f.lockFile()
+	n, err = file.data.readAt(b, f.currPos)
+	file.lock.Unlock()
+	f.currPos += int64(n)
+	f.lock.Unlock()
+	f.owner.transfer(n, f.owner.readRate)
+	return n, err
 }
 
-func (f *fakeFile) ReadAt(b []byte, off int64) (n int, err error) {
-	desired := int64(len(b))
-	toRead := desired
+func (f *fakeHandle) ReadAt(b []byte, off int64) (n int, err error) {
+	f.owner.delay("File.ReadAt")
+	if err := f.access("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{
+			Op:   "readat",
+			Path: f.name,
+			Err:  errors.New("negative offset"),
+		}
+	}
 
-	if desired > int64(len(f.content))-off {
-		toRead = int64(len(f.content)) - off
+	file := f.lockFile()
+	for len(b) > 0 {
+		var m int
+		m, err = file.data.readAt(b, off)
+		n += m
+		if err != nil {
+			break
+		}
+		b = b[m:]
+		off += int64(m)
 	}
+	file.lock.Unlock()
+	f.owner.transfer(n, f.owner.readRate)
+	return n, err
+}
 
-	copy(b, f.content[off:off+toRead])
-	f.currPos = off + toRead
-	return int(toRead), nil
+func (f *fakeHandle) Readdir(n int) (fi []os.FileInfo, err error) {
+	f.owner.delay("File.Readdir")
+	return f.readdir(n)
 }
 
-func (f *fakeFile) Readdir(n int) (fi []os.FileInfo, err error) {
-	// TODO(ttacon): do it
-	panic("UNIMPLEMENTED")
+func (f *fakeHandle) readdir(n int) ([]os.FileInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.rdwrFlag == O_CLOSED {
+		return nil, &os.PathError{Op: "readdirent", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.file.isDir {
+		return nil, &os.PathError{
+			Op:   "readdirent",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.ENOTDIR),
+		}
+	}
+
+	if f.dirents == nil {
+		f.owner.lock.Lock()
+		children, err := f.owner.children(current(f.view, f.file).path)
+		if err != nil {
+			f.owner.lock.Unlock()
+			return nil, backingErr("readdirent", f.name, err)
+		}
+		f.dirents = make([]os.FileInfo, len(children))
+		for i, child := range children {
+			file, _ := f.owner.names.get(child)
+			file.lock.Lock()
+			f.dirents[i] = file.info(filepath.Base(child))
+			file.lock.Unlock()
+		}
+		f.owner.lock.Unlock()
+		sort.Slice(f.dirents, func(i, j int) bool {
+			return f.dirents[i].Name() < f.dirents[j].Name()
+		})
+	}
+
+	return nextDirents(&f.dirents, n)
 }
 
-func (f *fakeFile) Readdirnames(n int) (names []string, err error) {
-	// TODO(ttacon): do it
-	panic("UNIMPLEMENTED")
+func (f *fakeHandle) Readdirnames(n int) (names []string, err error) {
+	f.owner.delay("File.Readdirnames")
+	return direntNames(f.readdir(n))
 }
 
-func (f *fakeFile) Seek(offset int64, whence int) (ret int64, err error) {
-	var newOffset int64
-	if whence == 0 {
+func (f *fakeHandle) Seek(offset int64, whence int) (ret int64, err error) {
+	f.owner.delay("File.Seek")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.rdwrFlag == O_CLOSED {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrClosed}
+	}
+
+	file := f.lockFile()
+	var (
+		newOffset int64
+		found     = true
+	)
+	switch whence {
+	case SEEK_SET:
 		newOffset = offset
-	} else if whence == 1 {
+	case SEEK_CUR:
 		newOffset = f.currPos + offset
-	} else if whence == 2 {
-		newOffset = int64(len(f.content)) + offset
+	case SEEK_END:
+		newOffset = file.data.len() + offset
+	case SEEK_DATA:
+		newOffset, found = file.data.seekData(offset)
+	case SEEK_HOLE:
+		newOffset, found = file.data.seekHole(offset)
+	default:
+		newOffset = -1
 	}
+	file.lock.Unlock()
 
+	if !found {
+		return 0, &os.PathError{
+			Op:   "seek",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.ENXIO),
+		}
+	}
 	if newOffset < 0 {
-		// aparently seeking past the end is an error?
-		return newOffset, &os.PathError{
+		return 0, &os.PathError{
 			Op:   "seek",
 			Path: f.name,
-			Err:  syscall.EINVAL,
+			Err:  syscall.Errno(syscall.EINVAL),
 		}
 	}
+	if f.file.isDir && newOffset == 0 {
+		// start listing the directory from the top again
+		f.dirents = nil
+	}
 	f.currPos = newOffset
 	return f.currPos, nil
 }
 
-func (f *fakeFile) Stat() (fi os.FileInfo, err error) {
-	return f, nil
+func (f *fakeHandle) Stat() (fi os.FileInfo, err error) {
+	f.owner.delay("File.Stat")
+	if f.closed() {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	file := f.lockFile()
+	fi = file.info(filepath.Base(f.name))
+	file.lock.Unlock()
+	return fi, nil
 }
 
-func (f *fakeFile) Sync() (err error) {
+func (f *fakeHandle) Sync() (err error) {
+	f.owner.delay("File.Sync")
+	if f.closed() {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
 	// nothing to do
 	return nil
 }
 
-func (f *fakeFile) Truncate(size int64) error {
-	// TODO(ttacon): do some testing with this function to see
-	// how the real one behaves
-
-	var newContents = make([]byte, size)
-	if size > int64(len(f.content)) {
-		size = int64(len(f.content))
+func (f *fakeHandle) Truncate(size int64) error {
+	f.owner.delay("File.Truncate")
+	if err := f.access("truncate", true); err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err != os.ErrClosed {
+			pe.Err = syscall.Errno(syscall.EINVAL)
+		}
+		return err
 	}
-	copy(newContents, f.content[:size])
-	f.content = newContents
-	return nil
-}
-
-func (f *fakeFile) Write(b []byte) (n int, err error) {
-	space := int64(len(f.content)) - f.currPos
-	if space > 0 {
-		copy(f.content[f.currPos:], b[:space])
-		f.content = append(f.content, b[:space]...)
-	} else {
-		f.content = append(f.content, b...)
+	if size < 0 {
+		return &os.PathError{
+			Op:   "truncate",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
 	}
-	f.currPos = int64(len(f.content))
-	return len(b), nil
-}
 
-func (f *fakeFile) WriteAt(b []byte, off int64) (n int, err error) {
-	// TODO(ttacon): do it
-	panic("UNIMPLEMENTED")
+	file := f.lockOwnFile()
+	file.resize(size)
+	now := f.owner.now()
+	file.modify, file.change = now, now
+	f.owner.notify(file.path, EventWrite)
+	file.lock.Unlock()
+	return nil
 }
 
-func (f *fakeFile) WriteString(s string) (ret int, err error) {
-	return f.Write([]byte(s))
+func (f *fakeHandle) Write(b []byte) (n int, err error) {
+	f.owner.delay("File.Write")
+	return f.write(b)
 }
 
-////////// to make life simpler with os.FileInfo
-func (f *fakeFile) Size() int64 {
-	return int64(len(f.content))
-}
+func (f *fakeHandle) write(b []byte) (int, error) {
+	f.lock.Lock()
+	if err := f.check("write", true); err != nil {
+		f.lock.Unlock()
+		return 0, err
+	}
 
-func (f *fakeFile) Mode() os.FileMode {
-	return f.mode
+	file := f.lockOwnFile()
+	if f.rdwrFlag&O_APPEND != 0 {
+		f.currPos = file.data.len()
+	}
+	file.writeAt(b, f.currPos, f.owner.now())
+	f.owner.notify(file.path, EventWrite)
+	file.lock.Unlock()
+	f.currPos += int64(len(b))
+	f.lock.Unlock()
+	f.owner.transfer(len(b), f.owner.writeRate)
+	return len(b), nil
 }
 
-func (f *fakeFile) ModTime() time.Time {
-	return f.modify
-}
+func (f *fakeHandle) WriteAt(b []byte, off int64) (n int, err error) {
+	f.owner.delay("File.WriteAt")
+	f.lock.Lock()
+	err = f.check("write", true)
+	appending := f.rdwrFlag&O_APPEND != 0
+	f.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if appending {
+		return 0, errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, &os.PathError{
+			Op:   "writeat",
+			Path: f.name,
+			Err:  errors.New("negative offset"),
+		}
+	}
 
-func (f *fakeFile) IsDir() bool {
-	return f.isDir
+	file := f.lockOwnFile()
+	file.writeAt(b, off, f.owner.now())
+	f.owner.notify(file.path, EventWrite)
+	file.lock.Unlock()
+	f.owner.transfer(len(b), f.owner.writeRate)
+	return len(b), nil
 }
 
-func (f *fakeFile) Sys() interface{} {
-	// TODO(ttacon): don't feel like doing this one right now
-	return nil
+func (f *fakeHandle) WriteString(s string) (ret int, err error) {
+	f.owner.delay("File.WriteString")
+	return f.write([]byte(s))
 }
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package fs
+
+// sys returns what the FileInfo of f reports as Sys, a *fileStat as there's
+// no system type to fill in off unix. Must be called with f.lock held.
+func (f *fakeFile) sys(size int64) interface{} {
+	return &fileStat{
+		ino:    f.ino,
+		uid:    f.uid,
+		gid:    f.gid,
+		blocks: f.data.blocks(),
+	}
+}
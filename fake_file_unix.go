@@ -0,0 +1,19 @@
+//go:build unix
+
+package fs
+
+import "syscall"
+
+// sys returns what the FileInfo of f reports as Sys, a *syscall.Stat_t
+// holding its inode, ownership, size and allocation. Must be called with
+// f.lock held.
+func (f *fakeFile) sys(size int64) interface{} {
+	return &syscall.Stat_t{
+		Ino:     f.ino,
+		Uid:     uint32(f.uid),
+		Gid:     uint32(f.gid),
+		Size:    size,
+		Blksize: chunkSize,
+		Blocks:  f.data.blocks(),
+	}
+}
@@ -0,0 +1,248 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WithBacking makes a FakeOS start out as a copy of the directory dir on
+// o, its root being dir, without copying anything up front: files and
+// directories are loaded as they're first looked up, opened or listed, the
+// contents of a file when it's first opened. From then on they live in
+// memory like any other file of the FakeOS, changes never reach o, and what
+// is removed isn't loaded again. The temporary directory of the FakeOS
+// hides the one of dir, if any.
+//
+// Symbolic links are loaded as they are, an absolute target then refers to
+// the FakeOS, not to o. Files sharing their inode on o share it once
+// loaded. See Loaded for what was read from o.
+func WithBacking(o OperatingSystem, dir string) FakeOption {
+	return func(d *fakeOS) {
+		d.backing = &backing{
+			o:      o,
+			dir:    filepath.Clean(dir),
+			inodes: map[[2]uint64]*fakeFile{},
+		}
+	}
+}
+
+// LoadReport is what a FakeOS created WithBacking read from its backing
+// directory, by path on the backing OperatingSystem, sorted.
+type LoadReport struct {
+	// Stat holds the files and directories that were looked up.
+	Stat []string
+
+	// Read holds the files whose contents were read.
+	Read []string
+
+	// Listed holds the directories whose entries were listed.
+	Listed []string
+}
+
+// Loaded returns what o, a FakeOS created WithBacking, read from its
+// backing directory so far. The report is empty for any other o.
+func Loaded(o OperatingSystem) LoadReport {
+	d, ok := o.(*fakeOS)
+	if !ok {
+		return LoadReport{}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.backing == nil {
+		return LoadReport{}
+	}
+	r := LoadReport{
+		Stat:   append([]string(nil), d.backing.report.Stat...),
+		Read:   append([]string(nil), d.backing.report.Read...),
+		Listed: append([]string(nil), d.backing.report.Listed...),
+	}
+	sort.Strings(r.Stat)
+	sort.Strings(r.Read)
+	sort.Strings(r.Listed)
+	return r
+}
+
+// backing is the directory a FakeOS loads its files from, see WithBacking.
+type backing struct {
+	o   OperatingSystem
+	dir string
+
+	// the files with several names, by device and inode
+	inodes map[[2]uint64]*fakeFile
+
+	report LoadReport
+}
+
+// lazyFile is what is left to load of a fakeFile: the contents of a file,
+// guarded by its lock, or the entries of a directory, guarded by the lock
+// of the fakeOS.
+type lazyFile struct {
+	path string // on the backing OperatingSystem
+	size int64
+
+	// the entries of a directory that were already loaded, or removed
+	seen map[string]bool
+}
+
+// attach makes root, the root directory of d, load its entries from the
+// backing directory.
+func (d *fakeOS) attach(root *fakeFile) {
+	root.lazy = &lazyFile{
+		path: d.backing.dir,
+		seen: map[string]bool{
+			filepath.Base(d.tmpDir): true,
+		},
+	}
+}
+
+// load loads the entry named name of the directory at dir if it wasn't
+// already. Must be called with d.lock held.
+func (d *fakeOS) load(dir, name string) error {
+	parent, _ := d.names.get(dir)
+	if parent == nil || parent.lazy == nil || parent.lazy.seen[name] {
+		return nil
+	}
+	// what's seen of the directory changes, see own
+	parent = d.own(d.view, parent)
+	if _, ok := d.names.get(filepath.Join(dir, name)); ok {
+		parent.lazy.seen[name] = true
+		return nil
+	}
+
+	path := filepath.Join(parent.lazy.path, name)
+	fi, err := d.backing.o.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	parent.lazy.seen[name] = true
+	if err != nil {
+		return nil
+	}
+	b := d.ownBacking()
+	b.report.Stat = append(b.report.Stat, path)
+
+	f, err := d.newBackedFile(path, fi)
+	if err != nil {
+		return err
+	}
+	d.add(filepath.Join(dir, name), f)
+	return nil
+}
+
+// newBackedFile returns the fakeFile for the file at path on the backing
+// OperatingSystem, whose FileInfo is fi.
+func (d *fakeOS) newBackedFile(path string, fi os.FileInfo) (*fakeFile, error) {
+	st, ok := sysStat(fi)
+	if ok && st.nlink > 1 && !fi.IsDir() {
+		if f := d.backing.inodes[[2]uint64{st.dev, st.ino}]; f != nil {
+			return d.own(d.view, f), nil
+		}
+	}
+
+	uid, gid := d.uid, d.gid
+	if ok {
+		uid, gid = st.uid, st.gid
+	}
+	f := newFakeFile(fi.Mode(), uid, gid, fi.ModTime())
+	switch {
+	case fi.IsDir():
+		f.isDir = true
+		f.lazy = &lazyFile{path: path, seen: map[string]bool{}}
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := d.backing.o.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		f.pointsTo = target
+	default:
+		f.lazy = &lazyFile{path: path, size: fi.Size()}
+	}
+
+	if ok && st.nlink > 1 && !fi.IsDir() {
+		f.linked = true
+		d.ownBacking().inodes[[2]uint64{st.dev, st.ino}] = f
+	}
+	return f, nil
+}
+
+// list loads all the entries of the directory at path. Must be called with
+// d.lock held.
+func (d *fakeOS) list(path string) error {
+	f, _ := d.names.get(path)
+	if f == nil || !f.isDir || f.lazy == nil {
+		return nil
+	}
+	f = d.own(d.view, f)
+
+	names, err := readDirNames(d.backing.o, f.lazy.path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := d.load(path, name); err != nil {
+			return err
+		}
+	}
+	b := d.ownBacking()
+	b.report.Listed = append(b.report.Listed, f.lazy.path)
+	f.lazy = nil
+	return nil
+}
+
+// fill reads the contents of f from o, the backing OperatingSystem, if
+// they weren't already, for the clones and snapshots sharing f too (see
+// own). Must be called without d.lock and f.lock held: reading may take
+// long, and the operations looking f up take f.lock with d.lock held. The
+// contents are installed unless f was filled or truncated meanwhile.
+func (d *fakeOS) fill(o OperatingSystem, f *fakeFile) error {
+	if f.isDir {
+		return nil
+	}
+	f.lock.Lock()
+	lazy := f.lazy
+	f.lock.Unlock()
+	if lazy == nil {
+		return nil
+	}
+
+	content, err := ReadFile(o, lazy.path)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	filled := f.lazy == lazy
+	if filled {
+		f.data.writeAt(content, 0)
+		f.lazy = nil
+	}
+	f.lock.Unlock()
+	if !filled {
+		return nil
+	}
+
+	d.lock.Lock()
+	b := d.ownBacking()
+	b.report.Read = append(b.report.Read, lazy.path)
+	d.lock.Unlock()
+	return nil
+}
+
+func readDirNames(o OperatingSystem, path string) ([]string, error) {
+	f, err := o.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// backingErr returns err, met loading what name refers to, as returned by
+// the operation op of a fakeOS.
+func backingErr(op, name string, err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,282 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// backedTree returns a directory holding a small project, and a FakeOS
+// backed by it.
+func backedTree(t *testing.T, opts ...FakeOption) (string, OperatingSystem) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":           "module example.com/app\n",
+		"main.go":          "package main\n",
+		"pkg/util.go":      "package pkg\n",
+		"pkg/util_test.go": "package pkg\n",
+		"docs/README":      "read me\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("docs/README", filepath.Join(dir, "README")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "main.go"), filepath.Join(dir, "main.go.bak")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, FakeOS(append(opts, WithBacking(DefaultOS(), dir))...)
+}
+
+func Test_FakeOS_Backing_Lazy(t *testing.T) {
+	dir, o := backedTree(t)
+
+	if r := Loaded(o); len(r.Stat)+len(r.Read)+len(r.Listed) != 0 {
+		t.Fatalf("loaded %+v before anything was accessed", r)
+	}
+
+	fi, err := o.Stat("/pkg/util.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len("package pkg\n")) || fi.Mode() != 0644 {
+		t.Errorf("got size %d and mode %v", fi.Size(), fi.Mode())
+	}
+	want := LoadReport{Stat: []string{
+		filepath.Join(dir, "pkg"),
+		filepath.Join(dir, "pkg/util.go"),
+	}}
+	if r := Loaded(o); !reflect.DeepEqual(r, want) {
+		t.Errorf("after a Stat, loaded %+v, expected %+v", r, want)
+	}
+
+	data, err := ReadFile(o, "/README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "read me\n" {
+		t.Errorf("read %q through the link", data)
+	}
+	r := Loaded(o)
+	if want := []string{filepath.Join(dir, "docs/README")}; !reflect.DeepEqual(r.Read, want) {
+		t.Errorf("read %v, expected %v", r.Read, want)
+	}
+	if r.Listed != nil {
+		t.Errorf("listed %v, expected nothing", r.Listed)
+	}
+}
+
+func Test_FakeOS_Backing_Readdir(t *testing.T) {
+	dir, o := backedTree(t)
+
+	names, err := ReadDir(o, "/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Name() != "util.go" || names[1].Name() != "util_test.go" {
+		t.Errorf("listed %v", names)
+	}
+	r := Loaded(o)
+	if want := []string{filepath.Join(dir, "pkg")}; !reflect.DeepEqual(r.Listed, want) {
+		t.Errorf("listed %v, expected %v", r.Listed, want)
+	}
+	if r.Read != nil {
+		t.Errorf("listing read %v", r.Read)
+	}
+
+	var paths []string
+	err = Walk(o, "/", func(path string, fi os.FileInfo, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/", "/README", "/docs", "/docs/README", "/go.mod", "/main.go",
+		"/main.go.bak", "/pkg", "/pkg/util.go", "/pkg/util_test.go", "/tmp",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("walked %v, expected %v", paths, want)
+	}
+}
+
+func Test_FakeOS_Backing_Changes(t *testing.T) {
+	dir, o := backedTree(t)
+
+	if err := WriteFile(o, "/main.go", []byte("package app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Remove("/go.mod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Rename("/pkg", "/internal"); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing reaches the backing directory
+	if data, _ := os.ReadFile(filepath.Join(dir, "main.go")); string(data) != "package main\n" {
+		t.Errorf("the backing main.go holds %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		t.Errorf("the backing go.mod is gone: %v", err)
+	}
+
+	// what's removed isn't loaded again
+	if _, err := o.Stat("/go.mod"); !os.IsNotExist(err) {
+		t.Errorf("expected go.mod to be removed, got %v", err)
+	}
+	if _, err := o.Stat("/pkg/util.go"); !os.IsNotExist(err) {
+		t.Errorf("expected pkg to be renamed, got %v", err)
+	}
+
+	// what's renamed is loaded from where it was
+	data, err := ReadFile(o, "/internal/util.go")
+	if err != nil || string(data) != "package pkg\n" {
+		t.Errorf("read %q, %v from the renamed directory", data, err)
+	}
+
+	// truncating needs no read, and hard links share their contents
+	r := Loaded(o)
+	if want := []string{filepath.Join(dir, "pkg/util.go")}; !reflect.DeepEqual(r.Read, want) {
+		t.Errorf("read %v, expected %v", r.Read, want)
+	}
+	data, err = ReadFile(o, "/main.go.bak")
+	if err != nil || string(data) != "package app\n" {
+		t.Errorf("read %q, %v through the hard link", data, err)
+	}
+}
+
+func Test_FakeOS_Backing_Semantics(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, o := backedTree(t, WithClock(clock))
+
+	if err := o.Mkdir("/pkg", 0755); !os.IsExist(err) {
+		t.Errorf("expected EEXIST creating a directory of the backing one, got %v", err)
+	}
+	if err := o.Remove("/docs"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY removing a backing directory, got %v", err)
+	}
+
+	// the virtual clock applies to the loaded files
+	clock.Advance(time.Hour)
+	if err := WriteFile(o, "/go.mod", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := o.Stat("/go.mod")
+	if err != nil || !fi.ModTime().Equal(clock.Now()) {
+		t.Errorf("got %v, %v", fi, err)
+	}
+}
+
+func Test_FakeOS_Backing_ListError(t *testing.T) {
+	b := FakeOS()
+	if err := b.MkdirAll("/src/pkg", 0755); err != nil {
+		t.Fatal(err)
+	}
+	o := FakeOS(WithBacking(b, "/src"))
+	if _, err := o.Stat("/pkg"); err != nil {
+		t.Fatal(err)
+	}
+	o.Mkdir("/empty", 0755)
+
+	// the directory can no longer be listed once looked up
+	b.RemoveAll("/src/pkg")
+	if _, err := ReadDir(o, "/pkg"); !os.IsNotExist(err) {
+		t.Errorf("expected ENOENT listing, got %v", err)
+	}
+	if err := o.Remove("/pkg"); !os.IsNotExist(err) {
+		t.Errorf("expected ENOENT removing, got %v", err)
+	}
+	if err := o.Rename("/empty", "/pkg"); !os.IsNotExist(err) {
+		t.Errorf("expected ENOENT replacing, got %v", err)
+	}
+}
+
+// blockingOS blocks opening block until release is closed, having told
+// reading.
+type blockingOS struct {
+	OperatingSystem
+	block            string
+	reading, release chan struct{}
+}
+
+func (o *blockingOS) Open(name string) (File, error) {
+	return o.OpenFile(name, O_RDONLY, 0)
+}
+
+func (o *blockingOS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if name == o.block {
+		close(o.reading)
+		<-o.release
+	}
+	return o.OperatingSystem.OpenFile(name, flag, perm)
+}
+
+func Test_FakeOS_Backing_ReadUnlocked(t *testing.T) {
+	b := FakeOS()
+	b.MkdirAll("/src", 0755)
+	WriteFile(b, "/src/big", []byte("big"), 0644)
+	WriteFile(b, "/src/small", []byte("small"), 0644)
+	slow := &blockingOS{
+		OperatingSystem: b,
+		block:           "/src/big",
+		reading:         make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+	o := FakeOS(WithBacking(slow, "/src"))
+
+	read := make(chan error)
+	go func() {
+		got, err := ReadFile(o, "/big")
+		if err == nil && string(got) != "big" {
+			err = errors.New("unexpected contents " + string(got))
+		}
+		read <- err
+	}()
+	<-slow.reading
+
+	// the FakeOS goes on while the contents of /big are read
+	done := make(chan error)
+	go func() {
+		_, err := ReadFile(o, "/small")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("failed to read /small, err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected reading /small not to wait for /big")
+	}
+
+	close(slow.release)
+	if err := <-read; err != nil {
+		t.Errorf("failed to read /big, err: %v", err)
+	}
+	if want := []string{"/src/big", "/src/small"}; !reflect.DeepEqual(Loaded(o).Read, want) {
+		t.Errorf("expected %v to be read, got %v", want, Loaded(o).Read)
+	}
+}
+
+func Test_FakeOS_Loaded_NotBacked(t *testing.T) {
+	if r := Loaded(FakeOS()); !reflect.DeepEqual(r, LoadReport{}) {
+		t.Errorf("got %+v", r)
+	}
+	if r := Loaded(DefaultOS()); !reflect.DeepEqual(r, LoadReport{}) {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,255 @@
+package fs
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// fakeRange is a byte-range lock on a fakeFile, covering [off, end).
+type fakeRange struct {
+	owner     *fakeHandle
+	off, end  int64
+	exclusive bool
+}
+
+func (r fakeRange) overlaps(off, end int64) bool {
+	return r.off < end && off < r.end
+}
+
+// Lock takes an exclusive lock on the whole file, waiting for the locks
+// of other handles to be released.
+func (f *fakeHandle) Lock() error {
+	f.owner.delay("File.Lock")
+	return f.flock(LOCK_EX)
+}
+
+// RLock takes a shared lock on the whole file, waiting for an exclusive
+// lock of another handle to be released.
+func (f *fakeHandle) RLock() error {
+	f.owner.delay("File.RLock")
+	return f.flock(LOCK_SH)
+}
+
+// TryLock takes an exclusive lock on the whole file, failing with
+// EWOULDBLOCK if another handle holds a lock on it.
+func (f *fakeHandle) TryLock() error {
+	f.owner.delay("File.TryLock")
+	return f.flock(LOCK_EX | LOCK_NB)
+}
+
+// Unlock releases the lock f holds on the whole file, if any.
+func (f *fakeHandle) Unlock() error {
+	f.owner.delay("File.Unlock")
+	return f.flock(LOCK_UN)
+}
+
+// flock locks the whole file as flock(2) does: a lock held by f is
+// converted rather than stacked, and not atomically, it's released before
+// waiting for the new one.
+func (f *fakeHandle) flock(how int) error {
+	if op := how &^ LOCK_NB; op != LOCK_SH && op != LOCK_EX && op != LOCK_UN {
+		return &os.PathError{
+			Op:   "flock",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
+	}
+
+	d := f.owner
+	d.lock.Lock()
+	if f.rdwrFlag == O_CLOSED {
+		d.lock.Unlock()
+		return &os.PathError{Op: "flock", Path: f.name, Err: os.ErrClosed}
+	}
+
+	// the file changes in d, so it's copied first if shared, see own
+	file := current(f.view, f.file)
+	if _, ok := file.flocks[f]; ok {
+		file = d.own(f.view, file)
+		delete(file.flocks, f)
+		d.wakeUp()
+	}
+	if how&^LOCK_NB == LOCK_UN {
+		d.lock.Unlock()
+		return nil
+	}
+
+	exclusive := how&^LOCK_NB == LOCK_EX
+	// a shared file holds the locks of other views too, its copy only those
+	// of the files open in the view of f, see fakeFile.clone
+	for file = d.own(f.view, file); file.flockConflict(exclusive); file = d.own(f.view, file) {
+		if how&LOCK_NB != 0 {
+			d.lock.Unlock()
+			return &os.PathError{
+				Op:   "flock",
+				Path: f.name,
+				Err:  errWouldBlock,
+			}
+		}
+		d.wait()
+	}
+
+	if file.flocks == nil {
+		file.flocks = map[*fakeHandle]int{}
+	}
+	file.flocks[f] = how &^ LOCK_NB
+	d.lock.Unlock()
+	return nil
+}
+
+// wait waits for a lock to be released. Must be called with d.lock held.
+func (d *fakeOS) wait() {
+	if d.sched == nil {
+		d.unlocked.Wait()
+		return
+	}
+
+	// the goroutine holding the lock needs to be switched to
+	d.lock.Unlock()
+	d.sched.yield("wait", true)
+	d.lock.Lock()
+}
+
+// wakeUp wakes up whoever waits for a lock, as one was released. Must be
+// called with d.lock held.
+func (d *fakeOS) wakeUp() {
+	d.unlocked.Broadcast()
+	if d.sched != nil {
+		d.sched.wake()
+	}
+}
+
+// flockConflict returns whether a whole file lock, exclusive or not,
+// conflicts with the ones held on f. Must be called with the owner's lock
+// held.
+func (f *fakeFile) flockConflict(exclusive bool) bool {
+	for _, how := range f.flocks {
+		if exclusive || how == LOCK_EX {
+			return true
+		}
+	}
+	return false
+}
+
+// LockRange locks the n bytes at off as fcntl(2) does, up to the end of
+// the file (however it grows) if n is 0. The ranges f already holds are
+// replaced where they overlap the new one, or released with LOCK_UN.
+func (f *fakeHandle) LockRange(off, n int64, how int) error {
+	f.owner.delay("File.LockRange")
+	if n < 0 {
+		off, n = off+n, -n
+	}
+	end := off + n
+	if n == 0 || end < off {
+		end = math.MaxInt64
+	}
+
+	exclusive := how&^LOCK_NB == LOCK_EX
+	if off < 0 || (!exclusive && how&^LOCK_NB != LOCK_SH && how&^LOCK_NB != LOCK_UN) {
+		return &os.PathError{
+			Op:   "fcntl",
+			Path: f.name,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
+	}
+
+	d := f.owner
+	d.lock.Lock()
+
+	// as with fcntl, a lock needs the file to be open for the matching kind
+	// of access
+	if how&^LOCK_NB == LOCK_UN {
+		if f.rdwrFlag == O_CLOSED {
+			d.lock.Unlock()
+			return &os.PathError{Op: "fcntl", Path: f.name, Err: os.ErrClosed}
+		}
+	} else if err := f.check("fcntl", exclusive); err != nil {
+		d.lock.Unlock()
+		return err
+	}
+
+	file := d.own(f.view, f.file)
+	if how&^LOCK_NB != LOCK_UN {
+		for ; file.rangeConflict(f, off, end, exclusive); file = d.own(f.view, file) {
+			if how&LOCK_NB != 0 {
+				d.lock.Unlock()
+				return &os.PathError{
+					Op:   "fcntl",
+					Path: f.name,
+					Err:  errWouldBlock,
+				}
+			}
+			d.wait()
+		}
+	}
+
+	// carve [off, end) out of the ranges f holds, then add the new one
+	var ranges []fakeRange
+	for _, r := range file.ranges {
+		if r.owner != f || !r.overlaps(off, end) {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.off < off {
+			ranges = append(ranges, fakeRange{f, r.off, off, r.exclusive})
+		}
+		if end < r.end {
+			ranges = append(ranges, fakeRange{f, end, r.end, r.exclusive})
+		}
+	}
+	if how&^LOCK_NB != LOCK_UN {
+		ranges = append(ranges, fakeRange{f, off, end, exclusive})
+	}
+	file.ranges = ranges
+	d.wakeUp()
+	d.lock.Unlock()
+	return nil
+}
+
+// rangeConflict returns whether a lock on [off, end) taken by h conflicts
+// with the ranges other handles hold on f. Must be called with the owner's
+// lock held.
+func (f *fakeFile) rangeConflict(h *fakeHandle, off, end int64, exclusive bool) bool {
+	for _, r := range f.ranges {
+		if r.owner != h && r.overlaps(off, end) && (exclusive || r.exclusive) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseLocks releases every lock f holds, waking up whoever waits for
+// them. Must be called with the owner's lock held.
+func (f *fakeHandle) releaseLocks() {
+	if f.file == nil {
+		return
+	}
+	file := current(f.view, f.file)
+	_, held := file.flocks[f]
+	for _, r := range file.ranges {
+		held = held || r.owner == f
+	}
+	if !held {
+		return
+	}
+	file = f.owner.own(f.view, file)
+
+	released := false
+	if _, ok := file.flocks[f]; ok {
+		delete(file.flocks, f)
+		released = true
+	}
+	ranges := file.ranges[:0]
+	for _, r := range file.ranges {
+		if r.owner == f {
+			released = true
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	file.ranges = ranges
+	if released {
+		f.owner.wakeUp()
+	}
+}
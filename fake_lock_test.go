@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_FakeOS_Lock(t *testing.T) {
+	o := FakeOS()
+	a, _ := o.Create("/tmp/app.lock")
+	b, _ := o.Open("/tmp/app.lock")
+
+	if err := a.Lock(); err != nil {
+		t.Fatalf("failed to lock, err: %v", err)
+	}
+	if err := b.TryLock(); !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK, err: %v", err)
+	}
+
+	// b waits until a's lock is released by Close
+	locked := make(chan error)
+	go func() {
+		locked <- b.RLock()
+	}()
+	select {
+	case err := <-locked:
+		t.Fatalf("expected RLock to wait, err: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	a.Close()
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("failed to lock, err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RLock still waiting after the lock was released")
+	}
+
+	// shared locks don't conflict with each other
+	c, _ := o.Open("/tmp/app.lock")
+	if err := c.RLock(); err != nil {
+		t.Errorf("failed to share the lock, err: %v", err)
+	}
+	if err := c.TryLock(); !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK upgrading, err: %v", err)
+	}
+	b.Unlock()
+	if err := c.TryLock(); err != nil {
+		t.Errorf("failed to upgrade, err: %v", err)
+	}
+
+	// neither shared nor exclusive
+	for _, how := range []int{0, LOCK_SH | LOCK_EX, LOCK_NB} {
+		if err := c.(*fakeHandle).flock(how); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("flock(%d): expected EINVAL, err: %v", how, err)
+		}
+	}
+
+	c.Close()
+	if err := c.Lock(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected ErrClosed, err: %v", err)
+	}
+}
+
+func Test_FakeOS_LockRange(t *testing.T) {
+	o := FakeOS()
+	a, _ := o.Create("/tmp/db")
+	b, _ := o.OpenFile("/tmp/db", O_RDWR, 0)
+
+	if err := a.LockRange(0, 100, LOCK_EX); err != nil {
+		t.Fatalf("failed to lock, err: %v", err)
+	}
+	if err := b.LockRange(100, 0, LOCK_EX|LOCK_NB); err != nil {
+		t.Errorf("expected disjoint ranges not to conflict, err: %v", err)
+	}
+	if err := b.LockRange(50, 10, LOCK_SH|LOCK_NB); !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK, err: %v", err)
+	}
+
+	// unlocking the middle of a range keeps both ends locked
+	a.LockRange(40, 30, LOCK_UN)
+	if err := b.LockRange(50, 10, LOCK_SH|LOCK_NB); err != nil {
+		t.Errorf("failed to lock the released bytes, err: %v", err)
+	}
+	if err := b.LockRange(30, 20, LOCK_SH|LOCK_NB); !errors.Is(err, errWouldBlock) {
+		t.Errorf("expected EWOULDBLOCK, err: %v", err)
+	}
+
+	// whole file locks are independent of range ones
+	if err := b.TryLock(); err != nil {
+		t.Errorf("failed to lock the whole file, err: %v", err)
+	}
+
+	r, _ := o.Open("/tmp/db")
+	if err := r.LockRange(0, 1, LOCK_EX); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("expected EBADF locking a read only file, err: %v", err)
+	}
+	if err := r.LockRange(0, 1, 42); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL, err: %v", err)
+	}
+}
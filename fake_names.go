@@ -0,0 +1,94 @@
+package fs
+
+// maxNamesDepth is the number of layers of names past which they're
+// flattened when shared, so that lookups don't slow down as clones and
+// snapshots pile up.
+const maxNamesDepth = 8
+
+// fakeNames maps the clean absolute paths of a fakeOS to its files. Clones
+// and snapshots share names copy-on-write: the names they start from are
+// frozen, and each adds the changes it makes in a layer of its own on top,
+// see layer.
+type fakeNames struct {
+	parent *fakeNames
+	files  map[string]*fakeFile // nil for the names removed from parent
+	depth  int
+}
+
+func newNames() *fakeNames {
+	return &fakeNames{files: map[string]*fakeFile{}}
+}
+
+// get returns the file named path.
+func (n *fakeNames) get(path string) (*fakeFile, bool) {
+	for l := n; l != nil; l = l.parent {
+		if f, ok := l.files[path]; ok {
+			return f, f != nil
+		}
+	}
+	return nil, false
+}
+
+// set names f path.
+func (n *fakeNames) set(path string, f *fakeFile) {
+	n.files[path] = f
+}
+
+// remove removes the name path.
+func (n *fakeNames) remove(path string) {
+	if n.parent != nil {
+		if _, ok := n.parent.get(path); ok {
+			n.files[path] = nil
+			return
+		}
+	}
+	delete(n.files, path)
+}
+
+// each calls fn with every name and the file it names, in no particular
+// order. fn mustn't change n.
+func (n *fakeNames) each(fn func(path string, f *fakeFile)) {
+	if n.parent == nil {
+		for path, f := range n.files {
+			fn(path, f)
+		}
+		return
+	}
+
+	seen := map[string]bool{}
+	for l := n; l != nil; l = l.parent {
+		for path, f := range l.files {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if f != nil {
+				fn(path, f)
+			}
+		}
+	}
+}
+
+// layer returns names starting out as n, which mustn't be changed from then
+// on.
+func (n *fakeNames) layer() *fakeNames {
+	return &fakeNames{
+		parent: n,
+		files:  map[string]*fakeFile{},
+		depth:  n.depth + 1,
+	}
+}
+
+// frozen returns names to share in place of n, which mustn't be changed
+// from then on: n itself, or a flattened copy of it once it's
+// maxNamesDepth layers deep.
+func (n *fakeNames) frozen() *fakeNames {
+	if n.depth < maxNamesDepth {
+		return n
+	}
+	flat := newNames()
+	n.each(func(path string, f *fakeFile) {
+		flat.files[path] = f
+	})
+	return flat
+}
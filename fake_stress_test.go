@@ -0,0 +1,240 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests are meant to be run with -race, they also check that the
+// results are consistent.
+
+// stress runs fn(i) for i in [0, n) concurrently.
+func stress(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_FakeOS_Stress_Files(t *testing.T) {
+	o := FakeOS()
+	if err := o.MkdirAll("/work", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 16
+	errs := make(chan error, workers)
+	stress(workers, func(i int) {
+		name := fmt.Sprintf("/work/%d", i)
+		f, err := o.Create(name)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer f.Close()
+
+		want := bytes.Repeat([]byte{byte('a' + i)}, 100)
+		for j := 0; j < 50; j++ {
+			f.Write(want)
+			f.Chmod(0600)
+			o.Chtimes(name, o.(*fakeOS).now(), o.(*fakeOS).now())
+			if _, err := o.Stat(name); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := ReadDir(o, "/work"); err != nil {
+				errs <- err
+				return
+			}
+		}
+
+		got := make([]byte, len(want))
+		if _, err := f.ReadAt(got, 49*100); err != nil || !bytes.Equal(got, want) {
+			errs <- fmt.Errorf("%s: read %q, %v", name, got, err)
+		}
+		if fi, _ := f.Stat(); fi.Size() != 50*100 {
+			errs <- fmt.Errorf("%s: got size %d", name, fi.Size())
+		}
+	})
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func Test_FakeOS_Stress_SharedHandle(t *testing.T) {
+	o := FakeOS()
+	f, err := o.OpenFile("/log", O_RDWR|O_CREATE|O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte("0123456789\n")
+	stress(8, func(int) {
+		for j := 0; j < 100; j++ {
+			f.Write(line)
+			f.Seek(0, SEEK_CUR)
+			f.Stat()
+		}
+	})
+	f.Close()
+
+	data, err := ReadFile(o, "/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat(line, 8*100); !bytes.Equal(data, want) {
+		t.Errorf("appends were lost or interleaved: got %d bytes, expected %d", len(data), len(want))
+	}
+}
+
+func Test_FakeOS_Stress_Rename(t *testing.T) {
+	o := FakeOS()
+	for _, name := range []string{"/a", "/b", "/c"} {
+		if err := WriteFile(o, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// renames in every direction, replacing each other, while the files
+	// are opened and written
+	names := []string{"/a", "/b", "/c"}
+	stress(12, func(i int) {
+		for j := 0; j < 100; j++ {
+			from, to := names[(i+j)%3], names[(i+j+1)%3]
+			switch i % 3 {
+			case 0:
+				o.Rename(from, to)
+			case 1:
+				if f, err := o.OpenFile(from, O_WRONLY, 0); err == nil {
+					f.WriteAt([]byte("x"), 0)
+					f.Close()
+				}
+			case 2:
+				o.Link(from, to)
+				o.Remove(to)
+			}
+		}
+	})
+
+	infos, err := readDir(o, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range infos {
+		if fi.Name() == "tmp" {
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			t.Errorf("%s has mode %v", fi.Name(), fi.Mode())
+		}
+	}
+}
+
+func Test_FakeOS_Stress_Chdir(t *testing.T) {
+	o := FakeOS()
+	for _, dir := range []string{"/a", "/b"} {
+		if err := o.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stress(8, func(i int) {
+		for j := 0; j < 100; j++ {
+			if i%2 == 0 {
+				o.Chdir([]string{"/a", "/b"}[(i+j)%2])
+				continue
+			}
+			if wd, err := o.Getwd(); err != nil || (wd != "/a" && wd != "/b" && wd != "/") {
+				t.Errorf("Getwd returned %q, %v", wd, err)
+			}
+		}
+	})
+}
+
+func Test_FakeOS_Stress_Watch(t *testing.T) {
+	o := FakeOS()
+	w, err := Watch(o, "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stress(8, func(i int) {
+		name := fmt.Sprintf("/%d", i)
+		f, err := o.Create(name)
+		if err != nil {
+			return
+		}
+		for j := 0; j < 10; j++ {
+			f.Write([]byte("x"))
+		}
+		f.Close()
+		o.Remove(name)
+	})
+
+	// a create, ten writes and a remove each, in that order
+	counts := map[string]int{}
+	for i := 0; i < 8*12; i++ {
+		select {
+		case e := <-w.Events():
+			switch n := counts[e.Name]; {
+			case n == 0 && e.Op != EventCreate,
+				n > 0 && n < 11 && e.Op != EventWrite,
+				n == 11 && e.Op != EventRemove:
+				t.Errorf("got %v after %d events", e, n)
+			}
+			counts[e.Name]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d events, expected %d", i, 8*12)
+		}
+	}
+	w.Close()
+}
+
+func Test_FakeOS_Stress_Clone(t *testing.T) {
+	o := FakeOS()
+	if err := WriteFile(o, "/f", bytes.Repeat([]byte("a"), 3*chunkSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := o.OpenFile("/f", O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stress(8, func(i int) {
+		if i%2 == 0 {
+			for j := 0; j < 50; j++ {
+				f.WriteAt([]byte("b"), int64(j*100))
+			}
+			return
+		}
+		for j := 0; j < 10; j++ {
+			c := o.(Cloneable).Clone()
+			WriteFile(c, "/f", []byte("c"), 0644)
+			if data, _ := ReadFile(c, "/f"); string(data) != "c" {
+				t.Errorf("the clone reads %q", data)
+			}
+		}
+	})
+
+	data, err := ReadFile(o, "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3*chunkSize || bytes.Contains(data, []byte("c")) {
+		t.Errorf("writing the clones changed the original")
+	}
+	if _, err := o.Stat("/f"); os.IsNotExist(err) {
+		t.Errorf("the original is gone")
+	}
+}
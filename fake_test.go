@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"io"
 	"os"
 	"testing"
 )
@@ -87,3 +88,123 @@ func Test_FakeOs_Clearenv(t *testing.T) {
 			fr.envVars)
 	}
 }
+
+func Test_FakeOs_CreateRead(t *testing.T) {
+	f := FakeOS()
+	file, err := f.Create("/tmp/hello")
+	if err != nil {
+		t.Fatalf("failed to create file, err: %v", err)
+	}
+	file.WriteString("hello, world")
+	file.Close()
+
+	file, err = f.Open("/tmp/hello")
+	if err != nil {
+		t.Fatalf("failed to open file, err: %v", err)
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		t.Errorf("failed to read file, err: %v", err)
+	}
+	if string(b) != "hello, world" {
+		t.Errorf("expected \"hello, world\", was: %q", b)
+	}
+
+	if _, err := file.Write([]byte("nope")); err == nil {
+		t.Errorf("expected write to a read-only file to fail")
+	}
+}
+
+func Test_FakeOs_Stat(t *testing.T) {
+	f := FakeOS()
+	if err := f.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatalf("failed to MkdirAll, err: %v", err)
+	}
+
+	fi, err := f.Stat("/a/b")
+	if err != nil {
+		t.Fatalf("failed to Stat, err: %v", err)
+	}
+	if !fi.IsDir() || fi.Name() != "b" || fi.Mode().Perm() != 0755 {
+		t.Errorf("unexpected FileInfo: %v %v %v", fi.Name(), fi.IsDir(), fi.Mode())
+	}
+
+	if _, err := f.Stat("/a/c"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, was: %v", err)
+	}
+}
+
+func Test_FakeOs_Readdir(t *testing.T) {
+	f := FakeOS()
+	f.MkdirAll("/a/c", 0755)
+	f.Create("/a/b")
+	f.Create("/a/c/d")
+
+	dir, err := f.Open("/a")
+	if err != nil {
+		t.Fatalf("failed to open directory, err: %v", err)
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("failed to Readdirnames, err: %v", err)
+	}
+	if len(names) != 2 || names[0] != "b" || names[1] != "c" {
+		t.Errorf("expected [b c], was: %v", names)
+	}
+
+	// an open directory keeps listing its entries once renamed
+	moved, _ := f.Open("/a/c")
+	defer moved.Close()
+	f.Rename("/a", "/e")
+	names, err = moved.Readdirnames(-1)
+	if err != nil || len(names) != 1 || names[0] != "d" {
+		t.Errorf("expected [d] after renaming, was: %v, err: %v", names, err)
+	}
+}
+
+func Test_FakeOs_Symlink(t *testing.T) {
+	f := FakeOS()
+	f.MkdirAll("/a/b", 0755)
+	f.Create("/a/b/c")
+
+	if err := f.Symlink("b", "/a/link"); err != nil {
+		t.Fatalf("failed to Symlink, err: %v", err)
+	}
+	if _, err := f.Stat("/a/link/c"); err != nil {
+		t.Errorf("expected to Stat through the symlink, err: %v", err)
+	}
+
+	fi, err := f.Lstat("/a/link")
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected Lstat to return the symlink, was: %v, err: %v", fi, err)
+	}
+	if err := f.Symlink("b", "/a/link"); !os.IsExist(err) {
+		t.Errorf("expected symlinking over an existing file to fail, err: %v", err)
+	}
+}
+
+func Test_FakeOs_RenameRemove(t *testing.T) {
+	f := FakeOS()
+	f.MkdirAll("/a/b", 0755)
+	f.Create("/a/b/c")
+
+	if err := f.Remove("/a"); err == nil {
+		t.Errorf("expected removing a non-empty directory to fail")
+	}
+	if err := f.Rename("/a", "/z"); err != nil {
+		t.Fatalf("failed to Rename, err: %v", err)
+	}
+	if _, err := f.Stat("/z/b/c"); err != nil {
+		t.Errorf("expected children to move along, err: %v", err)
+	}
+	if err := f.RemoveAll("/z"); err != nil {
+		t.Fatalf("failed to RemoveAll, err: %v", err)
+	}
+	if _, err := f.Stat("/z/b"); !os.IsNotExist(err) {
+		t.Errorf("expected /z/b to be gone, err: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package fs
+
+// Watch reports the changes made to the file or directory at name, see
+// Watchable. Events are queued as the changes are made, before the call
+// making them returns.
+func (d *fakeOS) Watch(name string, recursive bool) (Watcher, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	path, _, err := d.lookup("watch", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return d.watches.add(name, path, recursive), nil
+}
+
+// notify tells the watches concerned that op happened to the file at path.
+// Must be called holding the lock guarding what changed, so that events
+// are queued in the order of the changes.
+func (d *fakeOS) notify(path string, op EventOp) {
+	if path == "" {
+		return // an open file without a name left
+	}
+	d.watches.notify(path, op)
+}
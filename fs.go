@@ -29,6 +29,18 @@ const (
 	SEEK_END int = 2 // seek relative to the end
 )
 
+//     Lock operations of LockRange, as for flock. Locks are advisory, held by
+//     the open file (not the process) and released when it is closed. Whole
+//     file locks (Lock, RLock, TryLock and Unlock) and byte-range locks
+//     (LockRange) are independent of each other. The values are those of
+//     flock on unix, so that they're the same on every system.
+const (
+	LOCK_SH int = 0x1 // take a shared lock.
+	LOCK_EX int = 0x2 // take an exclusive lock.
+	LOCK_NB int = 0x4 // fail with EWOULDBLOCK rather than wait.
+	LOCK_UN int = 0x8 // release the lock.
+)
+
 const (
 	PathSeparator     = '/' // OS-specific path separator
 	PathListSeparator = ':' // OS-specific path list separator
@@ -101,7 +113,10 @@ type File interface {
 	Chown(uid, gid int) error
 	Close() error
 	Fd() uintptr
+	Lock() error
+	LockRange(off, n int64, how int) error
 	Name() string
+	RLock() error
 	Read(b []byte) (n int, err error)
 	ReadAt(b []byte, off int64) (n int, err error)
 	Readdir(n int) (fi []os.FileInfo, err error)
@@ -110,6 +125,8 @@ type File interface {
 	Stat() (fi os.FileInfo, err error)
 	Sync() (err error)
 	Truncate(size int64) error
+	TryLock() error
+	Unlock() error
 	Write(b []byte) (n int, err error)
 	WriteAt(b []byte, off int64) (n int, err error)
 	WriteString(s string) (ret int, err error)
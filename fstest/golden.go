@@ -0,0 +1,139 @@
+package fstest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttacon/fs"
+)
+
+// Update makes AssertTree rewrite the golden trees from the actual ones
+// rather than compare them. AssertTree also updates when the tests run with
+// -update, or with FSTEST_UPDATE=1 in their environment.
+var Update = os.Getenv("FSTEST_UPDATE") == "1"
+
+// init declares -update, unless a package initialized before fstest
+// already has. Packages using AssertTree get the flag from here, and
+// mustn't declare their own.
+func init() {
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "rewrite the golden trees compared by fstest.AssertTree")
+	}
+}
+
+// updating reports whether the golden trees are to be rewritten, see
+// Update. The -update flag may be another package's, see init.
+func updating() bool {
+	if Update {
+		return true
+	}
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	on, _ := g.Get().(bool)
+	return on
+}
+
+// ignored are the attributes AssertTree doesn't compare by default, as
+// they depend on the checkout of the golden files more than on the code
+// under test.
+const ignored = fs.AttrModTime | fs.AttrOwner | fs.AttrMode
+
+// AssertTree fails t if the tree rooted at root on o doesn't match golden,
+// printing how they differ. Golden is a directory on disk, or a snapshot
+// of one if it ends in ".txtar", as written by fs.ToTxtar. Modification
+// times, owners and modes aren't compared, opts may ignore other
+// attributes. As git doesn't keep empty directories, a tree holding some is
+// best kept as a snapshot.
+//
+// When updating, see Update, golden is rewritten from the actual tree
+// instead.
+func AssertTree(t testing.TB, o fs.OperatingSystem, root, golden string, opts ...fs.DiffOption) {
+	t.Helper()
+
+	if strings.HasSuffix(golden, ".txtar") {
+		assertSnapshot(t, o, root, golden, opts)
+		return
+	}
+
+	disk := fs.DefaultOS()
+	if updating() {
+		if err := disk.RemoveAll(golden); err != nil {
+			t.Fatalf("failed to remove %s, err: %v", golden, err)
+		}
+		if err := fs.CopyTree(o, root, disk, golden, fs.PreserveSymlinks(), fs.PreserveMode()); err != nil {
+			t.Fatalf("failed to update %s, err: %v", golden, err)
+		}
+		return
+	}
+
+	if _, err := disk.Stat(golden); err != nil {
+		t.Fatalf("missing golden tree %s, run with -update to create it, err: %v", golden, err)
+	}
+	opts = append([]fs.DiffOption{fs.IgnoreAttrs(ignored)}, opts...)
+	changes, err := fs.Diff(disk, o, golden, root, opts...)
+	if err != nil {
+		t.Fatalf("failed to compare %s with %s, err: %v", root, golden, err)
+	}
+	if len(changes) > 0 {
+		t.Errorf("%s doesn't match %s, run with -update to accept the changes:\n%s",
+			root, golden, fs.FormatDiff(disk, o, golden, root, changes))
+	}
+}
+
+func assertSnapshot(t testing.TB, o fs.OperatingSystem, root, golden string, opts []fs.DiffOption) {
+	t.Helper()
+
+	actual, err := fs.ToTxtar(o, root)
+	if err != nil {
+		t.Fatalf("failed to snapshot %s, err: %v", root, err)
+	}
+
+	disk := fs.DefaultOS()
+	if updating() {
+		if err := disk.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("failed to update %s, err: %v", golden, err)
+		}
+		if err := fs.WriteFileAtomic(disk, golden, actual, 0644); err != nil {
+			t.Fatalf("failed to update %s, err: %v", golden, err)
+		}
+		return
+	}
+
+	expected, err := fs.ReadFile(disk, golden)
+	if err != nil {
+		t.Fatalf("missing golden snapshot %s, run with -update to create it, err: %v", golden, err)
+	}
+	if bytes.Equal(expected, actual) {
+		return
+	}
+
+	// both go through the same encoding, so that only what they hold differs
+	a, err := fs.FromTxtar(expected)
+	if err != nil {
+		t.Fatalf("failed to read %s, err: %v", golden, err)
+	}
+	b, err := fs.FromTxtar(actual)
+	if err != nil {
+		t.Fatalf("failed to read the snapshot of %s, err: %v", root, err)
+	}
+	opts = append([]fs.DiffOption{fs.IgnoreAttrs(ignored)}, opts...)
+	changes, err := fs.Diff(a, b, "/", "/", opts...)
+	if err != nil {
+		t.Fatalf("failed to compare %s with %s, err: %v", root, golden, err)
+	}
+	if len(changes) == 0 {
+		return // formatting only, e.g. the order of the files
+	}
+	t.Errorf("%s doesn't match %s, run with -update to accept the changes:\n%s",
+		root, golden, fs.FormatDiff(a, b, "/", "/", changes))
+}
@@ -0,0 +1,117 @@
+package fstest
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ttacon/fs"
+)
+
+// generate is the code under test, writing a small Go program.
+func generate(o fs.OperatingSystem, dir string) {
+	o.MkdirAll(dir+"/pkg", 0755)
+	fs.WriteFile(o, dir+"/main.go", []byte("package main\n\nimport \"gen/pkg\"\n\nfunc main() {\n\tpkg.Run()\n}\n"), 0644)
+	fs.WriteFile(o, dir+"/pkg/pkg.go", []byte("package pkg\n\nfunc Run() {}\n"), 0644)
+}
+
+// recorder is a testing.TB keeping the failures it's told about.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// assertTree runs AssertTree against r, which may stop it as Fatalf does.
+func (r *recorder) assertTree(o fs.OperatingSystem, root, golden string) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AssertTree(r, o, root, golden)
+	}()
+	<-done
+}
+
+func Test_AssertTree(t *testing.T) {
+	o := fs.FakeOS()
+	generate(o, "/out")
+	AssertTree(t, o, "/out", "testdata/golden/gen")
+
+	o.MkdirAll("/out/empty", 0755)
+	AssertTree(t, o, "/out", "testdata/gen.txtar")
+}
+
+func Test_AssertTree_Mismatch(t *testing.T) {
+	o := fs.FakeOS()
+	generate(o, "/out")
+	fs.WriteFile(o, "/out/main.go", []byte("package main\n\nfunc main() {\n}\n"), 0644)
+	o.Create("/out/extra.go")
+
+	for _, golden := range []string{"testdata/golden/gen", "testdata/gen.txtar"} {
+		r := &recorder{TB: t}
+		r.assertTree(o, "/out", golden)
+		if len(r.failures) != 1 {
+			t.Fatalf("%s: expected a failure, got %v", golden, r.failures)
+		}
+		for _, want := range []string{
+			"added extra.go\n",
+			"modified main.go (content)\n",
+			"-import \"gen/pkg\"\n",
+			"run with -update",
+		} {
+			if !strings.Contains(r.failures[0], want) {
+				t.Errorf("%s: expected the failure to contain %q, got:\n%s", golden, want, r.failures[0])
+			}
+		}
+	}
+
+	r := &recorder{TB: t}
+	r.assertTree(o, "/out", "testdata/missing")
+	if len(r.failures) != 1 || !strings.Contains(r.failures[0], "-update") {
+		t.Errorf("expected a missing golden tree to fail, got %v", r.failures)
+	}
+}
+
+func Test_AssertTree_Update(t *testing.T) {
+	o := fs.FakeOS()
+	generate(o, "/out")
+	dir := t.TempDir()
+
+	Update = true
+	AssertTree(t, o, "/out", filepath.Join(dir, "gen"))
+	AssertTree(t, o, "/out", filepath.Join(dir, "snap", "gen.txtar"))
+	Update = false
+
+	AssertTree(t, o, "/out", filepath.Join(dir, "gen"))
+	AssertTree(t, o, "/out", filepath.Join(dir, "snap", "gen.txtar"))
+	if got, _ := fs.ReadFile(fs.DefaultOS(), filepath.Join(dir, "gen", "pkg", "pkg.go")); string(got) != "package pkg\n\nfunc Run() {}\n" {
+		t.Errorf("unexpected golden file %q", got)
+	}
+}
+
+func Test_AssertTree_UpdateFlag(t *testing.T) {
+	o := fs.FakeOS()
+	generate(o, "/out")
+	golden := filepath.Join(t.TempDir(), "gen.txtar")
+
+	flag.Set("update", "true")
+	AssertTree(t, o, "/out", golden)
+	flag.Set("update", "false")
+
+	if _, err := fs.DefaultOS().Stat(golden); err != nil {
+		t.Errorf("expected -update to write the golden snapshot, err: %v", err)
+	}
+}
@@ -0,0 +1,2 @@
+// Package fstest helps testing code written against fs.OperatingSystem.
+package fstest
@@ -0,0 +1,7 @@
+package main
+
+import "gen/pkg"
+
+func main() {
+	pkg.Run()
+}
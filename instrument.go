@@ -0,0 +1,176 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Metrics receives the measurements of an instrumented OperatingSystem, it
+// must be safe for concurrent use.
+type Metrics interface {
+	// CountCall counts a call to op (e.g. "Open", "File.Read").
+	CountCall(op string)
+
+	// CountBytes counts n bytes read, or written, by a call to op.
+	CountBytes(op string, read bool, n int)
+
+	// CountError counts a call to op failing with errno, the name of the
+	// errno (e.g. "ENOENT"), or "other" for errors not carrying one.
+	CountError(op, errno string)
+
+	// ObserveLatency records how long a call to op, about a file under
+	// prefix, took. prefix is "" for calls that aren't about a file.
+	ObserveLatency(op, prefix string, d time.Duration)
+}
+
+// Tracer starts a span for every call made on an instrumented
+// OperatingSystem.
+type Tracer interface {
+	// Start starts the span of a call to op about the file at path, path
+	// is "" for calls that aren't about a file.
+	Start(op, path string) Span
+}
+
+// Span is the span of a single call.
+type Span interface {
+	// End ends the span, err is what the call returned.
+	End(err error)
+}
+
+// InstrumentOption configures Instrument.
+type InstrumentOption func(*instrument)
+
+// WithTracer makes the instrumented OperatingSystem start a span of t for
+// every call.
+func WithTracer(t Tracer) InstrumentOption {
+	return func(in *instrument) {
+		in.tracer = t
+	}
+}
+
+// WithPrefixDepth sets how many leading elements of the directory of a
+// file make up the prefix latencies are observed under, 2 by default: a
+// call about "/var/lib/app/db" is observed under "/var/lib".
+func WithPrefixDepth(n int) InstrumentOption {
+	return func(in *instrument) {
+		in.depth = n
+	}
+}
+
+type instrument struct {
+	metrics Metrics
+	tracer  Tracer
+	depth   int
+	spans   sync.Map
+}
+
+// Instrument wraps o so that every call made on it, or on any File it
+// returns, is measured by m: calls, bytes read and written, errors by errno
+// and latency, by operation and path prefix. m may be nil when only
+// tracing calls. The calls Record leaves out aren't measured either.
+func Instrument(o OperatingSystem, m Metrics, opts ...InstrumentOption) OperatingSystem {
+	in := &instrument{metrics: m, depth: 2}
+	for _, opt := range opts {
+		opt(in)
+	}
+	return intercept(o, in, false)
+}
+
+func (in *instrument) before(c *Call) {
+	if in.tracer != nil {
+		in.spans.Store(c, in.tracer.Start(c.Op, c.Path))
+	}
+}
+
+func (in *instrument) after(c *Call) {
+	if span, ok := in.spans.LoadAndDelete(c); ok {
+		span.(Span).End(c.Err)
+	}
+	if in.metrics == nil {
+		return
+	}
+
+	in.metrics.CountCall(c.Op)
+	in.metrics.ObserveLatency(c.Op, pathPrefix(c.Path, in.depth), c.Duration)
+	if c.Err != nil && c.Err != io.EOF {
+		in.metrics.CountError(c.Op, errnoName(c.Err))
+	}
+
+	switch c.Op {
+	case "File.Read", "File.ReadAt", "File.Write", "File.WriteAt", "File.WriteString":
+		if n, ok := c.result(0).(int); ok && n > 0 {
+			in.metrics.CountBytes(c.Op, strings.HasPrefix(c.Op, "File.Read"), n)
+		}
+	}
+}
+
+// pathPrefix returns the first depth elements of the directory of path.
+func pathPrefix(path string, depth int) string {
+	if path == "" {
+		return ""
+	}
+
+	dir := filepath.Dir(filepath.Clean(path))
+	rooted := strings.HasPrefix(dir, string(PathSeparator))
+	elems := strings.FieldsFunc(dir, func(r rune) bool {
+		return r == PathSeparator
+	})
+	if len(elems) > depth {
+		elems = elems[:depth]
+	}
+
+	prefix := strings.Join(elems, string(PathSeparator))
+	if rooted {
+		return string(PathSeparator) + prefix
+	}
+	return prefix
+}
+
+// errnoNames names the errnos reported. It's a list rather than a map as
+// some systems give two of them the same number, as AIX does EEXIST and
+// ENOTEMPTY, the first one listed wins.
+var errnoNames = []struct {
+	errno syscall.Errno
+	name  string
+}{
+	{syscall.EACCES, "EACCES"},
+	{syscall.EAGAIN, "EAGAIN"},
+	{syscall.EBADF, "EBADF"},
+	{syscall.EBUSY, "EBUSY"},
+	{syscall.EEXIST, "EEXIST"},
+	{syscall.EINTR, "EINTR"},
+	{syscall.EINVAL, "EINVAL"},
+	{syscall.EIO, "EIO"},
+	{syscall.EISDIR, "EISDIR"},
+	{syscall.ELOOP, "ELOOP"},
+	{syscall.EMFILE, "EMFILE"},
+	{syscall.ENAMETOOLONG, "ENAMETOOLONG"},
+	{syscall.ENOENT, "ENOENT"},
+	{syscall.ENOSPC, "ENOSPC"},
+	{syscall.ENOTDIR, "ENOTDIR"},
+	{syscall.ENOTEMPTY, "ENOTEMPTY"},
+	{syscall.EPERM, "EPERM"},
+	{syscall.EROFS, "EROFS"},
+	{syscall.EXDEV, "EXDEV"},
+}
+
+// errnoName returns the name of the errno err carries, "other" if it
+// doesn't carry one.
+func errnoName(err error) string {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return "other"
+	}
+	for _, e := range errnoNames {
+		if e.errno == errno {
+			return e.name
+		}
+	}
+	return fmt.Sprintf("errno %d", uintptr(errno))
+}
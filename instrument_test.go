@@ -0,0 +1,93 @@
+package fs
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+type fakeSpan struct {
+	tracer *fakeTracer
+	name   string
+}
+
+func (s *fakeSpan) End(err error) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s.name)
+}
+
+type fakeTracer struct {
+	lock  sync.Mutex
+	ended []string
+}
+
+func (t *fakeTracer) Start(op, path string) Span {
+	return &fakeSpan{tracer: t, name: op + " " + path}
+}
+
+func Test_Instrument(t *testing.T) {
+	m := NewExpvarMetrics("fs_test_instrument")
+	tracer := &fakeTracer{}
+	o := Instrument(FakeOS(), m, WithTracer(tracer))
+
+	o.MkdirAll("/var/lib/app", 0755)
+	f, err := o.Create("/var/lib/app/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	o.Open("/var/lib/app/missing")
+	o.Open("/var/lib/app/missing")
+
+	var got struct {
+		Calls         map[string]int64
+		BytesWritten  map[string]int64 `json:"bytes_written"`
+		Errors        map[string]int64
+		Latency       map[string]struct{ Count int64 }
+		PrefixLatency map[string]struct{ Count int64 } `json:"prefix_latency"`
+	}
+	if err := json.Unmarshal([]byte(m.Map().String()), &got); err != nil {
+		t.Fatalf("failed to parse metrics %s, err: %v", m.Map(), err)
+	}
+
+	if got.Calls["Open"] != 2 || got.Calls["File.Write"] != 1 {
+		t.Errorf("expected calls to be counted, got %v", got.Calls)
+	}
+	if got.BytesWritten["File.Write"] != 5 {
+		t.Errorf("expected 5 bytes written, got %v", got.BytesWritten)
+	}
+	if got.Errors["ENOENT"] != 2 {
+		t.Errorf("expected 2 ENOENT errors, got %v", got.Errors)
+	}
+	if got.Latency["Open"].Count != 2 {
+		t.Errorf("expected 2 Open latencies, got %v", got.Latency)
+	}
+	if got.PrefixLatency["/var/lib"].Count != 6 {
+		t.Errorf("expected 6 latencies under /var/lib, got %v", got.PrefixLatency)
+	}
+
+	if len(tracer.ended) != 6 || tracer.ended[1] != "Create /var/lib/app/db" {
+		t.Errorf("expected a span per call, got %q", tracer.ended)
+	}
+}
+
+func Test_PathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		depth  int
+		prefix string
+	}{
+		{"/var/lib/app/db", 2, "/var/lib"},
+		{"/etc/hosts", 2, "/etc"},
+		{"/hosts", 2, "/"},
+		{"data/app/db", 1, "data"},
+		{"", 2, ""},
+	}
+	for _, test := range tests {
+		if prefix := pathPrefix(test.path, test.depth); prefix != test.prefix {
+			t.Errorf("expected prefix of %q to be %q, was %q", test.path, test.prefix, prefix)
+		}
+	}
+}
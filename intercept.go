@@ -0,0 +1,579 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// interceptor is told about every call going through an interceptedOS (or
+// one of the files it handed out), before and after it's made.
+type interceptor interface {
+	before(c *Call)
+	after(c *Call)
+}
+
+// interceptedOS wraps an OperatingSystem, and every File it returns, so
+// that an interceptor sees every call made on them.
+type interceptedOS struct {
+	o       OperatingSystem
+	hook    interceptor
+	seq     int64
+	handles int64
+
+	// detailed says whether calls should carry the bytes read and
+	// written, and the goroutine they were made from, which don't come
+	// cheap.
+	detailed bool
+}
+
+func intercept(o OperatingSystem, hook interceptor, detailed bool) *interceptedOS {
+	return &interceptedOS{o: o, hook: hook, detailed: detailed}
+}
+
+// goroutineID returns the id of the calling goroutine, as it appears in
+// stack traces.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+func (i *interceptedOS) start(handle int64, op, path string, args ...interface{}) *Call {
+	c := &Call{
+		Seq:    atomic.AddInt64(&i.seq, 1),
+		Op:     op,
+		Handle: handle,
+		Path:   path,
+		Args:   args,
+	}
+	if i.detailed {
+		c.Goroutine = goroutineID()
+	}
+	i.hook.before(c)
+	c.Start = time.Now()
+	return c
+}
+
+func (i *interceptedOS) finish(c *Call, err error, results ...interface{}) {
+	c.Duration = time.Since(c.Start)
+	c.Err = err
+	c.Results = results
+	i.hook.after(c)
+}
+
+// wrap returns f as seen through the interceptor.
+func (i *interceptedOS) wrap(f File) File {
+	if f == nil {
+		return nil
+	}
+	return &interceptedFile{
+		f:  f,
+		id: atomic.AddInt64(&i.handles, 1),
+		os: i,
+	}
+}
+
+// handleRef returns the reference to f recorded in calls.
+func handleRef(f File) interface{} {
+	if f, ok := f.(*interceptedFile); ok {
+		return HandleRef{ID: f.id, Name: f.f.Name()}
+	}
+	return nil
+}
+
+func (i *interceptedOS) Chdir(dir string) error {
+	c := i.start(0, "Chdir", dir, dir)
+	err := i.o.Chdir(dir)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Chmod(name string, mode os.FileMode) error {
+	c := i.start(0, "Chmod", name, name, mode)
+	err := i.o.Chmod(name, mode)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Chown(name string, uid, gid int) error {
+	c := i.start(0, "Chown", name, name, uid, gid)
+	err := i.o.Chown(name, uid, gid)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	c := i.start(0, "Chtimes", name, name, atime, mtime)
+	err := i.o.Chtimes(name, atime, mtime)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Clearenv() {
+	c := i.start(0, "Clearenv", "")
+	i.o.Clearenv()
+	i.finish(c, nil)
+}
+
+func (i *interceptedOS) Environ() []string {
+	c := i.start(0, "Environ", "")
+	env := i.o.Environ()
+	i.finish(c, nil, env)
+	return env
+}
+
+func (i *interceptedOS) Exit(code int) {
+	// there is no after for this one
+	c := i.start(0, "Exit", "", code)
+	i.finish(c, nil)
+	i.o.Exit(code)
+}
+
+func (i *interceptedOS) Expand(s string, mapping func(string) string) string {
+	c := i.start(0, "Expand", "", s)
+	v := i.o.Expand(s, mapping)
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) ExpandEnv(s string) string {
+	c := i.start(0, "ExpandEnv", "", s)
+	v := i.o.ExpandEnv(s)
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getegid() int {
+	c := i.start(0, "Getegid", "")
+	v := i.o.Getegid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getenv(key string) string {
+	c := i.start(0, "Getenv", "", key)
+	v := i.o.Getenv(key)
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Geteuid() int {
+	c := i.start(0, "Geteuid", "")
+	v := i.o.Geteuid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getgid() int {
+	c := i.start(0, "Getgid", "")
+	v := i.o.Getgid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getgroups() ([]int, error) {
+	c := i.start(0, "Getgroups", "")
+	v, err := i.o.Getgroups()
+	i.finish(c, err, v)
+	return v, err
+}
+
+func (i *interceptedOS) Getpagesize() int {
+	c := i.start(0, "Getpagesize", "")
+	v := i.o.Getpagesize()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getpid() int {
+	c := i.start(0, "Getpid", "")
+	v := i.o.Getpid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getppid() int {
+	c := i.start(0, "Getppid", "")
+	v := i.o.Getppid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getuid() int {
+	c := i.start(0, "Getuid", "")
+	v := i.o.Getuid()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Getwd() (dir string, err error) {
+	c := i.start(0, "Getwd", "")
+	dir, err = i.o.Getwd()
+	i.finish(c, err, dir)
+	return dir, err
+}
+
+func (i *interceptedOS) Hostname() (name string, err error) {
+	c := i.start(0, "Hostname", "")
+	name, err = i.o.Hostname()
+	i.finish(c, err, name)
+	return name, err
+}
+
+// IsExist, IsNotExist, IsPathSeparator, IsPermission, SameFile and
+// File.Name aren't intercepted, see Record.
+
+func (i *interceptedOS) IsExist(err error) bool {
+	return i.o.IsExist(err)
+}
+
+func (i *interceptedOS) IsNotExist(err error) bool {
+	return i.o.IsNotExist(err)
+}
+
+func (i *interceptedOS) IsPathSeparator(c uint8) bool {
+	return i.o.IsPathSeparator(c)
+}
+
+func (i *interceptedOS) IsPermission(err error) bool {
+	return i.o.IsPermission(err)
+}
+
+func (i *interceptedOS) Lchown(name string, uid, gid int) error {
+	c := i.start(0, "Lchown", name, name, uid, gid)
+	err := i.o.Lchown(name, uid, gid)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Link(oldname, newname string) error {
+	c := i.start(0, "Link", newname, oldname, newname)
+	err := i.o.Link(oldname, newname)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Mkdir(name string, perm os.FileMode) error {
+	c := i.start(0, "Mkdir", name, name, perm)
+	err := i.o.Mkdir(name, perm)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) MkdirAll(path string, perm os.FileMode) error {
+	c := i.start(0, "MkdirAll", path, path, perm)
+	err := i.o.MkdirAll(path, perm)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Readlink(name string) (string, error) {
+	c := i.start(0, "Readlink", name, name)
+	target, err := i.o.Readlink(name)
+	i.finish(c, err, target)
+	return target, err
+}
+
+func (i *interceptedOS) Remove(name string) error {
+	c := i.start(0, "Remove", name, name)
+	err := i.o.Remove(name)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) RemoveAll(path string) error {
+	c := i.start(0, "RemoveAll", path, path)
+	err := i.o.RemoveAll(path)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Rename(oldname, newname string) error {
+	c := i.start(0, "Rename", oldname, oldname, newname)
+	err := i.o.Rename(oldname, newname)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) SameFile(fi1, fi2 os.FileInfo) bool {
+	return i.o.SameFile(fi1, fi2)
+}
+
+func (i *interceptedOS) Setenv(key, value string) error {
+	c := i.start(0, "Setenv", "", key, value)
+	err := i.o.Setenv(key, value)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Symlink(oldname, newname string) error {
+	c := i.start(0, "Symlink", newname, oldname, newname)
+	err := i.o.Symlink(oldname, newname)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) TempDir() string {
+	c := i.start(0, "TempDir", "")
+	v := i.o.TempDir()
+	i.finish(c, nil, v)
+	return v
+}
+
+func (i *interceptedOS) Truncate(name string, size int64) error {
+	c := i.start(0, "Truncate", name, name, size)
+	err := i.o.Truncate(name, size)
+	i.finish(c, err)
+	return err
+}
+
+func (i *interceptedOS) Create(name string) (file File, err error) {
+	c := i.start(0, "Create", name, name)
+	file, err = i.o.Create(name)
+	file = i.wrap(file)
+	i.finish(c, err, handleRef(file))
+	return file, err
+}
+
+func (i *interceptedOS) NewFile(fd uintptr, name string) File {
+	c := i.start(0, "NewFile", name, fd, name)
+	file := i.wrap(i.o.NewFile(fd, name))
+	i.finish(c, nil, handleRef(file))
+	return file
+}
+
+func (i *interceptedOS) Open(name string) (file File, err error) {
+	c := i.start(0, "Open", name, name)
+	file, err = i.o.Open(name)
+	file = i.wrap(file)
+	i.finish(c, err, handleRef(file))
+	return file, err
+}
+
+func (i *interceptedOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	c := i.start(0, "OpenFile", name, name, flag, perm)
+	file, err = i.o.OpenFile(name, flag, perm)
+	file = i.wrap(file)
+	i.finish(c, err, handleRef(file))
+	return file, err
+}
+
+func (i *interceptedOS) Pipe() (r File, w File, err error) {
+	c := i.start(0, "Pipe", "")
+	r, w, err = i.o.Pipe()
+	r, w = i.wrap(r), i.wrap(w)
+	i.finish(c, err, handleRef(r), handleRef(w))
+	return r, w, err
+}
+
+func (i *interceptedOS) Lstat(name string) (fi os.FileInfo, err error) {
+	c := i.start(0, "Lstat", name, name)
+	fi, err = i.o.Lstat(name)
+	i.finish(c, err, fi)
+	return fi, err
+}
+
+func (i *interceptedOS) Stat(name string) (fi os.FileInfo, err error) {
+	c := i.start(0, "Stat", name, name)
+	fi, err = i.o.Stat(name)
+	i.finish(c, err, fi)
+	return fi, err
+}
+
+// Watch watches path on the wrapped OperatingSystem, see Watchable. Watch
+// isn't an OperatingSystem call, so it isn't intercepted.
+func (i *interceptedOS) Watch(path string, recursive bool) (Watcher, error) {
+	return Watch(i.o, path, recursive)
+}
+
+// random draws from the wrapped OperatingSystem, see randomSource. It isn't
+// an OperatingSystem call either.
+func (i *interceptedOS) random() uint32 {
+	return randomOf(i.o)
+}
+
+// interceptedFile is a File handed out by an interceptedOS.
+type interceptedFile struct {
+	f  File
+	id int64
+	os *interceptedOS
+}
+
+func (f *interceptedFile) start(op string, args ...interface{}) *Call {
+	return f.os.start(f.id, "File."+op, f.f.Name(), args...)
+}
+
+func (f *interceptedFile) Chdir() error {
+	c := f.start("Chdir")
+	err := f.f.Chdir()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Chmod(mode os.FileMode) error {
+	c := f.start("Chmod", mode)
+	err := f.f.Chmod(mode)
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Chown(uid, gid int) error {
+	c := f.start("Chown", uid, gid)
+	err := f.f.Chown(uid, gid)
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Close() error {
+	c := f.start("Close")
+	err := f.f.Close()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Fd() uintptr {
+	c := f.start("Fd")
+	fd := f.f.Fd()
+	f.os.finish(c, nil, fd)
+	return fd
+}
+
+func (f *interceptedFile) Lock() error {
+	c := f.start("Lock")
+	err := f.f.Lock()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) LockRange(off, n int64, how int) error {
+	c := f.start("LockRange", off, n, how)
+	err := f.f.LockRange(off, n, how)
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Name() string {
+	return f.f.Name()
+}
+
+func (f *interceptedFile) RLock() error {
+	c := f.start("RLock")
+	err := f.f.RLock()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Read(b []byte) (n int, err error) {
+	c := f.start("Read", len(b))
+	n, err = f.f.Read(b)
+	if f.os.detailed {
+		c.Data = append([]byte(nil), b[:n]...)
+	}
+	f.os.finish(c, err, n)
+	return n, err
+}
+
+func (f *interceptedFile) ReadAt(b []byte, off int64) (n int, err error) {
+	c := f.start("ReadAt", len(b), off)
+	n, err = f.f.ReadAt(b, off)
+	if f.os.detailed {
+		c.Data = append([]byte(nil), b[:n]...)
+	}
+	f.os.finish(c, err, n)
+	return n, err
+}
+
+func (f *interceptedFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	c := f.start("Readdir", n)
+	fi, err = f.f.Readdir(n)
+	f.os.finish(c, err, fi)
+	return fi, err
+}
+
+func (f *interceptedFile) Readdirnames(n int) (names []string, err error) {
+	c := f.start("Readdirnames", n)
+	names, err = f.f.Readdirnames(n)
+	f.os.finish(c, err, names)
+	return names, err
+}
+
+func (f *interceptedFile) Seek(offset int64, whence int) (ret int64, err error) {
+	c := f.start("Seek", offset, whence)
+	ret, err = f.f.Seek(offset, whence)
+	f.os.finish(c, err, ret)
+	return ret, err
+}
+
+func (f *interceptedFile) Stat() (fi os.FileInfo, err error) {
+	c := f.start("Stat")
+	fi, err = f.f.Stat()
+	f.os.finish(c, err, fi)
+	return fi, err
+}
+
+func (f *interceptedFile) Sync() (err error) {
+	c := f.start("Sync")
+	err = f.f.Sync()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Truncate(size int64) error {
+	c := f.start("Truncate", size)
+	err := f.f.Truncate(size)
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) TryLock() error {
+	c := f.start("TryLock")
+	err := f.f.TryLock()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Unlock() error {
+	c := f.start("Unlock")
+	err := f.f.Unlock()
+	f.os.finish(c, err)
+	return err
+}
+
+func (f *interceptedFile) Write(b []byte) (n int, err error) {
+	c := f.start("Write", len(b))
+	if f.os.detailed {
+		c.Data = append([]byte(nil), b...)
+	}
+	n, err = f.f.Write(b)
+	f.os.finish(c, err, n)
+	return n, err
+}
+
+func (f *interceptedFile) WriteAt(b []byte, off int64) (n int, err error) {
+	c := f.start("WriteAt", len(b), off)
+	if f.os.detailed {
+		c.Data = append([]byte(nil), b...)
+	}
+	n, err = f.f.WriteAt(b, off)
+	f.os.finish(c, err, n)
+	return n, err
+}
+
+func (f *interceptedFile) WriteString(s string) (ret int, err error) {
+	c := f.start("WriteString", len(s))
+	if f.os.detailed {
+		c.Data = []byte(s)
+	}
+	ret, err = f.f.WriteString(s)
+	f.os.finish(c, err, ret)
+	return ret, err
+}
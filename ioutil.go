@@ -0,0 +1,67 @@
+package fs
+
+import (
+	"io"
+	iofs "io/fs"
+	"os"
+)
+
+// ReadFile reads the file named name on o and returns its contents, as
+// os.ReadFile does. A successful call returns a nil err, not io.EOF.
+func ReadFile(o OperatingSystem, name string) ([]byte, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := 512
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 && int64(int(fi.Size())) == fi.Size() {
+		size = int(fi.Size()) + 1 // one more byte to see EOF
+	}
+
+	data := make([]byte, 0, size)
+	for {
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		n, err := f.Read(data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return data, err
+		}
+	}
+}
+
+// WriteFile writes data to the file named name on o, creating it with
+// perm (before umask) if necessary, or truncating it, as os.WriteFile
+// does.
+func WriteFile(o OperatingSystem, name string, data []byte, perm os.FileMode) error {
+	f, err := o.OpenFile(name, O_WRONLY|O_CREATE|O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ReadDir reads the directory named name on o and returns its entries
+// sorted by name, as os.ReadDir does.
+func ReadDir(o OperatingSystem, name string) ([]iofs.DirEntry, error) {
+	infos, err := readDir(o, name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]iofs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = iofs.FileInfoToDirEntry(fi)
+	}
+	return entries, nil
+}
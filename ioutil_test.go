@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func Test_ReadWriteFile(t *testing.T) {
+	for name, o := range map[string]OperatingSystem{
+		"fake":    FakeOS(),
+		"default": DefaultOS(),
+	} {
+		dir := "/tmp"
+		if name == "default" {
+			dir = t.TempDir()
+		}
+		file := dir + "/data.bin"
+
+		data := bytes.Repeat([]byte("0123456789"), 1000)
+		if err := WriteFile(o, file, data, 0644); err != nil {
+			t.Fatalf("%s: failed to write, err: %v", name, err)
+		}
+		got, err := ReadFile(o, file)
+		if err != nil {
+			t.Fatalf("%s: failed to read, err: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: expected %d bytes back, got %d", name, len(data), len(got))
+		}
+
+		// truncated when written again
+		WriteFile(o, file, []byte("short"), 0644)
+		if got, _ := ReadFile(o, file); string(got) != "short" {
+			t.Errorf("%s: expected the file to be truncated, got %q", name, got)
+		}
+
+		if _, err := ReadFile(o, dir+"/missing"); !errors.Is(err, syscall.ENOENT) {
+			t.Errorf("%s: expected ENOENT, err: %v", name, err)
+		}
+	}
+}
+
+func Test_ReadDir(t *testing.T) {
+	o := FakeOS()
+	o.MkdirAll("/etc/app/conf.d", 0755)
+	WriteFile(o, "/etc/app/b.conf", nil, 0644)
+	WriteFile(o, "/etc/app/a.conf", nil, 0644)
+
+	entries, err := ReadDir(o, "/etc/app")
+	if err != nil {
+		t.Fatalf("failed to read dir, err: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"a.conf", "b.conf", "conf.d"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+	if !entries[2].IsDir() || entries[0].IsDir() {
+		t.Errorf("expected only conf.d to be a directory")
+	}
+
+	if _, err := ReadDir(o, "/etc/app/a.conf"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, err: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package fs
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Latency is a distribution of how long operations take.
+type Latency interface {
+	// Sample returns the duration of one operation, drawing any randomness
+	// it needs from r.
+	Sample(r *rand.Rand) time.Duration
+}
+
+type fixedLatency time.Duration
+
+// FixedLatency returns a Latency of d, every time.
+func FixedLatency(d time.Duration) Latency {
+	return fixedLatency(d)
+}
+
+func (l fixedLatency) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(l)
+}
+
+type uniformLatency struct {
+	min, max time.Duration
+}
+
+// UniformLatency returns a Latency uniformly distributed in [min, max).
+func UniformLatency(min, max time.Duration) Latency {
+	return uniformLatency{min: min, max: max}
+}
+
+func (l uniformLatency) Sample(r *rand.Rand) time.Duration {
+	if l.max <= l.min {
+		return l.min
+	}
+	return l.min + time.Duration(r.Int63n(int64(l.max-l.min)))
+}
+
+type normalLatency struct {
+	mean, stddev time.Duration
+}
+
+// NormalLatency returns a normally distributed Latency, samples below zero
+// are taken as zero.
+func NormalLatency(mean, stddev time.Duration) Latency {
+	return normalLatency{mean: mean, stddev: stddev}
+}
+
+func (l normalLatency) Sample(r *rand.Rand) time.Duration {
+	d := l.mean + time.Duration(r.NormFloat64()*float64(l.stddev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// LatencyBucket is a bucket of a histogram: Weight is how often operations
+// take up to UpTo (and more than the UpTo of the previous bucket).
+type LatencyBucket struct {
+	UpTo   time.Duration
+	Weight float64
+}
+
+type histogramLatency struct {
+	buckets []LatencyBucket
+	total   float64
+}
+
+// HistogramLatency returns a Latency following a histogram, e.g. one
+// measured on a real disk. Samples are uniformly distributed within their
+// bucket.
+func HistogramLatency(buckets ...LatencyBucket) Latency {
+	l := histogramLatency{buckets: append([]LatencyBucket(nil), buckets...)}
+	sort.Slice(l.buckets, func(i, j int) bool {
+		return l.buckets[i].UpTo < l.buckets[j].UpTo
+	})
+	for _, b := range l.buckets {
+		l.total += b.Weight
+	}
+	return l
+}
+
+func (l histogramLatency) Sample(r *rand.Rand) time.Duration {
+	if l.total <= 0 {
+		return 0
+	}
+
+	x := r.Float64() * l.total
+	var from time.Duration
+	for _, b := range l.buckets {
+		if x < b.Weight {
+			return uniformLatency{min: from, max: b.UpTo}.Sample(r)
+		}
+		x -= b.Weight
+		from = b.UpTo
+	}
+	return from
+}
+
+// WithLatency makes the given operations of a FakeOS take l, every file
+// system operation when none are given. Operations are named as in Call
+// (e.g. "Open", "File.Read"), environment and process calls never take
+// any time.
+func WithLatency(l Latency, ops ...string) FakeOption {
+	return func(d *fakeOS) {
+		if len(ops) == 0 {
+			ops = []string{""}
+		}
+		for _, op := range ops {
+			d.latencies[op] = l
+		}
+	}
+}
+
+// WithThroughput caps how many bytes per second files of a FakeOS can be
+// read from and written to, 0 meaning no limit.
+func WithThroughput(read, write int64) FakeOption {
+	return func(d *fakeOS) {
+		d.readRate, d.writeRate = read, write
+	}
+}
+
+// delay lets the latency of op pass, once the scheduler, if any, switched
+// back to the calling goroutine.
+func (d *fakeOS) delay(op string) {
+	if d.sched != nil {
+		d.sched.yield(op, false)
+	}
+
+	l, ok := d.latencies[op]
+	if !ok {
+		l = d.latencies[""]
+	}
+	if l == nil {
+		return
+	}
+
+	d.randLock.Lock()
+	latency := l.Sample(d.rand)
+	d.randLock.Unlock()
+	d.clock.Sleep(latency)
+}
+
+// transfer lets the time it takes to move n bytes at rate pass.
+func (d *fakeOS) transfer(n int, rate int64) {
+	if rate > 0 && n > 0 {
+		d.clock.Sleep(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	}
+}
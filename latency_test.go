@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_Latency_Distributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	hist := HistogramLatency(
+		LatencyBucket{UpTo: time.Millisecond, Weight: 9},
+		LatencyBucket{UpTo: time.Second, Weight: 1},
+	)
+
+	var slow int
+	for i := 0; i < 1000; i++ {
+		d := UniformLatency(time.Millisecond, 2*time.Millisecond).Sample(r)
+		if d < time.Millisecond || d >= 2*time.Millisecond {
+			t.Fatalf("uniform sample out of range: %v", d)
+		}
+		if d := NormalLatency(time.Millisecond, 10*time.Millisecond).Sample(r); d < 0 {
+			t.Fatalf("normal sample below zero: %v", d)
+		}
+		d = hist.Sample(r)
+		if d < 0 || d >= time.Second {
+			t.Fatalf("histogram sample out of range: %v", d)
+		}
+		if d >= time.Millisecond {
+			slow++
+		}
+	}
+	if slow < 50 || slow > 150 {
+		t.Errorf("expected about 100 slow histogram samples, got %d", slow)
+	}
+	if d := FixedLatency(time.Second).Sample(r); d != time.Second {
+		t.Errorf("expected fixed latency of 1s, got %v", d)
+	}
+}
+
+func Test_FakeOS_Throughput(t *testing.T) {
+	c := NewFakeClock(epoch)
+	c.SetAutoAdvance(true)
+	o := FakeOS(
+		WithClock(c),
+		WithLatency(FixedLatency(10*time.Millisecond), "Create"),
+		WithThroughput(0, 10<<20),
+	)
+
+	f, err := o.Create("/tmp/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Now().Sub(epoch); got != 10*time.Millisecond {
+		t.Errorf("expected Create to take 10ms, took %v", got)
+	}
+
+	start := c.Now()
+	f.Write(make([]byte, 20<<20))
+	if got := c.Now().Sub(start); got != 2*time.Second {
+		t.Errorf("expected writing 20MB at 10MB/s to take 2s, took %v", got)
+	}
+
+	fi, _ := o.Stat("/tmp/big")
+	if !fi.ModTime().Equal(start) {
+		t.Errorf("expected modification time to come from the clock, was %v", fi.ModTime())
+	}
+}
+
+func Test_FakeOS_HungCall(t *testing.T) {
+	c := NewFakeClock(epoch)
+	o := FakeOS(WithClock(c), WithLatency(FixedLatency(time.Hour), "Stat"))
+
+	done := make(chan error)
+	go func() {
+		_, err := o.Stat("/tmp")
+		done <- err
+	}()
+
+	c.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("expected Stat to hang until the clock is advanced")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("expected Stat to succeed, err: %v", err)
+	}
+}
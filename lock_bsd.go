@@ -0,0 +1,16 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package fs
+
+import "syscall"
+
+// lockRange applies lk to fd as a POSIX record lock. Unlike on Linux the
+// lock is held by the process: locks taken through other open files of
+// the process don't conflict with it, and closing any of them releases it.
+func lockRange(fd uintptr, lk *syscall.Flock_t, wait bool) error {
+	cmd := syscall.F_SETLK
+	if wait {
+		cmd = syscall.F_SETLKW
+	}
+	return syscall.FcntlFlock(fd, cmd, lk)
+}
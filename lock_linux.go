@@ -0,0 +1,19 @@
+package fs
+
+import "syscall"
+
+// open file description locks, missing from syscall.
+const (
+	fOFDSetlk  = 37
+	fOFDSetlkw = 38
+)
+
+// lockRange applies lk to fd as an open file description lock, so that, as
+// with FakeOS, it's held by the open file rather than the process.
+func lockRange(fd uintptr, lk *syscall.Flock_t, wait bool) error {
+	cmd := fOFDSetlk
+	if wait {
+		cmd = fOFDSetlkw
+	}
+	return syscall.FcntlFlock(fd, cmd, lk)
+}
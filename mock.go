@@ -0,0 +1,881 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Any matches any argument of an expected call.
+var Any interface{} = anyArg{}
+
+type anyArg struct{}
+
+func (anyArg) GoString() string {
+	return "Any"
+}
+
+// Expectation is a call a MockOS or MockFile expects to be made.
+type Expectation struct {
+	c     *mockController
+	owner interface{}
+	op    string
+	args  []interface{}
+
+	results  []interface{}
+	returned bool
+	min, max int
+	calls    int
+}
+
+// Return sets what the expected call returns, in the same order as the
+// method does; results left out are zero values. Without Return, Write,
+// WriteAt and WriteString report everything as written.
+//
+// Read and ReadAt may be given the []byte (or string) read in place of the
+// count of bytes read.
+func (e *Expectation) Return(results ...interface{}) *Expectation {
+	e.c.lock.Lock()
+	defer e.c.lock.Unlock()
+
+	e.results = results
+	e.returned = true
+	return e
+}
+
+// Times sets how many times the call is expected, once by default.
+func (e *Expectation) Times(n int) *Expectation {
+	e.c.lock.Lock()
+	defer e.c.lock.Unlock()
+
+	e.min, e.max = n, n
+	return e
+}
+
+// AnyTimes allows the call to be made any number of times, including none.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.c.lock.Lock()
+	defer e.c.lock.Unlock()
+
+	e.min, e.max = 0, -1
+	return e
+}
+
+func (e *Expectation) String() string {
+	return formatCall(e.op, e.args)
+}
+
+func (e *Expectation) matches(owner interface{}, op string, args []interface{}) bool {
+	if e.owner != owner || e.op != op || len(e.args) != len(args) {
+		return false
+	}
+	for i, want := range e.args {
+		if !matchArg(want, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchArg(want, got interface{}) bool {
+	if want == Any {
+		return true
+	}
+	// written data may be expected as a string
+	if s, ok := want.(string); ok {
+		if b, ok := got.([]byte); ok {
+			return s == string(b)
+		}
+	}
+	// untyped constants end up as ints, whatever the parameter's type
+	if w, ok := want.(int); ok {
+		switch got.(type) {
+		case int64, os.FileMode, uintptr:
+			return reflect.ValueOf(got).Convert(reflect.TypeOf(0)).Int() == int64(w)
+		}
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+// mockController holds the expectations of a MockOS and of its MockFiles.
+type mockController struct {
+	t TestingT
+
+	lock     sync.Mutex
+	expected []*Expectation
+	ordered  bool
+	next     int
+}
+
+func newMockController(t TestingT) *mockController {
+	c := &mockController{t: t}
+	t.Cleanup(c.done)
+	return c
+}
+
+func (c *mockController) expect(owner interface{}, op string, args ...interface{}) *Expectation {
+	e := &Expectation{c: c, owner: owner, op: op, args: args, min: 1, max: 1}
+
+	c.lock.Lock()
+	c.expected = append(c.expected, e)
+	c.lock.Unlock()
+	return e
+}
+
+// call finds the expectation matching a call and returns its results.
+func (c *mockController) call(owner interface{}, op string, args ...interface{}) mockResults {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i := c.next; i < len(c.expected); i++ {
+		e := c.expected[i]
+		if !e.matches(owner, op, args) || (e.max >= 0 && e.calls >= e.max) {
+			continue
+		}
+
+		if c.ordered {
+			for _, skipped := range c.expected[c.next:i] {
+				if skipped.calls < skipped.min {
+					return c.unexpected(op, args, fmt.Sprintf(", expected %s first", skipped))
+				}
+			}
+			c.next = i
+		}
+		e.calls++
+		return mockResults{c: c, op: op, values: e.results, returned: e.returned}
+	}
+	return c.unexpected(op, args, "")
+}
+
+func (c *mockController) unexpected(op string, args []interface{}, why string) mockResults {
+	call := formatCall(op, args)
+	c.t.Errorf("fs: mock: unexpected call %s%s", call, why)
+	return mockResults{c: c, op: op, failure: fmt.Errorf("%w: %s", ErrUnexpectedCall, call)}
+}
+
+func (c *mockController) done() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, e := range c.expected {
+		if e.calls < e.min {
+			c.t.Errorf("fs: mock: missing call %s, expected %d calls, got %d", e, e.min, e.calls)
+		}
+	}
+}
+
+// mockResults are the results of a call to a mock.
+type mockResults struct {
+	c        *mockController
+	op       string
+	values   []interface{}
+	returned bool
+
+	// failure is set for unexpected calls, it's returned in place of any
+	// error.
+	failure error
+}
+
+func (r mockResults) value(i int) interface{} {
+	if i < len(r.values) {
+		return r.values[i]
+	}
+	return nil
+}
+
+func (r mockResults) wrongType(i int, v interface{}, want string) {
+	r.c.t.Errorf("fs: mock: %s returns a %s as result %d, not a %T", r.op, want, i, v)
+}
+
+func (r mockResults) err(i int) error {
+	if r.failure != nil {
+		return r.failure
+	}
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	err, ok := v.(error)
+	if !ok {
+		r.wrongType(i, v, "error")
+	}
+	return err
+}
+
+func (r mockResults) integer(i int) int64 {
+	v := r.value(i)
+	if v == nil {
+		return 0
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint())
+	}
+	r.wrongType(i, v, "number")
+	return 0
+}
+
+func (r mockResults) string(i int) string {
+	v := r.value(i)
+	if v == nil {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		r.wrongType(i, v, "string")
+	}
+	return s
+}
+
+func (r mockResults) strings(i int) []string {
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	s, ok := v.([]string)
+	if !ok {
+		r.wrongType(i, v, "[]string")
+	}
+	return s
+}
+
+func (r mockResults) ints(i int) []int {
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	s, ok := v.([]int)
+	if !ok {
+		r.wrongType(i, v, "[]int")
+	}
+	return s
+}
+
+func (r mockResults) file(i int) File {
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	f, ok := v.(File)
+	if !ok {
+		r.wrongType(i, v, "File")
+	}
+	return f
+}
+
+func (r mockResults) fileInfo(i int) os.FileInfo {
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	fi, ok := v.(os.FileInfo)
+	if !ok {
+		r.wrongType(i, v, "os.FileInfo")
+	}
+	return fi
+}
+
+func (r mockResults) fileInfos(i int) []os.FileInfo {
+	v := r.value(i)
+	if v == nil {
+		return nil
+	}
+	fis, ok := v.([]os.FileInfo)
+	if !ok {
+		r.wrongType(i, v, "[]os.FileInfo")
+	}
+	return fis
+}
+
+// read copies what the expected call reads into b.
+func (r mockResults) read(b []byte) (int, error) {
+	switch v := r.value(0).(type) {
+	case []byte:
+		return copy(b, v), r.err(1)
+	case string:
+		return copy(b, v), r.err(1)
+	}
+	return int(r.integer(0)), r.err(1)
+}
+
+// written returns how much the expected call wrote out of n bytes.
+func (r mockResults) written(n int) (int, error) {
+	if !r.returned && r.failure == nil {
+		return n, nil
+	}
+	return int(r.integer(0)), r.err(1)
+}
+
+// MockOS is an OperatingSystem failing tests when calls made on it aren't
+// the ones it was told to expect (see NewMockOS).
+//
+// Expectations match arguments with reflect.DeepEqual, unless they're Any.
+// IsExist, IsNotExist, IsPathSeparator, IsPermission and SameFile aren't
+// mocked and behave as the os package's.
+type MockOS struct {
+	c *mockController
+}
+
+// NewMockOS returns a MockOS reporting unexpected calls to t, and once the
+// test is over, expected calls that weren't made.
+func NewMockOS(t TestingT) *MockOS {
+	return &MockOS{c: newMockController(t)}
+}
+
+// InOrder makes the calls expected so far, and from now on, have to be
+// made in the order they were expected, including calls on MockFiles
+// returned by File.
+func (m *MockOS) InOrder() *MockOS {
+	m.c.lock.Lock()
+	m.c.ordered = true
+	m.c.lock.Unlock()
+	return m
+}
+
+// File returns a MockFile named name, sharing m's expectations (and their
+// order).
+func (m *MockOS) File(name string) *MockFile {
+	return &MockFile{c: m.c, name: name}
+}
+
+func (m *MockOS) ExpectChdir(dir interface{}) *Expectation {
+	return m.c.expect(m, "Chdir", dir)
+}
+
+func (m *MockOS) ExpectChmod(name, mode interface{}) *Expectation {
+	return m.c.expect(m, "Chmod", name, mode)
+}
+
+func (m *MockOS) ExpectChown(name, uid, gid interface{}) *Expectation {
+	return m.c.expect(m, "Chown", name, uid, gid)
+}
+
+func (m *MockOS) ExpectChtimes(name, atime, mtime interface{}) *Expectation {
+	return m.c.expect(m, "Chtimes", name, atime, mtime)
+}
+
+func (m *MockOS) ExpectClearenv() *Expectation {
+	return m.c.expect(m, "Clearenv")
+}
+
+func (m *MockOS) ExpectEnviron() *Expectation {
+	return m.c.expect(m, "Environ")
+}
+
+func (m *MockOS) ExpectExit(code interface{}) *Expectation {
+	return m.c.expect(m, "Exit", code)
+}
+
+func (m *MockOS) ExpectExpand(s interface{}) *Expectation {
+	return m.c.expect(m, "Expand", s)
+}
+
+func (m *MockOS) ExpectExpandEnv(s interface{}) *Expectation {
+	return m.c.expect(m, "ExpandEnv", s)
+}
+
+func (m *MockOS) ExpectGetegid() *Expectation {
+	return m.c.expect(m, "Getegid")
+}
+
+func (m *MockOS) ExpectGetenv(key interface{}) *Expectation {
+	return m.c.expect(m, "Getenv", key)
+}
+
+func (m *MockOS) ExpectGeteuid() *Expectation {
+	return m.c.expect(m, "Geteuid")
+}
+
+func (m *MockOS) ExpectGetgid() *Expectation {
+	return m.c.expect(m, "Getgid")
+}
+
+func (m *MockOS) ExpectGetgroups() *Expectation {
+	return m.c.expect(m, "Getgroups")
+}
+
+func (m *MockOS) ExpectGetpagesize() *Expectation {
+	return m.c.expect(m, "Getpagesize")
+}
+
+func (m *MockOS) ExpectGetpid() *Expectation {
+	return m.c.expect(m, "Getpid")
+}
+
+func (m *MockOS) ExpectGetppid() *Expectation {
+	return m.c.expect(m, "Getppid")
+}
+
+func (m *MockOS) ExpectGetuid() *Expectation {
+	return m.c.expect(m, "Getuid")
+}
+
+func (m *MockOS) ExpectGetwd() *Expectation {
+	return m.c.expect(m, "Getwd")
+}
+
+func (m *MockOS) ExpectHostname() *Expectation {
+	return m.c.expect(m, "Hostname")
+}
+
+func (m *MockOS) ExpectLchown(name, uid, gid interface{}) *Expectation {
+	return m.c.expect(m, "Lchown", name, uid, gid)
+}
+
+func (m *MockOS) ExpectLink(oldname, newname interface{}) *Expectation {
+	return m.c.expect(m, "Link", oldname, newname)
+}
+
+func (m *MockOS) ExpectMkdir(name, perm interface{}) *Expectation {
+	return m.c.expect(m, "Mkdir", name, perm)
+}
+
+func (m *MockOS) ExpectMkdirAll(path, perm interface{}) *Expectation {
+	return m.c.expect(m, "MkdirAll", path, perm)
+}
+
+func (m *MockOS) ExpectReadlink(name interface{}) *Expectation {
+	return m.c.expect(m, "Readlink", name)
+}
+
+func (m *MockOS) ExpectRemove(name interface{}) *Expectation {
+	return m.c.expect(m, "Remove", name)
+}
+
+func (m *MockOS) ExpectRemoveAll(path interface{}) *Expectation {
+	return m.c.expect(m, "RemoveAll", path)
+}
+
+func (m *MockOS) ExpectRename(oldname, newname interface{}) *Expectation {
+	return m.c.expect(m, "Rename", oldname, newname)
+}
+
+func (m *MockOS) ExpectSetenv(key, value interface{}) *Expectation {
+	return m.c.expect(m, "Setenv", key, value)
+}
+
+func (m *MockOS) ExpectSymlink(oldname, newname interface{}) *Expectation {
+	return m.c.expect(m, "Symlink", oldname, newname)
+}
+
+func (m *MockOS) ExpectTempDir() *Expectation {
+	return m.c.expect(m, "TempDir")
+}
+
+func (m *MockOS) ExpectTruncate(name, size interface{}) *Expectation {
+	return m.c.expect(m, "Truncate", name, size)
+}
+
+func (m *MockOS) ExpectCreate(name interface{}) *Expectation {
+	return m.c.expect(m, "Create", name)
+}
+
+func (m *MockOS) ExpectNewFile(fd, name interface{}) *Expectation {
+	return m.c.expect(m, "NewFile", fd, name)
+}
+
+func (m *MockOS) ExpectOpen(name interface{}) *Expectation {
+	return m.c.expect(m, "Open", name)
+}
+
+func (m *MockOS) ExpectOpenFile(name, flag, perm interface{}) *Expectation {
+	return m.c.expect(m, "OpenFile", name, flag, perm)
+}
+
+func (m *MockOS) ExpectPipe() *Expectation {
+	return m.c.expect(m, "Pipe")
+}
+
+func (m *MockOS) ExpectLstat(name interface{}) *Expectation {
+	return m.c.expect(m, "Lstat", name)
+}
+
+func (m *MockOS) ExpectStat(name interface{}) *Expectation {
+	return m.c.expect(m, "Stat", name)
+}
+
+func (m *MockOS) Chdir(dir string) error {
+	return m.c.call(m, "Chdir", dir).err(0)
+}
+
+func (m *MockOS) Chmod(name string, mode os.FileMode) error {
+	return m.c.call(m, "Chmod", name, mode).err(0)
+}
+
+func (m *MockOS) Chown(name string, uid, gid int) error {
+	return m.c.call(m, "Chown", name, uid, gid).err(0)
+}
+
+func (m *MockOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return m.c.call(m, "Chtimes", name, atime, mtime).err(0)
+}
+
+func (m *MockOS) Clearenv() {
+	m.c.call(m, "Clearenv")
+}
+
+func (m *MockOS) Environ() []string {
+	return m.c.call(m, "Environ").strings(0)
+}
+
+func (m *MockOS) Exit(code int) {
+	m.c.call(m, "Exit", code)
+}
+
+func (m *MockOS) Expand(s string, mapping func(string) string) string {
+	return m.c.call(m, "Expand", s).string(0)
+}
+
+func (m *MockOS) ExpandEnv(s string) string {
+	return m.c.call(m, "ExpandEnv", s).string(0)
+}
+
+func (m *MockOS) Getegid() int {
+	return int(m.c.call(m, "Getegid").integer(0))
+}
+
+func (m *MockOS) Getenv(key string) string {
+	return m.c.call(m, "Getenv", key).string(0)
+}
+
+func (m *MockOS) Geteuid() int {
+	return int(m.c.call(m, "Geteuid").integer(0))
+}
+
+func (m *MockOS) Getgid() int {
+	return int(m.c.call(m, "Getgid").integer(0))
+}
+
+func (m *MockOS) Getgroups() ([]int, error) {
+	r := m.c.call(m, "Getgroups")
+	return r.ints(0), r.err(1)
+}
+
+func (m *MockOS) Getpagesize() int {
+	return int(m.c.call(m, "Getpagesize").integer(0))
+}
+
+func (m *MockOS) Getpid() int {
+	return int(m.c.call(m, "Getpid").integer(0))
+}
+
+func (m *MockOS) Getppid() int {
+	return int(m.c.call(m, "Getppid").integer(0))
+}
+
+func (m *MockOS) Getuid() int {
+	return int(m.c.call(m, "Getuid").integer(0))
+}
+
+func (m *MockOS) Getwd() (dir string, err error) {
+	r := m.c.call(m, "Getwd")
+	return r.string(0), r.err(1)
+}
+
+func (m *MockOS) Hostname() (name string, err error) {
+	r := m.c.call(m, "Hostname")
+	return r.string(0), r.err(1)
+}
+
+func (m *MockOS) IsExist(err error) bool {
+	return os.IsExist(err)
+}
+
+func (m *MockOS) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (m *MockOS) IsPathSeparator(c uint8) bool {
+	return os.IsPathSeparator(c)
+}
+
+func (m *MockOS) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+func (m *MockOS) Lchown(name string, uid, gid int) error {
+	return m.c.call(m, "Lchown", name, uid, gid).err(0)
+}
+
+func (m *MockOS) Link(oldname, newname string) error {
+	return m.c.call(m, "Link", oldname, newname).err(0)
+}
+
+func (m *MockOS) Mkdir(name string, perm os.FileMode) error {
+	return m.c.call(m, "Mkdir", name, perm).err(0)
+}
+
+func (m *MockOS) MkdirAll(path string, perm os.FileMode) error {
+	return m.c.call(m, "MkdirAll", path, perm).err(0)
+}
+
+func (m *MockOS) Readlink(name string) (string, error) {
+	r := m.c.call(m, "Readlink", name)
+	return r.string(0), r.err(1)
+}
+
+func (m *MockOS) Remove(name string) error {
+	return m.c.call(m, "Remove", name).err(0)
+}
+
+func (m *MockOS) RemoveAll(path string) error {
+	return m.c.call(m, "RemoveAll", path).err(0)
+}
+
+func (m *MockOS) Rename(oldname, newname string) error {
+	return m.c.call(m, "Rename", oldname, newname).err(0)
+}
+
+func (m *MockOS) SameFile(fi1, fi2 os.FileInfo) bool {
+	return os.SameFile(fi1, fi2)
+}
+
+func (m *MockOS) Setenv(key, value string) error {
+	return m.c.call(m, "Setenv", key, value).err(0)
+}
+
+func (m *MockOS) Symlink(oldname, newname string) error {
+	return m.c.call(m, "Symlink", oldname, newname).err(0)
+}
+
+func (m *MockOS) TempDir() string {
+	return m.c.call(m, "TempDir").string(0)
+}
+
+func (m *MockOS) Truncate(name string, size int64) error {
+	return m.c.call(m, "Truncate", name, size).err(0)
+}
+
+func (m *MockOS) Create(name string) (file File, err error) {
+	r := m.c.call(m, "Create", name)
+	return r.file(0), r.err(1)
+}
+
+func (m *MockOS) NewFile(fd uintptr, name string) File {
+	return m.c.call(m, "NewFile", fd, name).file(0)
+}
+
+func (m *MockOS) Open(name string) (file File, err error) {
+	r := m.c.call(m, "Open", name)
+	return r.file(0), r.err(1)
+}
+
+func (m *MockOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	r := m.c.call(m, "OpenFile", name, flag, perm)
+	return r.file(0), r.err(1)
+}
+
+func (m *MockOS) Pipe() (r File, w File, err error) {
+	res := m.c.call(m, "Pipe")
+	return res.file(0), res.file(1), res.err(2)
+}
+
+func (m *MockOS) Lstat(name string) (fi os.FileInfo, err error) {
+	r := m.c.call(m, "Lstat", name)
+	return r.fileInfo(0), r.err(1)
+}
+
+func (m *MockOS) Stat(name string) (fi os.FileInfo, err error) {
+	r := m.c.call(m, "Stat", name)
+	return r.fileInfo(0), r.err(1)
+}
+
+// MockFile is a File failing tests when calls made on it aren't the ones
+// it was told to expect, see MockOS. Name isn't mocked.
+type MockFile struct {
+	c    *mockController
+	name string
+}
+
+// NewMockFile returns a MockFile named name reporting unexpected calls to
+// t, and once the test is over, expected calls that weren't made. Use
+// MockOS.File for files returned by a MockOS.
+func NewMockFile(t TestingT, name string) *MockFile {
+	return &MockFile{c: newMockController(t), name: name}
+}
+
+func (f *MockFile) ExpectChdir() *Expectation {
+	return f.c.expect(f, "File.Chdir")
+}
+
+func (f *MockFile) ExpectChmod(mode interface{}) *Expectation {
+	return f.c.expect(f, "File.Chmod", mode)
+}
+
+func (f *MockFile) ExpectChown(uid, gid interface{}) *Expectation {
+	return f.c.expect(f, "File.Chown", uid, gid)
+}
+
+func (f *MockFile) ExpectClose() *Expectation {
+	return f.c.expect(f, "File.Close")
+}
+
+func (f *MockFile) ExpectFd() *Expectation {
+	return f.c.expect(f, "File.Fd")
+}
+
+func (f *MockFile) ExpectLock() *Expectation {
+	return f.c.expect(f, "File.Lock")
+}
+
+func (f *MockFile) ExpectLockRange(off, n, how interface{}) *Expectation {
+	return f.c.expect(f, "File.LockRange", off, n, how)
+}
+
+func (f *MockFile) ExpectRLock() *Expectation {
+	return f.c.expect(f, "File.RLock")
+}
+
+// ExpectRead expects a Read with a buffer of any size.
+func (f *MockFile) ExpectRead() *Expectation {
+	return f.c.expect(f, "File.Read")
+}
+
+// ExpectReadAt expects a ReadAt at off with a buffer of any size.
+func (f *MockFile) ExpectReadAt(off interface{}) *Expectation {
+	return f.c.expect(f, "File.ReadAt", off)
+}
+
+func (f *MockFile) ExpectReaddir(n interface{}) *Expectation {
+	return f.c.expect(f, "File.Readdir", n)
+}
+
+func (f *MockFile) ExpectReaddirnames(n interface{}) *Expectation {
+	return f.c.expect(f, "File.Readdirnames", n)
+}
+
+func (f *MockFile) ExpectSeek(offset, whence interface{}) *Expectation {
+	return f.c.expect(f, "File.Seek", offset, whence)
+}
+
+func (f *MockFile) ExpectStat() *Expectation {
+	return f.c.expect(f, "File.Stat")
+}
+
+func (f *MockFile) ExpectSync() *Expectation {
+	return f.c.expect(f, "File.Sync")
+}
+
+func (f *MockFile) ExpectTruncate(size interface{}) *Expectation {
+	return f.c.expect(f, "File.Truncate", size)
+}
+
+func (f *MockFile) ExpectTryLock() *Expectation {
+	return f.c.expect(f, "File.TryLock")
+}
+
+func (f *MockFile) ExpectUnlock() *Expectation {
+	return f.c.expect(f, "File.Unlock")
+}
+
+// ExpectWrite expects b to be written, b may be a []byte or a string.
+func (f *MockFile) ExpectWrite(b interface{}) *Expectation {
+	return f.c.expect(f, "File.Write", b)
+}
+
+// ExpectWriteAt expects b to be written at off, b may be a []byte or a
+// string.
+func (f *MockFile) ExpectWriteAt(b, off interface{}) *Expectation {
+	return f.c.expect(f, "File.WriteAt", b, off)
+}
+
+func (f *MockFile) ExpectWriteString(s interface{}) *Expectation {
+	return f.c.expect(f, "File.WriteString", s)
+}
+
+func (f *MockFile) Chdir() error {
+	return f.c.call(f, "File.Chdir").err(0)
+}
+
+func (f *MockFile) Chmod(mode os.FileMode) error {
+	return f.c.call(f, "File.Chmod", mode).err(0)
+}
+
+func (f *MockFile) Chown(uid, gid int) error {
+	return f.c.call(f, "File.Chown", uid, gid).err(0)
+}
+
+func (f *MockFile) Close() error {
+	return f.c.call(f, "File.Close").err(0)
+}
+
+func (f *MockFile) Fd() uintptr {
+	return uintptr(f.c.call(f, "File.Fd").integer(0))
+}
+
+func (f *MockFile) Lock() error {
+	return f.c.call(f, "File.Lock").err(0)
+}
+
+func (f *MockFile) LockRange(off, n int64, how int) error {
+	return f.c.call(f, "File.LockRange", off, n, how).err(0)
+}
+
+func (f *MockFile) Name() string {
+	return f.name
+}
+
+func (f *MockFile) RLock() error {
+	return f.c.call(f, "File.RLock").err(0)
+}
+
+func (f *MockFile) Read(b []byte) (n int, err error) {
+	return f.c.call(f, "File.Read").read(b)
+}
+
+func (f *MockFile) ReadAt(b []byte, off int64) (n int, err error) {
+	return f.c.call(f, "File.ReadAt", off).read(b)
+}
+
+func (f *MockFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	r := f.c.call(f, "File.Readdir", n)
+	return r.fileInfos(0), r.err(1)
+}
+
+func (f *MockFile) Readdirnames(n int) (names []string, err error) {
+	r := f.c.call(f, "File.Readdirnames", n)
+	return r.strings(0), r.err(1)
+}
+
+func (f *MockFile) Seek(offset int64, whence int) (ret int64, err error) {
+	r := f.c.call(f, "File.Seek", offset, whence)
+	return r.integer(0), r.err(1)
+}
+
+func (f *MockFile) Stat() (fi os.FileInfo, err error) {
+	r := f.c.call(f, "File.Stat")
+	return r.fileInfo(0), r.err(1)
+}
+
+func (f *MockFile) Sync() (err error) {
+	return f.c.call(f, "File.Sync").err(0)
+}
+
+func (f *MockFile) Truncate(size int64) error {
+	return f.c.call(f, "File.Truncate", size).err(0)
+}
+
+func (f *MockFile) TryLock() error {
+	return f.c.call(f, "File.TryLock").err(0)
+}
+
+func (f *MockFile) Unlock() error {
+	return f.c.call(f, "File.Unlock").err(0)
+}
+
+func (f *MockFile) Write(b []byte) (n int, err error) {
+	return f.c.call(f, "File.Write", b).written(len(b))
+}
+
+func (f *MockFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return f.c.call(f, "File.WriteAt", b, off).written(len(b))
+}
+
+func (f *MockFile) WriteString(s string) (ret int, err error) {
+	return f.c.call(f, "File.WriteString", s).written(len(s))
+}
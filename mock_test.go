@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// loadConfig is the code under test: it reads a whole config file.
+func loadConfig(o OperatingSystem, name string) (string, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	buf := make([]byte, 64)
+	for {
+		n, err := f.Read(buf)
+		b.Write(buf[:n])
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func Test_MockOS_Expectations(t *testing.T) {
+	m := NewMockOS(t)
+	conf := m.File("/etc/app.conf")
+	m.ExpectOpen("/etc/app.conf").Return(conf, nil).Times(1)
+	conf.ExpectRead().Return("key=value", nil)
+	conf.ExpectRead().Return(0, io.EOF)
+	conf.ExpectClose()
+
+	s, err := loadConfig(m, "/etc/app.conf")
+	if err != nil || s != "key=value" {
+		t.Errorf("expected %q, got %q, err: %v", "key=value", s, err)
+	}
+}
+
+func Test_MockOS_Errors(t *testing.T) {
+	m := NewMockOS(t)
+	m.ExpectOpen(Any).Return(nil, &os.PathError{Op: "open", Path: "/missing", Err: syscall.ENOENT}).AnyTimes()
+	m.ExpectMkdirAll("/var/lib/app", 0755)
+
+	if _, err := loadConfig(m, "/missing"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected ENOENT, err: %v", err)
+	}
+	if err := m.MkdirAll("/var/lib/app", 0755); err != nil {
+		t.Errorf("expected MkdirAll to return nil, err: %v", err)
+	}
+}
+
+func Test_MockOS_Unexpected(t *testing.T) {
+	ft := &fakeT{}
+	m := NewMockOS(ft)
+	m.ExpectRemove("/a")
+	m.ExpectRemove("/b")
+
+	if err := m.Remove("/c"); !errors.Is(err, ErrUnexpectedCall) {
+		t.Errorf("expected ErrUnexpectedCall, err: %v", err)
+	}
+	m.Remove("/a")
+	ft.finish()
+
+	if len(ft.errors) != 2 ||
+		!strings.Contains(ft.errors[0], `unexpected call Remove("/c")`) ||
+		!strings.Contains(ft.errors[1], `missing call Remove("/b")`) {
+		t.Errorf("expected the unexpected and the missing call to be reported, got: %q", ft.errors)
+	}
+}
+
+func Test_MockOS_InOrder(t *testing.T) {
+	ft := &fakeT{}
+	m := NewMockOS(ft).InOrder()
+	f := m.File("/data")
+	m.ExpectCreate("/data").Return(f, nil)
+	f.ExpectWrite("rows")
+	f.ExpectSync()
+	f.ExpectClose()
+
+	created, _ := m.Create("/data")
+	if n, err := created.Write([]byte("rows")); n != 4 || err != nil {
+		t.Errorf("expected Write to report everything written, got %d, err: %v", n, err)
+	}
+	created.Close()
+	created.Sync()
+	ft.finish()
+
+	if len(ft.errors) == 0 || !strings.Contains(ft.errors[0], "expected File.Sync() first") {
+		t.Errorf("expected Close before Sync to be reported, got: %q", ft.errors)
+	}
+}
+
+func Test_MockFile(t *testing.T) {
+	f := NewMockFile(t, "/log")
+	f.ExpectSeek(0, SEEK_END).Return(10, nil)
+	f.ExpectWriteString(Any).Times(2)
+
+	if off, err := f.Seek(0, SEEK_END); off != 10 || err != nil {
+		t.Errorf("expected to seek to 10, got %d, err: %v", off, err)
+	}
+	f.WriteString("a")
+	f.WriteString("b")
+	if f.Name() != "/log" {
+		t.Errorf("expected name to be /log, was %q", f.Name())
+	}
+}
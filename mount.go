@@ -0,0 +1,535 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MountTable is an OperatingSystem made up of other OperatingSystems, each
+// mounted at a directory. Paths are served by the OperatingSystem mounted at
+// their longest matching prefix, with the mount point itself showing up as
+// the root ("/") of that OperatingSystem. Like the kernel, Rename and Link
+// across mount points fail with EXDEV.
+//
+// Symlinks are resolved by the OperatingSystem they live in, so they can't
+// point into another mount. Everything that isn't about paths (environment,
+// process info, ...) is answered by the OperatingSystem mounted at "/".
+type MountTable struct {
+	lock   sync.RWMutex
+	root   OperatingSystem
+	mounts map[string]OperatingSystem
+	cwd    string
+}
+
+// NewMountTable returns a MountTable with root mounted at "/".
+func NewMountTable(root OperatingSystem) *MountTable {
+	sep := string(filepath.Separator)
+	return &MountTable{
+		root:   root,
+		mounts: map[string]OperatingSystem{sep: root},
+		cwd:    sep,
+	}
+}
+
+// Mount makes o available at point, hiding whatever was there before.
+func (m *MountTable) Mount(point string, o OperatingSystem) error {
+	if !filepath.IsAbs(point) {
+		return &os.PathError{
+			Op:   "mount",
+			Path: point,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
+	}
+
+	m.lock.Lock()
+	point = filepath.Clean(point)
+	if _, ok := m.mounts[point]; ok {
+		m.lock.Unlock()
+		return &os.PathError{
+			Op:   "mount",
+			Path: point,
+			Err:  syscall.Errno(syscall.EBUSY),
+		}
+	}
+	m.mounts[point] = o
+	m.lock.Unlock()
+	return nil
+}
+
+// Unmount removes whatever is mounted at point. The root can't be unmounted.
+func (m *MountTable) Unmount(point string) error {
+	m.lock.Lock()
+	point = filepath.Clean(point)
+	if _, ok := m.mounts[point]; !ok || point == string(filepath.Separator) {
+		m.lock.Unlock()
+		return &os.PathError{
+			Op:   "unmount",
+			Path: point,
+			Err:  syscall.Errno(syscall.EINVAL),
+		}
+	}
+	delete(m.mounts, point)
+	m.lock.Unlock()
+	return nil
+}
+
+// resolve returns the OperatingSystem serving name, the mount point it's
+// mounted at and the path name has inside of it.
+func (m *MountTable) resolve(name string) (OperatingSystem, string, string) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.cwd, path)
+	}
+	path = filepath.Clean(path)
+
+	best := string(filepath.Separator)
+	for point := range m.mounts {
+		if len(point) > len(best) && under(path, point) {
+			best = point
+		}
+	}
+
+	rel, _ := filepath.Rel(best, path)
+	return m.mounts[best], best, filepath.Join(string(filepath.Separator), rel)
+}
+
+// under reports whether path is dir or lies below it.
+func under(path, dir string) bool {
+	if dir == string(filepath.Separator) {
+		return true
+	}
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// mountsIn returns the mount points directly inside of the directory at
+// path, keyed by their name.
+func (m *MountTable) mountsIn(path string) map[string]OperatingSystem {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	found := map[string]OperatingSystem{}
+	for point, o := range m.mounts {
+		if point != path && filepath.Dir(point) == path {
+			found[filepath.Base(point)] = o
+		}
+	}
+	return found
+}
+
+// abs returns the clean absolute version of name.
+func (m *MountTable) abs(name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return filepath.Join(m.cwd, name)
+}
+
+func (m *MountTable) Chdir(dir string) error {
+	fi, err := m.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{
+			Op:   "chdir",
+			Path: dir,
+			Err:  syscall.Errno(syscall.ENOTDIR),
+		}
+	}
+
+	path := m.abs(dir)
+	m.lock.Lock()
+	m.cwd = path
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *MountTable) Chmod(name string, mode os.FileMode) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Chmod(inner, mode), name)
+}
+
+func (m *MountTable) Chown(name string, uid, gid int) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Chown(inner, uid, gid), name)
+}
+
+func (m *MountTable) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Chtimes(inner, atime, mtime), name)
+}
+
+func (m *MountTable) Clearenv() {
+	m.root.Clearenv()
+}
+
+func (m *MountTable) Environ() []string {
+	return m.root.Environ()
+}
+
+func (m *MountTable) Exit(code int) {
+	m.root.Exit(code)
+}
+
+func (m *MountTable) Expand(s string, mapping func(string) string) string {
+	return m.root.Expand(s, mapping)
+}
+
+func (m *MountTable) ExpandEnv(s string) string {
+	return m.root.ExpandEnv(s)
+}
+
+func (m *MountTable) Getegid() int {
+	return m.root.Getegid()
+}
+
+func (m *MountTable) Getenv(key string) string {
+	return m.root.Getenv(key)
+}
+
+func (m *MountTable) Geteuid() int {
+	return m.root.Geteuid()
+}
+
+func (m *MountTable) Getgid() int {
+	return m.root.Getgid()
+}
+
+func (m *MountTable) Getgroups() ([]int, error) {
+	return m.root.Getgroups()
+}
+
+func (m *MountTable) Getpagesize() int {
+	return m.root.Getpagesize()
+}
+
+func (m *MountTable) Getpid() int {
+	return m.root.Getpid()
+}
+
+func (m *MountTable) Getppid() int {
+	return m.root.Getppid()
+}
+
+func (m *MountTable) Getuid() int {
+	return m.root.Getuid()
+}
+
+func (m *MountTable) Getwd() (dir string, err error) {
+	m.lock.RLock()
+	dir = m.cwd
+	m.lock.RUnlock()
+	return dir, nil
+}
+
+func (m *MountTable) Hostname() (name string, err error) {
+	return m.root.Hostname()
+}
+
+func (m *MountTable) IsExist(err error) bool {
+	return os.IsExist(err)
+}
+
+func (m *MountTable) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (m *MountTable) IsPathSeparator(c uint8) bool {
+	return os.IsPathSeparator(c)
+}
+
+func (m *MountTable) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+func (m *MountTable) Lchown(name string, uid, gid int) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Lchown(inner, uid, gid), name)
+}
+
+// resolvePair resolves both names, failing with EXDEV if they live on
+// different mounts.
+func (m *MountTable) resolvePair(op, oldname, newname string) (OperatingSystem, string, string, error) {
+	o, oldPoint, oldInner := m.resolve(oldname)
+	_, newPoint, newInner := m.resolve(newname)
+	if oldPoint != newPoint {
+		return nil, "", "", linkErr(op, oldname, newname, syscall.Errno(syscall.EXDEV))
+	}
+	return o, oldInner, newInner, nil
+}
+
+func (m *MountTable) Link(oldname, newname string) error {
+	o, oldInner, newInner, err := m.resolvePair("link", oldname, newname)
+	if err != nil {
+		return err
+	}
+	return renameLinkErr(o.Link(oldInner, newInner), oldname, newname)
+}
+
+func (m *MountTable) Mkdir(name string, perm os.FileMode) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Mkdir(inner, perm), name)
+}
+
+func (m *MountTable) MkdirAll(path string, perm os.FileMode) error {
+	o, _, inner := m.resolve(path)
+	return renameErr(o.MkdirAll(inner, perm), path)
+}
+
+func (m *MountTable) Readlink(name string) (string, error) {
+	o, _, inner := m.resolve(name)
+	target, err := o.Readlink(inner)
+	return target, renameErr(err, name)
+}
+
+// busy returns EBUSY if name is a mount point.
+func (m *MountTable) busy(op, name string) error {
+	if _, point, _ := m.resolve(name); point == m.abs(name) {
+		return &os.PathError{
+			Op:   op,
+			Path: name,
+			Err:  syscall.Errno(syscall.EBUSY),
+		}
+	}
+	return nil
+}
+
+func (m *MountTable) Remove(name string) error {
+	if err := m.busy("remove", name); err != nil {
+		return err
+	}
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Remove(inner), name)
+}
+
+func (m *MountTable) RemoveAll(path string) error {
+	if err := m.busy("unlinkat", path); err != nil {
+		return err
+	}
+	o, _, inner := m.resolve(path)
+	return renameErr(o.RemoveAll(inner), path)
+}
+
+func (m *MountTable) Rename(oldname, newname string) error {
+	if err := m.busy("rename", oldname); err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	o, oldInner, newInner, err := m.resolvePair("rename", oldname, newname)
+	if err != nil {
+		return err
+	}
+	return renameLinkErr(o.Rename(oldInner, newInner), oldname, newname)
+}
+
+func (m *MountTable) SameFile(fi1, fi2 os.FileInfo) bool {
+	if ri, ok := fi1.(*renamedInfo); ok {
+		fi1 = ri.FileInfo
+	}
+	if ri, ok := fi2.(*renamedInfo); ok {
+		fi2 = ri.FileInfo
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, o := range m.mounts {
+		if o.SameFile(fi1, fi2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MountTable) Setenv(key, value string) error {
+	return m.root.Setenv(key, value)
+}
+
+func (m *MountTable) Symlink(oldname, newname string) error {
+	o, _, inner := m.resolve(newname)
+	return renameLinkErr(o.Symlink(oldname, inner), oldname, newname)
+}
+
+func (m *MountTable) TempDir() string {
+	return m.root.TempDir()
+}
+
+func (m *MountTable) Truncate(name string, size int64) error {
+	o, _, inner := m.resolve(name)
+	return renameErr(o.Truncate(inner, size), name)
+}
+
+func (m *MountTable) Create(name string) (file File, err error) {
+	return m.OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
+}
+
+func (m *MountTable) NewFile(fd uintptr, name string) File {
+	return m.root.NewFile(fd, name)
+}
+
+func (m *MountTable) Open(name string) (file File, err error) {
+	return m.OpenFile(name, O_RDONLY, 0)
+}
+
+func (m *MountTable) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	o, _, inner := m.resolve(name)
+	f, err := o.OpenFile(inner, flag, perm)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+
+	f = &mountFile{File: f, table: m, name: name, path: m.abs(name)}
+	if mounts := m.mountsIn(m.abs(name)); len(mounts) > 0 {
+		f = &mountDir{File: f, mounts: mounts}
+	}
+	return f, nil
+}
+
+func (m *MountTable) Pipe() (r File, w File, err error) {
+	return m.root.Pipe()
+}
+
+func (m *MountTable) Lstat(name string) (fi os.FileInfo, err error) {
+	o, point, inner := m.resolve(name)
+	fi, err = o.Lstat(inner)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	if inner == string(filepath.Separator) {
+		fi = &renamedInfo{FileInfo: fi, name: filepath.Base(point)}
+	}
+	return fi, nil
+}
+
+func (m *MountTable) Stat(name string) (fi os.FileInfo, err error) {
+	o, point, inner := m.resolve(name)
+	fi, err = o.Stat(inner)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	if inner == string(filepath.Separator) {
+		fi = &renamedInfo{FileInfo: fi, name: filepath.Base(point)}
+	}
+	return fi, nil
+}
+
+// Watch watches name on the OperatingSystem holding it, see Watchable. A
+// recursive watch also watches the OperatingSystems mounted below name.
+func (m *MountTable) Watch(name string, recursive bool) (Watcher, error) {
+	o, point, inner := m.resolve(name)
+	w, err := Watch(o, inner, recursive)
+	if err != nil {
+		return nil, renameErr(err, name)
+	}
+	sources := []watchSource{{w, rebase(inner, name)}}
+	if recursive {
+		path := m.abs(name)
+		for below, o := range m.mountsBelow(path, point) {
+			w, err := Watch(o, string(filepath.Separator), true)
+			if err != nil {
+				for _, s := range sources {
+					s.Close()
+				}
+				return nil, renameErr(err, name)
+			}
+			rel, _ := filepath.Rel(path, below)
+			sources = append(sources, watchSource{
+				w, rebase(string(filepath.Separator), filepath.Join(name, rel)),
+			})
+		}
+	}
+	return relayWatch(sources...), nil
+}
+
+// random draws from the root OperatingSystem, see randomSource.
+func (m *MountTable) random() uint32 {
+	return randomOf(m.root)
+}
+
+// mountsBelow returns the mount points below path, other than point,
+// the one holding it.
+func (m *MountTable) mountsBelow(path, point string) map[string]OperatingSystem {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	found := map[string]OperatingSystem{}
+	for p, o := range m.mounts {
+		if p != point && under(p, path) {
+			found[p] = o
+		}
+	}
+	return found
+}
+
+// mountFile is a File opened through a MountTable.
+type mountFile struct {
+	File
+	table *MountTable
+	name  string // as given to OpenFile
+	path  string // clean and absolute
+}
+
+func (f *mountFile) Name() string {
+	return f.name
+}
+
+func (f *mountFile) Chdir() error {
+	return f.table.Chdir(f.path)
+}
+
+// renamedInfo is an os.FileInfo reporting a different name.
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *renamedInfo) Name() string {
+	return fi.name
+}
+
+// mountDir is a directory with mount points in it, listing it includes the
+// roots of the mounted OperatingSystems.
+type mountDir struct {
+	File
+	mounts  map[string]OperatingSystem
+	dirents []os.FileInfo
+}
+
+func (d *mountDir) Readdir(n int) (fi []os.FileInfo, err error) {
+	if d.dirents == nil {
+		fis, err := d.File.Readdir(-1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		d.dirents = make([]os.FileInfo, 0, len(fis)+len(d.mounts))
+		for _, fi := range fis {
+			if _, ok := d.mounts[fi.Name()]; !ok {
+				d.dirents = append(d.dirents, fi)
+			}
+		}
+		for name, o := range d.mounts {
+			if fi, err := o.Lstat(string(filepath.Separator)); err == nil {
+				d.dirents = append(d.dirents, &renamedInfo{FileInfo: fi, name: name})
+			}
+		}
+		sort.Slice(d.dirents, func(i, j int) bool {
+			return d.dirents[i].Name() < d.dirents[j].Name()
+		})
+	}
+
+	return nextDirents(&d.dirents, n)
+}
+
+func (d *mountDir) Readdirnames(n int) (names []string, err error) {
+	return direntNames(d.Readdir(n))
+}
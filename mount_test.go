@@ -0,0 +1,132 @@
+package fs
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func newMountTable(t *testing.T) (*MountTable, OperatingSystem, OperatingSystem) {
+	root, data := FakeOS(), FakeOS()
+	root.MkdirAll("/etc", 0755)
+	root.Create("/etc/hosts")
+	data.MkdirAll("/db", 0755)
+	data.Create("/db/rows")
+
+	m := NewMountTable(root)
+	if err := m.Mount("/data", data); err != nil {
+		t.Fatalf("failed to mount, err: %v", err)
+	}
+	return m, root, data
+}
+
+func Test_MountTable_Resolve(t *testing.T) {
+	m, root, data := newMountTable(t)
+
+	if _, err := m.Stat("/data/db/rows"); err != nil {
+		t.Errorf("expected to find /data/db/rows, err: %v", err)
+	}
+	if _, err := m.Stat("/etc/hosts"); err != nil {
+		t.Errorf("expected to find /etc/hosts, err: %v", err)
+	}
+
+	f, err := m.Create("/data/db/more")
+	if err != nil {
+		t.Fatalf("failed to create file, err: %v", err)
+	}
+	if f.Name() != "/data/db/more" {
+		t.Errorf("expected file name to include the mount point, was: %q", f.Name())
+	}
+	f.Close()
+
+	if _, err := data.Stat("/db/more"); err != nil {
+		t.Errorf("expected file to be created in the mounted OS, err: %v", err)
+	}
+	if _, err := root.Stat("/data/db/more"); err == nil {
+		t.Errorf("file should not have been created in the root OS")
+	}
+}
+
+func Test_MountTable_LongestPrefix(t *testing.T) {
+	m, _, _ := newMountTable(t)
+	inner := FakeOS()
+	inner.Create("/only-here")
+	if err := m.Mount("/data/db", inner); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Stat("/data/db/only-here"); err != nil {
+		t.Errorf("expected the deepest mount to win, err: %v", err)
+	}
+	if err := m.Mount("/data/db", inner); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected mounting twice to fail with EBUSY, err: %v", err)
+	}
+}
+
+func Test_MountTable_EXDEV(t *testing.T) {
+	m, _, _ := newMountTable(t)
+
+	err := m.Rename("/etc/hosts", "/data/hosts")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected cross mount Rename to fail with EXDEV, err: %v", err)
+	}
+	err = m.Link("/data/db/rows", "/etc/rows")
+	if !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected cross mount Link to fail with EXDEV, err: %v", err)
+	}
+
+	if err := m.Rename("/data/db/rows", "/data/rows"); err != nil {
+		t.Errorf("expected Rename within a mount to work, err: %v", err)
+	}
+}
+
+func Test_MountTable_FileChdir(t *testing.T) {
+	m, root, data := newMountTable(t)
+
+	d, err := m.Open("/data/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Chdir(); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := m.Getwd(); wd != "/data/db" {
+		t.Errorf("expected the mount table to change directory, got %s", wd)
+	}
+	if _, err := m.Stat("rows"); err != nil {
+		t.Errorf("expected to find rows from /data/db, err: %v", err)
+	}
+	for _, o := range []OperatingSystem{root, data} {
+		if wd, _ := o.Getwd(); wd != "/" {
+			t.Errorf("expected the mounted OperatingSystems to stay in /, got %s", wd)
+		}
+	}
+}
+
+func Test_MountTable_Readdir(t *testing.T) {
+	m, _, _ := newMountTable(t)
+
+	dir, err := m.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"data", "etc", "tmp"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+
+	fi, err := m.Stat("/data")
+	if err != nil || fi.Name() != "data" || !fi.IsDir() {
+		t.Errorf("expected mount point to look like a directory, was %v, err: %v", fi, err)
+	}
+	if err := m.Remove("/data"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected removing a mount point to fail with EBUSY, err: %v", err)
+	}
+}
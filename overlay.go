@@ -0,0 +1,1180 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// OverlayOS is a copy-on-write union of two OperatingSystems. Reads fall
+// through to the lower one unless the upper one has its own copy, every
+// write (including deletes and metadata changes) lands in the upper one,
+// copying files up from the lower one first where needed.
+//
+// Deletions of entries that only exist in the lower OperatingSystem are
+// remembered as whiteouts by the OverlayOS itself. Environment variables
+// follow the same rules, process level calls go to the lower
+// OperatingSystem.
+type OverlayOS struct {
+	lower, upper OperatingSystem
+
+	lock       sync.Mutex
+	cwd        string
+	whiteouts  map[string]struct{} // deleted from lower
+	opaque     map[string]struct{} // upper directories hiding their lower counterpart
+	touched    map[string]struct{} // written to in upper
+	envCleared bool
+
+	// watches are told about every change made through the overlay, see
+	// Watch
+	watches watchList
+}
+
+// Overlay returns an OperatingSystem where reads fall through to lower and
+// all modifications land in upper, typically a FakeOS, leaving lower
+// untouched. Diff reports what has been changed.
+func Overlay(lower, upper OperatingSystem) *OverlayOS {
+	cwd, err := lower.Getwd()
+	if err != nil {
+		cwd = string(filepath.Separator)
+	}
+	return &OverlayOS{
+		lower:     lower,
+		upper:     upper,
+		cwd:       cwd,
+		whiteouts: map[string]struct{}{},
+		opaque:    map[string]struct{}{},
+		touched:   map[string]struct{}{},
+	}
+}
+
+// abs must be called with o.lock held.
+func (o *OverlayOS) abs(name string) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(o.cwd, name)
+	}
+	return filepath.Clean(name)
+}
+
+// inLower reports whether the lower layer is visible at path. Must be
+// called with o.lock held.
+func (o *OverlayOS) inLower(path string) bool {
+	for p := path; ; p = filepath.Dir(p) {
+		if _, ok := o.whiteouts[p]; ok {
+			return false
+		}
+		if _, ok := o.opaque[p]; ok && p != path {
+			return false
+		}
+		if p == filepath.Dir(p) {
+			return true
+		}
+	}
+}
+
+// layer returns the OperatingSystem path should be read from along with
+// the result of calling Lstat on it. Must be called with o.lock held.
+func (o *OverlayOS) layer(op, path string) (OperatingSystem, os.FileInfo, error) {
+	fi, err := o.upper.Lstat(path)
+	if err == nil {
+		return o.upper, fi, nil
+	}
+	if !o.upper.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	if o.inLower(path) {
+		if fi, err := o.lower.Lstat(path); err == nil {
+			return o.lower, fi, nil
+		} else if !o.lower.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, &os.PathError{
+		Op:   op,
+		Path: path,
+		Err:  syscall.Errno(syscall.ENOENT),
+	}
+}
+
+// resolve returns the path name refers to once the symbolic links along
+// it are resolved, the last element's only if follow is set. Must be called
+// with o.lock held.
+func (o *OverlayOS) resolve(op, name string, follow bool) (string, error) {
+	sep := string(filepath.Separator)
+	var (
+		resolved = sep
+		rest     = strings.Split(o.abs(name), sep)
+		links    = 0
+	)
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		if elem == "" {
+			continue
+		}
+		next := filepath.Join(resolved, elem)
+		if len(rest) == 0 && !follow {
+			resolved = next
+			break
+		}
+
+		// what doesn't exist is left for the caller to report
+		l, fi, err := o.layer(op, next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{
+				Op:   op,
+				Path: name,
+				Err:  syscall.Errno(syscall.ELOOP),
+			}
+		}
+		target, err := l.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+		}
+		rest = append(strings.Split(target, sep), rest...)
+	}
+	return resolved, nil
+}
+
+// readDir returns the merged contents of the directory at path. Must be
+// called with o.lock held.
+func (o *OverlayOS) readDir(path string) ([]os.FileInfo, error) {
+	var (
+		entries = map[string]os.FileInfo{}
+		found   = false
+	)
+
+	fis, err := readDirOf(o.upper, path)
+	switch {
+	case err == nil:
+		found = true
+		for _, fi := range fis {
+			entries[fi.Name()] = fi
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	if _, ok := o.opaque[path]; !ok && o.inLower(path) {
+		fis, err := readDirOf(o.lower, path)
+		switch {
+		case err == nil:
+			found = true
+			for _, fi := range fis {
+				name := fi.Name()
+				if _, ok := entries[name]; ok {
+					continue
+				}
+				if _, ok := o.whiteouts[filepath.Join(path, name)]; ok {
+					continue
+				}
+				entries[name] = fi
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+
+	if !found {
+		return nil, &os.PathError{
+			Op:   "readdirent",
+			Path: path,
+			Err:  syscall.Errno(syscall.ENOENT),
+		}
+	}
+
+	fis = make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		fis = append(fis, fi)
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+func readDirOf(o OperatingSystem, path string) ([]os.FileInfo, error) {
+	f, err := o.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+// copyUp makes sure path exists in the upper layer, copying it (and its
+// parents) from the lower one if needed. Must be called with o.lock held.
+func (o *OverlayOS) copyUp(op, path string) error {
+	if _, err := o.upper.Lstat(path); err == nil {
+		return nil
+	}
+	if !o.inLower(path) {
+		return &os.PathError{
+			Op:   op,
+			Path: path,
+			Err:  syscall.Errno(syscall.ENOENT),
+		}
+	}
+
+	fi, err := o.lower.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := o.prepare(op, path); err != nil {
+		return err
+	}
+
+	switch {
+	case fi.IsDir():
+		err = o.upper.Mkdir(path, fi.Mode().Perm())
+	case fi.Mode()&os.ModeSymlink != 0:
+		var target string
+		if target, err = o.lower.Readlink(path); err == nil {
+			err = o.upper.Symlink(target, path)
+		}
+	default:
+		err = o.copyFileUp(path, fi)
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		if err := o.upper.Chmod(path, fi.Mode()); err != nil {
+			return err
+		}
+		if err := o.upper.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	if st, ok := sysStat(fi); ok {
+		// the upper layer may not let us, which is fine
+		o.upper.Lchown(path, st.uid, st.gid)
+	}
+	return nil
+}
+
+func (o *OverlayOS) copyFileUp(path string, fi os.FileInfo) error {
+	src, err := o.lower.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := o.upper.OpenFile(path, O_WRONLY|O_CREATE|O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// copyUpTree copies path and everything below it into the upper layer. Must
+// be called with o.lock held.
+func (o *OverlayOS) copyUpTree(op, path string) error {
+	if err := o.copyUp(op, path); err != nil {
+		return err
+	}
+
+	fi, err := o.upper.Lstat(path)
+	if err != nil || !fi.IsDir() {
+		return err
+	}
+
+	fis, err := o.readDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := o.copyUpTree(op, filepath.Join(path, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare makes sure the parent directory of path exists in the upper layer
+// if it exists at all. Must be called with o.lock held.
+func (o *OverlayOS) prepare(op, path string) error {
+	parent := filepath.Dir(path)
+	if parent == path {
+		return nil
+	}
+	if _, _, err := o.layer(op, parent); err != nil {
+		// let the upper layer complain about it
+		return nil
+	}
+	return o.copyUp(op, parent)
+}
+
+// created records that path now exists in the upper layer. Must be called
+// with o.lock held.
+func (o *OverlayOS) created(path string, isDir bool) {
+	if _, ok := o.whiteouts[path]; ok {
+		delete(o.whiteouts, path)
+		if isDir {
+			o.opaque[path] = struct{}{}
+		}
+	}
+	o.touched[path] = struct{}{}
+}
+
+// removed records that path (and everything below it) is gone. Must be
+// called with o.lock held.
+func (o *OverlayOS) removed(path string) {
+	prefix := path + string(filepath.Separator)
+	for _, set := range []map[string]struct{}{o.whiteouts, o.opaque, o.touched} {
+		for p := range set {
+			if p == path || strings.HasPrefix(p, prefix) {
+				delete(set, p)
+			}
+		}
+	}
+
+	if o.inLower(path) {
+		if _, err := o.lower.Lstat(path); err == nil {
+			o.whiteouts[path] = struct{}{}
+		}
+	}
+}
+
+// descendants returns the paths below the directory at path, parents
+// first. Must be called with o.lock held.
+func (o *OverlayOS) descendants(path string) []string {
+	fis, err := o.readDir(path)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, fi := range fis {
+		p := filepath.Join(path, fi.Name())
+		paths = append(paths, p)
+		if fi.IsDir() {
+			paths = append(paths, o.descendants(p)...)
+		}
+	}
+	return paths
+}
+
+// exists must be called with o.lock held.
+func (o *OverlayOS) exists(path string) bool {
+	_, _, err := o.layer("lstat", path)
+	return err == nil
+}
+
+func (o *OverlayOS) Chdir(dir string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("chdir", dir, true)
+	if err != nil {
+		return err
+	}
+	_, fi, err := o.layer("chdir", path)
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: dir, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: syscall.Errno(syscall.ENOTDIR)}
+	}
+	o.cwd = path
+	return nil
+}
+
+// modify copies the target of name up and hands its path in the upper layer
+// to fn, reporting ev to the watches if it succeeds.
+func (o *OverlayOS) modify(op, name string, follow bool, ev EventOp, fn func(path string) error) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve(op, name, follow)
+	if err != nil {
+		return err
+	}
+	if err := o.copyUp(op, path); err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			pe.Path = name
+		}
+		return err
+	}
+	if err := fn(path); err != nil {
+		return err
+	}
+	o.touched[path] = struct{}{}
+	o.watches.notify(path, ev)
+	return nil
+}
+
+func (o *OverlayOS) Chmod(name string, mode os.FileMode) error {
+	return o.modify("chmod", name, true, EventChmod, func(path string) error {
+		return o.upper.Chmod(path, mode)
+	})
+}
+
+func (o *OverlayOS) Chown(name string, uid, gid int) error {
+	return o.modify("chown", name, true, EventChmod, func(path string) error {
+		return o.upper.Chown(path, uid, gid)
+	})
+}
+
+func (o *OverlayOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return o.modify("chtimes", name, true, EventChmod, func(path string) error {
+		return o.upper.Chtimes(path, atime, mtime)
+	})
+}
+
+func (o *OverlayOS) Clearenv() {
+	o.lock.Lock()
+	o.upper.Clearenv()
+	o.envCleared = true
+	o.lock.Unlock()
+}
+
+func (o *OverlayOS) Environ() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var (
+		env  []string
+		seen = map[string]bool{}
+	)
+	for _, kv := range o.upper.Environ() {
+		seen[strings.SplitN(kv, "=", 2)[0]] = true
+		env = append(env, kv)
+	}
+	if o.envCleared {
+		return env
+	}
+	for _, kv := range o.lower.Environ() {
+		if !seen[strings.SplitN(kv, "=", 2)[0]] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+func (o *OverlayOS) Exit(code int) {
+	o.lower.Exit(code)
+}
+
+func (o *OverlayOS) Expand(s string, mapping func(string) string) string {
+	return os.Expand(s, mapping)
+}
+
+func (o *OverlayOS) ExpandEnv(s string) string {
+	return os.Expand(s, o.Getenv)
+}
+
+func (o *OverlayOS) Getegid() int {
+	return o.lower.Getegid()
+}
+
+func (o *OverlayOS) Getenv(key string) string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	prefix := key + "="
+	for _, kv := range o.upper.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return kv[len(prefix):]
+		}
+	}
+	if o.envCleared {
+		return ""
+	}
+	return o.lower.Getenv(key)
+}
+
+func (o *OverlayOS) Geteuid() int {
+	return o.lower.Geteuid()
+}
+
+func (o *OverlayOS) Getgid() int {
+	return o.lower.Getgid()
+}
+
+func (o *OverlayOS) Getgroups() ([]int, error) {
+	return o.lower.Getgroups()
+}
+
+func (o *OverlayOS) Getpagesize() int {
+	return o.lower.Getpagesize()
+}
+
+func (o *OverlayOS) Getpid() int {
+	return o.lower.Getpid()
+}
+
+func (o *OverlayOS) Getppid() int {
+	return o.lower.Getppid()
+}
+
+func (o *OverlayOS) Getuid() int {
+	return o.lower.Getuid()
+}
+
+func (o *OverlayOS) Getwd() (dir string, err error) {
+	o.lock.Lock()
+	dir = o.cwd
+	o.lock.Unlock()
+	return dir, nil
+}
+
+func (o *OverlayOS) Hostname() (name string, err error) {
+	return o.lower.Hostname()
+}
+
+func (o *OverlayOS) IsExist(err error) bool {
+	return os.IsExist(err)
+}
+
+func (o *OverlayOS) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (o *OverlayOS) IsPathSeparator(c uint8) bool {
+	return os.IsPathSeparator(c)
+}
+
+func (o *OverlayOS) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+func (o *OverlayOS) Lchown(name string, uid, gid int) error {
+	return o.modify("lchown", name, false, EventChmod, func(path string) error {
+		return o.upper.Lchown(path, uid, gid)
+	})
+}
+
+func (o *OverlayOS) Link(oldname, newname string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	oldPath, err := o.resolve("link", oldname, false)
+	if err != nil {
+		return linkErr("link", oldname, newname, err)
+	}
+	newPath, err := o.resolve("link", newname, false)
+	if err != nil {
+		return linkErr("link", oldname, newname, err)
+	}
+	if o.exists(newPath) {
+		return linkErr("link", oldname, newname, syscall.Errno(syscall.EEXIST))
+	}
+	if err := o.copyUp("link", oldPath); err != nil {
+		return linkErr("link", oldname, newname, err)
+	}
+	if err := o.prepare("link", newPath); err != nil {
+		return linkErr("link", oldname, newname, err)
+	}
+
+	if err := o.upper.Link(oldPath, newPath); err != nil {
+		return err
+	}
+	o.created(newPath, false)
+	o.watches.notify(newPath, EventCreate)
+	return nil
+}
+
+func (o *OverlayOS) Mkdir(name string, perm os.FileMode) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	if o.exists(path) {
+		return &os.PathError{
+			Op:   "mkdir",
+			Path: name,
+			Err:  syscall.Errno(syscall.EEXIST),
+		}
+	}
+	if err := o.prepare("mkdir", path); err != nil {
+		return err
+	}
+
+	if err := o.upper.Mkdir(path, perm); err != nil {
+		return err
+	}
+	o.created(path, true)
+	o.watches.notify(path, EventCreate)
+	return nil
+}
+
+func (o *OverlayOS) MkdirAll(path string, perm os.FileMode) error {
+	o.lock.Lock()
+	abs := o.abs(path)
+	o.lock.Unlock()
+
+	curr := string(filepath.Separator)
+	for _, piece := range strings.Split(abs, string(filepath.Separator)) {
+		if piece == "" {
+			continue
+		}
+		curr = filepath.Join(curr, piece)
+
+		fi, err := o.Stat(curr)
+		if err == nil {
+			if !fi.IsDir() {
+				return &os.PathError{
+					Op:   "mkdir",
+					Path: path,
+					Err:  syscall.Errno(syscall.ENOTDIR),
+				}
+			}
+			continue
+		}
+		if err := o.Mkdir(curr, perm); err != nil && !o.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OverlayOS) Readlink(name string) (string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	l, _, err := o.layer("readlink", path)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	return l.Readlink(path)
+}
+
+func (o *OverlayOS) Remove(name string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	l, fi, err := o.layer("remove", path)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+
+	if fi.IsDir() {
+		fis, err := o.readDir(path)
+		if err != nil {
+			return err
+		}
+		if len(fis) > 0 {
+			return &os.PathError{
+				Op:   "remove",
+				Path: name,
+				Err:  syscall.Errno(syscall.ENOTEMPTY),
+			}
+		}
+	}
+
+	if l == o.upper {
+		if err := o.upper.Remove(path); err != nil {
+			return err
+		}
+	}
+	o.removed(path)
+	o.watches.notify(path, EventRemove)
+	return nil
+}
+
+func (o *OverlayOS) RemoveAll(path string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	abs, err := o.resolve("unlinkat", path, false)
+	if err != nil {
+		return err
+	}
+	if !o.exists(abs) {
+		return nil
+	}
+	var descendants []string
+	if !o.watches.empty() {
+		descendants = o.descendants(abs)
+	}
+	if err := o.upper.RemoveAll(abs); err != nil {
+		return err
+	}
+	o.removed(abs)
+	for i := len(descendants) - 1; i >= 0; i-- {
+		o.watches.notify(descendants[i], EventRemove)
+	}
+	o.watches.notify(abs, EventRemove)
+	return nil
+}
+
+func (o *OverlayOS) Rename(oldname, newname string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	oldPath, err := o.resolve("rename", oldname, false)
+	if err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	newPath, err := o.resolve("rename", newname, false)
+	if err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	_, fi, err := o.layer("rename", oldPath)
+	if err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	if oldPath == newPath {
+		return nil
+	}
+
+	if err := o.copyUpTree("rename", oldPath); err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+	if o.exists(newPath) {
+		// make sure the upper layer sees what it's replacing
+		if err := o.copyUp("rename", newPath); err != nil {
+			return linkErr("rename", oldname, newname, err)
+		}
+	} else if err := o.prepare("rename", newPath); err != nil {
+		return linkErr("rename", oldname, newname, err)
+	}
+
+	if err := o.upper.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	o.removed(oldPath)
+	o.removed(newPath)
+	delete(o.whiteouts, newPath)
+	if fi.IsDir() {
+		// everything in it has been copied up
+		o.opaque[newPath] = struct{}{}
+	}
+	o.touched[newPath] = struct{}{}
+	o.watches.notify(oldPath, EventRename)
+	o.watches.notify(newPath, EventCreate)
+	return nil
+}
+
+func (o *OverlayOS) SameFile(fi1, fi2 os.FileInfo) bool {
+	return o.upper.SameFile(fi1, fi2) || o.lower.SameFile(fi1, fi2)
+}
+
+func (o *OverlayOS) Setenv(key, value string) error {
+	return o.upper.Setenv(key, value)
+}
+
+func (o *OverlayOS) Symlink(oldname, newname string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("symlink", newname, false)
+	if err != nil {
+		return linkErr("symlink", oldname, newname, err)
+	}
+	if o.exists(path) {
+		return linkErr("symlink", oldname, newname, syscall.Errno(syscall.EEXIST))
+	}
+	if err := o.prepare("symlink", path); err != nil {
+		return linkErr("symlink", oldname, newname, err)
+	}
+
+	if err := o.upper.Symlink(oldname, path); err != nil {
+		return err
+	}
+	o.created(path, false)
+	o.watches.notify(path, EventCreate)
+	return nil
+}
+
+func (o *OverlayOS) TempDir() string {
+	return o.lower.TempDir()
+}
+
+func (o *OverlayOS) Truncate(name string, size int64) error {
+	return o.modify("truncate", name, true, EventWrite, func(path string) error {
+		return o.upper.Truncate(path, size)
+	})
+}
+
+func (o *OverlayOS) Create(name string) (file File, err error) {
+	return o.OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
+}
+
+func (o *OverlayOS) NewFile(fd uintptr, name string) File {
+	return o.upper.NewFile(fd, name)
+}
+
+func (o *OverlayOS) Open(name string) (file File, err error) {
+	return o.OpenFile(name, O_RDONLY, 0)
+}
+
+func (o *OverlayOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&writeFlags == 0 {
+		l, fi, err := o.layer("open", path)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+		}
+
+		f, err := l.OpenFile(path, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			f = &overlayDir{File: f, overlay: o, path: path}
+		}
+		return &overlayFile{File: f, overlay: o, name: name, path: path, lower: l == o.lower}, nil
+	}
+
+	existed := o.exists(path)
+	if existed {
+		if flag&O_CREATE != 0 && flag&O_EXCL != 0 {
+			return nil, &os.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  syscall.Errno(syscall.EEXIST),
+			}
+		}
+		if err := o.copyUp("open", path); err != nil {
+			return nil, err
+		}
+	} else if err := o.prepare("open", path); err != nil {
+		return nil, err
+	}
+
+	f, err := o.upper.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	o.created(path, false)
+	switch {
+	case !existed:
+		o.watches.notify(path, EventCreate)
+	case flag&O_TRUNC != 0:
+		o.watches.notify(path, EventWrite)
+	}
+	return &overlayFile{File: f, overlay: o, name: name, path: path}, nil
+}
+
+func (o *OverlayOS) Pipe() (r File, w File, err error) {
+	return o.upper.Pipe()
+}
+
+func (o *OverlayOS) Lstat(name string) (fi os.FileInfo, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	_, fi, err = o.layer("lstat", path)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	return fi, nil
+}
+
+func (o *OverlayOS) Stat(name string) (fi os.FileInfo, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	_, fi, err = o.layer("stat", path)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	return fi, nil
+}
+
+// Watch reports the changes made through the overlay to the file or
+// directory at name, see Watchable. Changes made to the layers directly
+// aren't reported.
+func (o *OverlayOS) Watch(name string, recursive bool) (Watcher, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	path, err := o.resolve("watch", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !o.exists(path) {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: syscall.Errno(syscall.ENOENT)}
+	}
+	return o.watches.add(name, path, recursive), nil
+}
+
+// random draws from the upper layer, which holds the temporary files, see
+// randomSource.
+func (o *OverlayOS) random() uint32 {
+	return randomOf(o.upper)
+}
+
+// Diff reports every entry that was added, removed or modified through the
+// overlay, relative to the lower OperatingSystem. Directories that were
+// merely copied up to hold a modified file aren't reported.
+func (o *OverlayOS) Diff() []Change {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var (
+		changes []Change
+		seen    = map[string]bool{}
+	)
+	add := func(kind ChangeKind, path string) {
+		if !seen[path] {
+			seen[path] = true
+			changes = append(changes, Change{Kind: kind, Path: path})
+		}
+	}
+
+	var diffPath func(path string)
+	diffPath = func(path string) {
+		if seen[path] {
+			return
+		}
+		ufi, err := o.upper.Lstat(path)
+		if err != nil {
+			return
+		}
+
+		var lfi os.FileInfo
+		if o.inLower(path) {
+			lfi, _ = o.lower.Lstat(path)
+		}
+		if lfi == nil {
+			add(Added, path)
+		} else if o.changed(path, ufi, lfi) {
+			add(Modified, path)
+		} else {
+			seen[path] = true
+		}
+
+		_, isOpaque := o.opaque[path]
+		if !ufi.IsDir() || (lfi != nil && !isOpaque) {
+			return
+		}
+
+		fis, _ := readDirOf(o.upper, path)
+		names := map[string]bool{}
+		for _, fi := range fis {
+			names[fi.Name()] = true
+			diffPath(filepath.Join(path, fi.Name()))
+		}
+		if lfi != nil && lfi.IsDir() {
+			fis, _ := readDirOf(o.lower, path)
+			for _, fi := range fis {
+				if !names[fi.Name()] {
+					add(Removed, filepath.Join(path, fi.Name()))
+				}
+			}
+		}
+	}
+
+	for path := range o.whiteouts {
+		if _, err := o.lower.Lstat(path); err == nil {
+			add(Removed, path)
+		}
+	}
+	for path := range o.touched {
+		diffPath(path)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// changed reports whether the entry at path differs between the layers.
+func (o *OverlayOS) changed(path string, ufi, lfi os.FileInfo) bool {
+	if ufi.Mode() != lfi.Mode() {
+		return true
+	}
+
+	switch {
+	case ufi.IsDir():
+		return false
+	case ufi.Mode()&os.ModeSymlink != 0:
+		ut, _ := o.upper.Readlink(path)
+		lt, _ := o.lower.Readlink(path)
+		return ut != lt
+	}
+
+	if ufi.Size() != lfi.Size() || !ufi.ModTime().Equal(lfi.ModTime()) {
+		return true
+	}
+	same, err := sameContent(o.upper, path, o.lower, path)
+	return err != nil || !same
+}
+
+// sameContent reports whether the files at a and b have the same contents.
+func sameContent(aOS OperatingSystem, a string, bOS OperatingSystem, b string) (bool, error) {
+	fa, err := aOS.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := bOS.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	var bufA, bufB [32 * 1024]byte
+	for {
+		na, errA := io.ReadFull(fa, bufA[:])
+		nb, errB := io.ReadFull(fb, bufB[:])
+		if na != nb || string(bufA[:na]) != string(bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// overlayFile is a File opened through an OverlayOS. One opened for
+// reading may be in the lower layer: changing its mode or owner then copies
+// it up first, the change landing on the copy rather than on the open
+// file, and writing to it fails with EBADF.
+type overlayFile struct {
+	File
+	overlay *OverlayOS
+	name    string // as given to OpenFile
+	path    string // clean and absolute
+	lower   bool
+}
+
+func (f *overlayFile) Name() string {
+	return f.name
+}
+
+func (f *overlayFile) Chdir() error {
+	return f.overlay.Chdir(f.path)
+}
+
+func (f *overlayFile) Chmod(mode os.FileMode) error {
+	if !f.lower {
+		return f.notify(f.File.Chmod(mode), EventChmod)
+	}
+	return f.overlay.modify("chmod", f.path, false, EventChmod, func(path string) error {
+		return f.overlay.upper.Chmod(path, mode)
+	})
+}
+
+func (f *overlayFile) Chown(uid, gid int) error {
+	if !f.lower {
+		return f.notify(f.File.Chown(uid, gid), EventChmod)
+	}
+	return f.overlay.modify("chown", f.path, false, EventChmod, func(path string) error {
+		return f.overlay.upper.Chown(path, uid, gid)
+	})
+}
+
+func (f *overlayFile) Truncate(size int64) error {
+	if f.lower {
+		return f.badf("truncate")
+	}
+	return f.notify(f.File.Truncate(size), EventWrite)
+}
+
+func (f *overlayFile) Write(b []byte) (n int, err error) {
+	if f.lower {
+		return 0, f.badf("write")
+	}
+	n, err = f.File.Write(b)
+	return n, f.notify(err, EventWrite)
+}
+
+func (f *overlayFile) WriteAt(b []byte, off int64) (n int, err error) {
+	if f.lower {
+		return 0, f.badf("write")
+	}
+	n, err = f.File.WriteAt(b, off)
+	return n, f.notify(err, EventWrite)
+}
+
+func (f *overlayFile) WriteString(s string) (ret int, err error) {
+	if f.lower {
+		return 0, f.badf("write")
+	}
+	ret, err = f.File.WriteString(s)
+	return ret, f.notify(err, EventWrite)
+}
+
+// notify reports ev to the watches of the overlay unless err says the
+// change failed, and returns err.
+func (f *overlayFile) notify(err error, ev EventOp) error {
+	if err == nil {
+		f.overlay.watches.notify(f.path, ev)
+	}
+	return err
+}
+
+// badf is the error of writing to a file opened for reading.
+func (f *overlayFile) badf(op string) error {
+	return &os.PathError{Op: op, Path: f.name, Err: syscall.Errno(syscall.EBADF)}
+}
+
+// overlayDir is a directory opened through an OverlayOS, listing it merges
+// both layers.
+type overlayDir struct {
+	File
+	overlay *OverlayOS
+	path    string
+	dirents []os.FileInfo
+}
+
+func (d *overlayDir) Readdir(n int) (fi []os.FileInfo, err error) {
+	if d.dirents == nil {
+		d.overlay.lock.Lock()
+		d.dirents, err = d.overlay.readDir(d.path)
+		d.overlay.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nextDirents(&d.dirents, n)
+}
+
+func (d *overlayDir) Readdirnames(n int) (names []string, err error) {
+	return direntNames(d.Readdir(n))
+}
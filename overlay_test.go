@@ -0,0 +1,295 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func newOverlay(t *testing.T) (*OverlayOS, string) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"README":       "hello",
+		"src/main.go":  "package main",
+		"src/util.go":  "package main // util",
+		"docs/a/b.txt": "b",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Overlay(ReadOnly(DefaultOS()), FakeOS()), dir
+}
+
+func readAll(t *testing.T, o OperatingSystem, name string) string {
+	f, err := o.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open %s, err: %v", name, err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("failed to read %s, err: %v", name, err)
+	}
+	return string(b)
+}
+
+// writeFile creates the file name on o, holding content.
+func writeFile(o OperatingSystem, name, content string) error {
+	f, err := o.OpenFile(name, O_WRONLY|O_CREATE|O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func Test_Overlay_ReadThrough(t *testing.T) {
+	o, dir := newOverlay(t)
+	if v := readAll(t, o, filepath.Join(dir, "README")); v != "hello" {
+		t.Errorf("expected to read lower file, got %q", v)
+	}
+}
+
+func Test_Overlay_WriteLandsInUpper(t *testing.T) {
+	o, dir := newOverlay(t)
+	name := filepath.Join(dir, "README")
+
+	f, err := o.OpenFile(name, O_WRONLY|O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open for append, err: %v", err)
+	}
+	f.WriteString(", world")
+	f.Close()
+
+	if v := readAll(t, o, name); v != "hello, world" {
+		t.Errorf("expected overlay to see the write, got %q", v)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "hello" {
+		t.Errorf("lower file was modified: %q", b)
+	}
+}
+
+func Test_Overlay_ReadOnlyHandle(t *testing.T) {
+	lower := FakeOS()
+	lower.MkdirAll("/srv/data", 0755)
+	writeFile(lower, "/srv/app.conf", "debug=false")
+	o := Overlay(lower, FakeOS())
+
+	f, err := o.Open("/srv/app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		t.Errorf("failed to chmod, err: %v", err)
+	}
+	if _, err := f.WriteString("debug=true"); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("expected EBADF writing, err: %v", err)
+	}
+	if fi, _ := o.Stat("/srv/app.conf"); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the overlay to see the new mode, got %v", fi.Mode())
+	}
+	if fi, _ := lower.Stat("/srv/app.conf"); fi.Mode().Perm() != 0644 {
+		t.Errorf("expected the lower layer to be left alone, got %v", fi.Mode())
+	}
+	if data := readAll(t, lower, "/srv/app.conf"); data != "debug=false" {
+		t.Errorf("expected the lower layer to be left alone, got %q", data)
+	}
+
+	d, err := o.Open("/srv/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Chdir(); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := o.Getwd(); wd != "/srv/data" {
+		t.Errorf("expected the overlay to change directory, got %s", wd)
+	}
+	if wd, _ := lower.Getwd(); wd != "/" {
+		t.Errorf("expected the lower layer to stay in /, got %s", wd)
+	}
+}
+
+func Test_Overlay_SymlinkedParent(t *testing.T) {
+	lower := FakeOS()
+	lower.MkdirAll("/srv/releases/v2", 0755)
+	writeFile(lower, "/srv/releases/v2/app.conf", "v2")
+	lower.Symlink("releases/v2", "/srv/current")
+	o := Overlay(lower, FakeOS())
+
+	if err := writeFile(o, "/srv/current/app.conf", "patched"); err != nil {
+		t.Fatalf("failed to write through the link, err: %v", err)
+	}
+	if err := o.Mkdir("/srv/current/logs", 0755); err != nil {
+		t.Fatalf("failed to create a directory through the link, err: %v", err)
+	}
+	if data := readAll(t, o, "/srv/releases/v2/app.conf"); data != "patched" {
+		t.Errorf("expected the target to be written, got %q", data)
+	}
+	if fi, err := o.Lstat("/srv/releases/v2/logs"); err != nil || !fi.IsDir() {
+		t.Errorf("expected the directory in the target, got %v, err: %v", fi, err)
+	}
+	if fi, _ := o.Lstat("/srv/current"); fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the link to stay a link, got %v", fi.Mode())
+	}
+
+	if err := o.Remove("/srv/current/app.conf"); err != nil {
+		t.Fatalf("failed to remove through the link, err: %v", err)
+	}
+	if _, err := o.Stat("/srv/releases/v2/app.conf"); !os.IsNotExist(err) {
+		t.Errorf("expected the target to be removed, err: %v", err)
+	}
+	if data := readAll(t, lower, "/srv/releases/v2/app.conf"); data != "v2" {
+		t.Errorf("expected the lower layer to be left alone, got %q", data)
+	}
+}
+
+func Test_Overlay_Whiteout(t *testing.T) {
+	o, dir := newOverlay(t)
+	name := filepath.Join(dir, "src", "util.go")
+
+	if err := o.Remove(name); err != nil {
+		t.Fatalf("failed to remove, err: %v", err)
+	}
+	if _, err := o.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected removed file to be gone, err: %v", err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("lower file was removed, err: %v", err)
+	}
+
+	if err := o.Remove(filepath.Join(dir, "docs")); err == nil {
+		t.Errorf("expected removing a non-empty directory to fail")
+	}
+}
+
+func Test_Overlay_Readdir(t *testing.T) {
+	o, dir := newOverlay(t)
+	src := filepath.Join(dir, "src")
+
+	if err := o.Remove(filepath.Join(src, "util.go")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := o.Create(filepath.Join(src, "new.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d, err := o.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main.go", "new.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected merged listing %v, got %v", want, names)
+	}
+}
+
+func Test_Overlay_Readdir_Errors(t *testing.T) {
+	lower, upper := FakeOS(), FakeOS()
+	lower.MkdirAll("/srv", 0755)
+	upper.MkdirAll("/srv", 0755)
+	eio := syscall.Errno(syscall.EIO)
+	o := Overlay(lower, &failingOS{OperatingSystem: upper, op: "File.Readdir", err: eio})
+
+	d, err := o.Open("/srv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Readdir(-1); !errors.Is(err, eio) {
+		t.Errorf("expected EIO listing the upper layer, err: %v", err)
+	}
+	if err := o.Remove("/srv"); !errors.Is(err, eio) {
+		t.Errorf("expected EIO removing, err: %v", err)
+	}
+}
+
+func Test_Overlay_Rename(t *testing.T) {
+	o, dir := newOverlay(t)
+	docs, moved := filepath.Join(dir, "docs"), filepath.Join(dir, "moved")
+
+	if err := o.Rename(docs, moved); err != nil {
+		t.Fatalf("failed to rename, err: %v", err)
+	}
+	if v := readAll(t, o, filepath.Join(moved, "a", "b.txt")); v != "b" {
+		t.Errorf("expected renamed tree to be readable, got %q", v)
+	}
+	if _, err := o.Stat(docs); !os.IsNotExist(err) {
+		t.Errorf("expected old name to be gone, err: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(docs, "a", "b.txt")); err != nil {
+		t.Errorf("lower tree was modified, err: %v", err)
+	}
+}
+
+func Test_Overlay_Diff(t *testing.T) {
+	o, dir := newOverlay(t)
+
+	f, err := o.Create(filepath.Join(dir, "src", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("package other")
+	f.Close()
+
+	if err := o.MkdirAll(filepath.Join(dir, "out", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Remove(filepath.Join(dir, "README")); err != nil {
+		t.Fatal(err)
+	}
+	// changing nothing shouldn't show up
+	if err := o.Chmod(filepath.Join(dir, "src", "util.go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Change{
+		{Kind: Removed, Path: filepath.Join(dir, "README")},
+		{Kind: Added, Path: filepath.Join(dir, "out")},
+		{Kind: Added, Path: filepath.Join(dir, "out", "bin")},
+		{Kind: Modified, Path: filepath.Join(dir, "src", "main.go")},
+	}
+	if got := o.Diff(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected Diff to be %v, was: %v", want, got)
+	}
+}
+
+func Test_Overlay_Env(t *testing.T) {
+	lower := FakeOS()
+	lower.Setenv("HOME", "/home/lower")
+	o := Overlay(lower, FakeOS())
+
+	if v := o.Getenv("HOME"); v != "/home/lower" {
+		t.Errorf("expected $HOME to fall through, was: %q", v)
+	}
+	o.Setenv("HOME", "/home/upper")
+	if v := o.Getenv("HOME"); v != "/home/upper" {
+		t.Errorf("expected $HOME from upper, was: %q", v)
+	}
+	if v := lower.Getenv("HOME"); v != "/home/lower" {
+		t.Errorf("lower env was modified: %q", v)
+	}
+}
@@ -0,0 +1,166 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// writeFlags are the OpenFile flags that can modify the file system.
+const writeFlags = O_WRONLY | O_RDWR | O_APPEND | O_CREATE | O_TRUNC
+
+type readOnlyOS struct {
+	OperatingSystem
+}
+
+// ReadOnly wraps o so that every call that would modify the file system (or
+// the environment) fails with EROFS (or EPERM) instead of reaching o. Read
+// calls are passed straight through, and any File handed out refuses to be
+// written to.
+func ReadOnly(o OperatingSystem) OperatingSystem {
+	return &readOnlyOS{OperatingSystem: o}
+}
+
+func erofs(op, name string) error {
+	return &os.PathError{
+		Op:   op,
+		Path: name,
+		Err:  syscall.Errno(syscall.EROFS),
+	}
+}
+
+func erofsLink(op, oldname, newname string) error {
+	return &os.LinkError{
+		Op:  op,
+		Old: oldname,
+		New: newname,
+		Err: syscall.Errno(syscall.EROFS),
+	}
+}
+
+func (r *readOnlyOS) Chmod(name string, mode os.FileMode) error {
+	return erofs("chmod", name)
+}
+
+func (r *readOnlyOS) Chown(name string, uid, gid int) error {
+	return erofs("chown", name)
+}
+
+func (r *readOnlyOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return erofs("chtimes", name)
+}
+
+func (r *readOnlyOS) Clearenv() {
+	// Clearenv can't report failure, so the best we can do is to not do it.
+}
+
+func (r *readOnlyOS) Lchown(name string, uid, gid int) error {
+	return erofs("lchown", name)
+}
+
+func (r *readOnlyOS) Link(oldname, newname string) error {
+	return erofsLink("link", oldname, newname)
+}
+
+func (r *readOnlyOS) Mkdir(name string, perm os.FileMode) error {
+	return erofs("mkdir", name)
+}
+
+func (r *readOnlyOS) MkdirAll(path string, perm os.FileMode) error {
+	return erofs("mkdir", path)
+}
+
+func (r *readOnlyOS) Remove(name string) error {
+	return erofs("remove", name)
+}
+
+func (r *readOnlyOS) RemoveAll(path string) error {
+	return erofs("unlinkat", path)
+}
+
+func (r *readOnlyOS) Rename(oldname, newname string) error {
+	return erofsLink("rename", oldname, newname)
+}
+
+func (r *readOnlyOS) Setenv(key, value string) error {
+	return os.NewSyscallError("setenv", syscall.Errno(syscall.EPERM))
+}
+
+func (r *readOnlyOS) Symlink(oldname, newname string) error {
+	return erofsLink("symlink", oldname, newname)
+}
+
+func (r *readOnlyOS) Truncate(name string, size int64) error {
+	return erofs("truncate", name)
+}
+
+func (r *readOnlyOS) Create(name string) (file File, err error) {
+	return nil, erofs("open", name)
+}
+
+func (r *readOnlyOS) NewFile(fd uintptr, name string) File {
+	f := r.OperatingSystem.NewFile(fd, name)
+	if f == nil {
+		return nil
+	}
+	return &readOnlyFile{File: f}
+}
+
+func (r *readOnlyOS) Open(name string) (file File, err error) {
+	f, err := r.OperatingSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f}, nil
+}
+
+func (r *readOnlyOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	if flag&writeFlags != 0 {
+		return nil, erofs("open", name)
+	}
+
+	f, err := r.OperatingSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f}, nil
+}
+
+// readOnlyFile is a File that can be read from but not modified.
+type readOnlyFile struct {
+	File
+}
+
+func (f *readOnlyFile) Chmod(mode os.FileMode) error {
+	return erofs("chmod", f.Name())
+}
+
+func (f *readOnlyFile) Chown(uid, gid int) error {
+	return erofs("chown", f.Name())
+}
+
+func (f *readOnlyFile) Truncate(size int64) error {
+	return erofs("truncate", f.Name())
+}
+
+func (f *readOnlyFile) Write(b []byte) (n int, err error) {
+	return 0, erofs("write", f.Name())
+}
+
+func (f *readOnlyFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return 0, erofs("write", f.Name())
+}
+
+func (f *readOnlyFile) WriteString(s string) (ret int, err error) {
+	return 0, erofs("write", f.Name())
+}
+
+// Watch watches path on the wrapped OperatingSystem, see Watchable.
+func (r *readOnlyOS) Watch(path string, recursive bool) (Watcher, error) {
+	return Watch(r.OperatingSystem, path, recursive)
+}
+
+// random draws from the wrapped OperatingSystem, see randomSource.
+func (r *readOnlyOS) random() uint32 {
+	return randomOf(r.OperatingSystem)
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func Test_ReadOnly_Reads(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "conf")
+	if err := os.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ReadOnly(DefaultOS())
+	if _, err := r.Stat(name); err != nil {
+		t.Errorf("expected Stat to pass through, err: %v", err)
+	}
+
+	f, err := r.OpenFile(name, O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("expected read-only OpenFile to pass through, err: %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 5)
+	if n, err := f.Read(b); err != nil || string(b[:n]) != "hello" {
+		t.Errorf("expected to read \"hello\", got %q, err: %v", b[:n], err)
+	}
+}
+
+func Test_ReadOnly_Mutations(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "conf")
+	if err := os.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ReadOnly(DefaultOS())
+	errs := map[string]error{
+		"Chmod":    r.Chmod(name, 0600),
+		"Chown":    r.Chown(name, 0, 0),
+		"Mkdir":    r.Mkdir(filepath.Join(dir, "sub"), 0755),
+		"MkdirAll": r.MkdirAll(filepath.Join(dir, "a", "b"), 0755),
+		"Remove":   r.Remove(name),
+		"Rename":   r.Rename(name, name+".bak"),
+		"Symlink":  r.Symlink(name, name+".lnk"),
+		"Link":     r.Link(name, name+".lnk"),
+		"Truncate": r.Truncate(name, 0),
+	}
+	_, errs["Create"] = r.Create(filepath.Join(dir, "new"))
+	_, errs["OpenFile"] = r.OpenFile(name, O_WRONLY|O_APPEND, 0)
+
+	for op, err := range errs {
+		if !errors.Is(err, syscall.EROFS) {
+			t.Errorf("expected %s to fail with EROFS, err: %v", op, err)
+		}
+	}
+
+	if err := r.Setenv("FS_READ_ONLY", "1"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected Setenv to fail with EPERM, err: %v", err)
+	}
+
+	if b, err := os.ReadFile(name); err != nil || string(b) != "hello" {
+		t.Errorf("file was modified: %q, err: %v", b, err)
+	}
+}
+
+func Test_ReadOnly_File(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "conf")
+	if err := os.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ReadOnly(DefaultOS()).Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("x")); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("expected Write to fail with EROFS, err: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 1); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("expected WriteAt to fail with EROFS, err: %v", err)
+	}
+	if err := f.Truncate(0); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("expected Truncate to fail with EROFS, err: %v", err)
+	}
+	if err := f.Chmod(0600); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("expected Chmod to fail with EROFS, err: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Call is a single OperatingSystem or File method call seen by a recording
+// OperatingSystem.
+type Call struct {
+	// Seq orders the calls made through the same recording
+	// OperatingSystem, starting at 1.
+	Seq int64
+
+	// Op is the name of the method, methods of File are prefixed with
+	// "File." (e.g. "Open", "File.Write").
+	Op string
+
+	// Handle identifies the File a File method was called on (as found in
+	// the HandleRef the File was returned in), it's 0 for OperatingSystem
+	// methods.
+	Handle int64
+
+	// Path is the file the call is about: the name passed to
+	// OperatingSystem methods taking one (the old name for Rename, the new
+	// one for Link and Symlink), the name of the File for File methods.
+	Path string
+
+	// Args holds the arguments of the call. The buffers passed to Read and
+	// Write style calls are recorded by their length, their contents end up
+	// in Data.
+	Args []interface{}
+
+	// Results holds the results of the call except for the trailing error.
+	// Files are recorded as HandleRefs.
+	Results []interface{}
+	Err     error
+
+	// Data holds the bytes read or written by Read, ReadAt, Write, WriteAt
+	// and WriteString.
+	Data []byte
+
+	Start     time.Time
+	Duration  time.Duration
+	Goroutine int64
+}
+
+func (c Call) String() string {
+	call := formatCall(c.Op, c.Args)
+	if c.Handle != 0 {
+		call = fmt.Sprintf("#%d %s", c.Handle, call)
+	}
+	if c.Err != nil {
+		return fmt.Sprintf("%d: %s = %v", c.Seq, call, c.Err)
+	}
+	return fmt.Sprintf("%d: %s", c.Seq, call)
+}
+
+func formatCall(op string, args []interface{}) string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = fmt.Sprintf("%#v", arg)
+	}
+	return op + "(" + strings.Join(strs, ", ") + ")"
+}
+
+// HandleRef is how Files returned by a call are recorded.
+type HandleRef struct {
+	ID   int64
+	Name string
+}
+
+// CallSink receives the calls made through a recording OperatingSystem, once
+// they have completed. Record may be called from several goroutines at once.
+type CallSink interface {
+	Record(c Call)
+}
+
+type recorder struct {
+	sink CallSink
+}
+
+func (r *recorder) before(c *Call) {}
+
+func (r *recorder) after(c *Call) {
+	r.sink.Record(*c)
+}
+
+// Record wraps o so that every call made on it, or on any File it returns,
+// is reported to sink along with its arguments, results, duration and the
+// goroutine it was made from. IsExist, IsNotExist, IsPathSeparator,
+// IsPermission, SameFile and File.Name aren't: they only look at their
+// arguments or the File, and are called too often for their records to be
+// of use. Neither is Watch, which isn't an OperatingSystem method.
+func Record(o OperatingSystem, sink CallSink) OperatingSystem {
+	return intercept(o, &recorder{sink: sink}, true)
+}
+
+// CallFilter selects calls out of a CallLog.
+type CallFilter func(c Call) bool
+
+// OpIs selects calls to any of the given methods.
+func OpIs(ops ...string) CallFilter {
+	return func(c Call) bool {
+		for _, op := range ops {
+			if c.Op == op {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathIs selects calls about the file at path.
+func PathIs(path string) CallFilter {
+	return func(c Call) bool {
+		return c.Path == path
+	}
+}
+
+// HandleIs selects the calls made on the File with the given handle.
+func HandleIs(handle int64) CallFilter {
+	return func(c Call) bool {
+		return c.Handle == handle
+	}
+}
+
+// After selects the calls that were made after c.
+func After(c Call) CallFilter {
+	return func(other Call) bool {
+		return other.Seq > c.Seq
+	}
+}
+
+// Failed selects the calls that returned an error.
+func Failed() CallFilter {
+	return func(c Call) bool {
+		return c.Err != nil
+	}
+}
+
+// CallLog is a CallSink keeping every call in memory so that it can be
+// queried.
+type CallLog struct {
+	lock  sync.Mutex
+	calls []Call
+}
+
+// NewCallLog returns an empty CallLog.
+func NewCallLog() *CallLog {
+	return &CallLog{}
+}
+
+// Record appends c to the log.
+func (l *CallLog) Record(c Call) {
+	l.lock.Lock()
+	l.calls = append(l.calls, c)
+	l.lock.Unlock()
+}
+
+// Calls returns the calls matching all of the filters, ordered by Seq.
+func (l *CallLog) Calls(filters ...CallFilter) []Call {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var found []Call
+	for _, c := range l.calls {
+		if matches(c, filters) {
+			found = append(found, c)
+		}
+	}
+
+	// calls are recorded as they complete, which may be out of order
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Seq < found[j].Seq
+	})
+	return found
+}
+
+// Count returns the number of calls matching all of the filters.
+func (l *CallLog) Count(filters ...CallFilter) int {
+	return len(l.Calls(filters...))
+}
+
+// First returns the earliest call matching all of the filters.
+func (l *CallLog) First(filters ...CallFilter) (Call, bool) {
+	calls := l.Calls(filters...)
+	if len(calls) == 0 {
+		return Call{}, false
+	}
+	return calls[0], true
+}
+
+// Last returns the latest call matching all of the filters.
+func (l *CallLog) Last(filters ...CallFilter) (Call, bool) {
+	calls := l.Calls(filters...)
+	if len(calls) == 0 {
+		return Call{}, false
+	}
+	return calls[len(calls)-1], true
+}
+
+// Reset forgets every call recorded so far.
+func (l *CallLog) Reset() {
+	l.lock.Lock()
+	l.calls = nil
+	l.lock.Unlock()
+}
+
+// String returns one line per call, which is handy when a test fails.
+func (l *CallLog) String() string {
+	var b strings.Builder
+	for _, c := range l.Calls() {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func matches(c Call, filters []CallFilter) bool {
+	for _, filter := range filters {
+		if !filter(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package fs
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func Test_Record_SyncAfterLastWrite(t *testing.T) {
+	log := NewCallLog()
+	o := Record(FakeOS(), log)
+
+	f, err := o.Create("/tmp/data")
+	if err != nil {
+		t.Fatalf("failed to create file, err: %v", err)
+	}
+	f.Write([]byte("hello "))
+	f.Sync()
+	f.WriteString("world")
+	f.Sync()
+	f.Close()
+
+	write, ok := log.Last(OpIs("File.Write", "File.WriteString"), PathIs("/tmp/data"))
+	if !ok {
+		t.Fatalf("expected writes to be recorded, log:\n%s", log)
+	}
+	if string(write.Data) != "world" {
+		t.Errorf("expected last write to be %q, was %q", "world", write.Data)
+	}
+	if n := log.Count(OpIs("File.Sync"), PathIs("/tmp/data"), After(write)); n != 1 {
+		t.Errorf("expected exactly one Sync after the last write, got %d, log:\n%s", n, log)
+	}
+}
+
+func Test_Record_Calls(t *testing.T) {
+	log := NewCallLog()
+	o := Record(FakeOS(), log)
+
+	o.Setenv("HOME", "/home/me")
+	o.Getenv("HOME")
+	if _, err := o.Open("/missing"); err == nil {
+		t.Fatal("expected Open of a missing file to fail")
+	}
+
+	calls := log.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d, log:\n%s", len(calls), log)
+	}
+	for i, op := range []string{"Setenv", "Getenv", "Open"} {
+		if calls[i].Op != op || calls[i].Seq != int64(i+1) {
+			t.Errorf("expected call %d to be %s, was %s", i+1, op, calls[i])
+		}
+	}
+	if len(calls[1].Results) != 1 || calls[1].Results[0] != "/home/me" {
+		t.Errorf("expected Getenv result to be recorded, was %v", calls[1].Results)
+	}
+	if calls[2].Err == nil || log.Count(Failed()) != 1 {
+		t.Errorf("expected the failed Open to be recorded with its error")
+	}
+	if calls[0].Goroutine == 0 || calls[0].Start.IsZero() {
+		t.Errorf("expected goroutine and start time to be recorded, was %#v", calls[0])
+	}
+}
+
+func Test_Record_Handles(t *testing.T) {
+	log := NewCallLog()
+	o := Record(FakeOS(), log)
+
+	w, _ := o.Create("/a")
+	w.WriteString("abc")
+	w.Close()
+
+	r, err := o.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 8)
+	r.Read(b)
+	if _, err := r.Read(b); err != io.EOF {
+		t.Errorf("expected EOF, err: %v", err)
+	}
+	r.Close()
+
+	open, ok := log.Last(OpIs("Open"))
+	if !ok {
+		t.Fatal("expected Open to be recorded")
+	}
+	ref, ok := open.Results[0].(HandleRef)
+	if !ok || ref.Name != "/a" {
+		t.Fatalf("expected Open to return a HandleRef to /a, was %#v", open.Results)
+	}
+
+	reads := log.Calls(HandleIs(ref.ID), OpIs("File.Read"))
+	if len(reads) != 2 || string(reads[0].Data) != "abc" || reads[1].Err != io.EOF {
+		t.Errorf("expected both reads to be recorded against the handle, log:\n%s", log)
+	}
+	if n := log.Count(HandleIs(ref.ID)); n != 3 {
+		t.Errorf("expected 3 calls on the handle, got %d", n)
+	}
+}
+
+func Test_Record_Concurrent(t *testing.T) {
+	log := NewCallLog()
+	o := Record(FakeOS(), log)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.Stat("/tmp")
+		}()
+	}
+	wg.Wait()
+
+	calls := log.Calls()
+	if len(calls) != 8 {
+		t.Fatalf("expected 8 calls, got %d", len(calls))
+	}
+	for i, c := range calls {
+		if c.Seq != int64(i+1) {
+			t.Errorf("expected calls ordered by Seq, got %d at %d", c.Seq, i)
+		}
+	}
+
+	log.Reset()
+	if log.Count() != 0 {
+		t.Errorf("expected Reset to empty the log")
+	}
+}
@@ -0,0 +1,507 @@
+package fs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrUnexpectedCall is returned by a replaying (or mock) OperatingSystem,
+// and the files it hands out, for calls it wasn't expecting.
+var ErrUnexpectedCall = errors.New("unexpected call")
+
+// TestingT is the part of *testing.T used to report failures.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Cleanup(f func())
+}
+
+// replayOS serves the results of a trace, for as long as the calls made on
+// it match the ones in the trace.
+type replayOS struct {
+	t TestingT
+
+	lock  sync.Mutex
+	calls []Call
+	next  int
+	err   error
+}
+
+// Replay returns an OperatingSystem which, rather than touching any file
+// system, serves the results of calls previously recorded (see Record and
+// ReadTrace). Calls must be made in the same order, on the same files and
+// with the same arguments (and data written) as in calls: the first one
+// that isn't is reported to t, and it and every later call fail with
+// ErrUnexpectedCall. Calls left unmade when the test ends are reported too.
+//
+// Files returned are replayed the same way, their Name is served from the
+// trace. The process is never exited, SameFile compares recorded file infos
+// and Expand doesn't call mapping.
+func Replay(t TestingT, calls []Call) OperatingSystem {
+	r := &replayOS{t: t, calls: calls}
+	t.Cleanup(r.done)
+	return r
+}
+
+func (r *replayOS) done() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err == nil && r.next < len(r.calls) {
+		r.t.Errorf("fs: replay: %d recorded calls were never made, next one is %s",
+			len(r.calls)-r.next, r.calls[r.next])
+	}
+}
+
+// call returns the recorded call matching the one being made, or
+// ErrUnexpectedCall.
+func (r *replayOS) call(handle int64, op, path string, data []byte, args ...interface{}) (Call, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return Call{}, r.err
+	}
+
+	got := Call{Seq: int64(r.next + 1), Op: op, Handle: handle, Path: path, Args: args}
+	if r.next >= len(r.calls) {
+		r.err = fmt.Errorf("%w: got %s after the end of the trace", ErrUnexpectedCall, got)
+		r.t.Errorf("fs: replay: %v", r.err)
+		return Call{}, r.err
+	}
+
+	want := r.calls[r.next]
+	got.Seq = want.Seq
+	if !sameCall(want, got) || (data != nil && string(data) != string(want.Data)) {
+		r.err = fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedCall, want, got)
+		r.t.Errorf("fs: replay: %v", r.err)
+		return Call{}, r.err
+	}
+	r.next++
+	return want, nil
+}
+
+// sameCall compares calls the way they're stored in a trace, so that
+// arguments read back from one compare equal to the live ones.
+func sameCall(a, b Call) bool {
+	if a.Op != b.Op || a.Handle != b.Handle || a.Path != b.Path {
+		return false
+	}
+	aArgs, aErr := encodeValues(a.Args)
+	bArgs, bErr := encodeValues(b.Args)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	aJSON, _ := json.Marshal(aArgs)
+	bJSON, _ := json.Marshal(bArgs)
+	return string(aJSON) == string(bJSON)
+}
+
+// result returns the i-th result of c, or nil.
+func (c Call) result(i int) interface{} {
+	if i < len(c.Results) {
+		return c.Results[i]
+	}
+	return nil
+}
+
+func (r *replayOS) run(op, path string, args ...interface{}) error {
+	c, err := r.call(0, op, path, nil, args...)
+	if err != nil {
+		return err
+	}
+	return c.Err
+}
+
+func (r *replayOS) runInt(op string) int {
+	c, _ := r.call(0, op, "", nil)
+	v, _ := c.result(0).(int)
+	return v
+}
+
+func (r *replayOS) runString(op, path string, args ...interface{}) (string, error) {
+	c, err := r.call(0, op, path, nil, args...)
+	if err != nil {
+		return "", err
+	}
+	v, _ := c.result(0).(string)
+	return v, c.Err
+}
+
+func (r *replayOS) runFileInfo(op, name string) (os.FileInfo, error) {
+	c, err := r.call(0, op, name, nil, name)
+	if err != nil {
+		return nil, err
+	}
+	fi, _ := c.result(0).(os.FileInfo)
+	return fi, c.Err
+}
+
+func (r *replayOS) file(v interface{}) File {
+	ref, ok := v.(HandleRef)
+	if !ok {
+		return nil
+	}
+	return &replayFile{ref: ref, os: r}
+}
+
+func (r *replayOS) runFile(op, name string, args ...interface{}) (File, error) {
+	c, err := r.call(0, op, name, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return r.file(c.result(0)), c.Err
+}
+
+func (r *replayOS) Chdir(dir string) error {
+	return r.run("Chdir", dir, dir)
+}
+
+func (r *replayOS) Chmod(name string, mode os.FileMode) error {
+	return r.run("Chmod", name, name, mode)
+}
+
+func (r *replayOS) Chown(name string, uid, gid int) error {
+	return r.run("Chown", name, name, uid, gid)
+}
+
+func (r *replayOS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return r.run("Chtimes", name, name, atime, mtime)
+}
+
+func (r *replayOS) Clearenv() {
+	r.run("Clearenv", "")
+}
+
+func (r *replayOS) Environ() []string {
+	c, _ := r.call(0, "Environ", "", nil)
+	v, _ := c.result(0).([]string)
+	return v
+}
+
+func (r *replayOS) Exit(code int) {
+	r.run("Exit", "", code)
+}
+
+func (r *replayOS) Expand(s string, mapping func(string) string) string {
+	v, _ := r.runString("Expand", "", s)
+	return v
+}
+
+func (r *replayOS) ExpandEnv(s string) string {
+	v, _ := r.runString("ExpandEnv", "", s)
+	return v
+}
+
+func (r *replayOS) Getegid() int {
+	return r.runInt("Getegid")
+}
+
+func (r *replayOS) Getenv(key string) string {
+	v, _ := r.runString("Getenv", "", key)
+	return v
+}
+
+func (r *replayOS) Geteuid() int {
+	return r.runInt("Geteuid")
+}
+
+func (r *replayOS) Getgid() int {
+	return r.runInt("Getgid")
+}
+
+func (r *replayOS) Getgroups() ([]int, error) {
+	c, err := r.call(0, "Getgroups", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := c.result(0).([]int)
+	return v, c.Err
+}
+
+func (r *replayOS) Getpagesize() int {
+	return r.runInt("Getpagesize")
+}
+
+func (r *replayOS) Getpid() int {
+	return r.runInt("Getpid")
+}
+
+func (r *replayOS) Getppid() int {
+	return r.runInt("Getppid")
+}
+
+func (r *replayOS) Getuid() int {
+	return r.runInt("Getuid")
+}
+
+func (r *replayOS) Getwd() (dir string, err error) {
+	return r.runString("Getwd", "")
+}
+
+func (r *replayOS) Hostname() (name string, err error) {
+	return r.runString("Hostname", "")
+}
+
+func (r *replayOS) IsExist(err error) bool {
+	return os.IsExist(err)
+}
+
+func (r *replayOS) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (r *replayOS) IsPathSeparator(c uint8) bool {
+	return os.IsPathSeparator(c)
+}
+
+func (r *replayOS) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+func (r *replayOS) Lchown(name string, uid, gid int) error {
+	return r.run("Lchown", name, name, uid, gid)
+}
+
+func (r *replayOS) Link(oldname, newname string) error {
+	return r.run("Link", newname, oldname, newname)
+}
+
+func (r *replayOS) Mkdir(name string, perm os.FileMode) error {
+	return r.run("Mkdir", name, name, perm)
+}
+
+func (r *replayOS) MkdirAll(path string, perm os.FileMode) error {
+	return r.run("MkdirAll", path, path, perm)
+}
+
+func (r *replayOS) Readlink(name string) (string, error) {
+	return r.runString("Readlink", name, name)
+}
+
+func (r *replayOS) Remove(name string) error {
+	return r.run("Remove", name, name)
+}
+
+func (r *replayOS) RemoveAll(path string) error {
+	return r.run("RemoveAll", path, path)
+}
+
+func (r *replayOS) Rename(oldname, newname string) error {
+	return r.run("Rename", oldname, oldname, newname)
+}
+
+func (r *replayOS) SameFile(fi1, fi2 os.FileInfo) bool {
+	a, ok1 := fi1.(*traceFileInfo)
+	b, ok2 := fi2.(*traceFileInfo)
+	return ok1 && ok2 && a.N == b.N && a.S == b.S && a.M == b.M && a.T.Equal(b.T)
+}
+
+func (r *replayOS) Setenv(key, value string) error {
+	return r.run("Setenv", "", key, value)
+}
+
+func (r *replayOS) Symlink(oldname, newname string) error {
+	return r.run("Symlink", newname, oldname, newname)
+}
+
+func (r *replayOS) TempDir() string {
+	v, _ := r.runString("TempDir", "")
+	return v
+}
+
+func (r *replayOS) Truncate(name string, size int64) error {
+	return r.run("Truncate", name, name, size)
+}
+
+func (r *replayOS) Create(name string) (file File, err error) {
+	return r.runFile("Create", name, name)
+}
+
+func (r *replayOS) NewFile(fd uintptr, name string) File {
+	file, _ := r.runFile("NewFile", name, fd, name)
+	return file
+}
+
+func (r *replayOS) Open(name string) (file File, err error) {
+	return r.runFile("Open", name, name)
+}
+
+func (r *replayOS) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	return r.runFile("OpenFile", name, name, flag, perm)
+}
+
+func (r *replayOS) Pipe() (rf File, wf File, err error) {
+	c, err := r.call(0, "Pipe", "", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.file(c.result(0)), r.file(c.result(1)), c.Err
+}
+
+func (r *replayOS) Lstat(name string) (fi os.FileInfo, err error) {
+	return r.runFileInfo("Lstat", name)
+}
+
+func (r *replayOS) Stat(name string) (fi os.FileInfo, err error) {
+	return r.runFileInfo("Stat", name)
+}
+
+// replayFile is a File handed out by a replayOS.
+type replayFile struct {
+	ref HandleRef
+	os  *replayOS
+}
+
+func (f *replayFile) call(op string, data []byte, args ...interface{}) (Call, error) {
+	return f.os.call(f.ref.ID, "File."+op, f.ref.Name, data, args...)
+}
+
+func (f *replayFile) run(op string, args ...interface{}) error {
+	c, err := f.call(op, nil, args...)
+	if err != nil {
+		return err
+	}
+	return c.Err
+}
+
+func (f *replayFile) runInt(op string, data []byte, args ...interface{}) (int, error) {
+	c, err := f.call(op, data, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := c.result(0).(int)
+	return n, c.Err
+}
+
+func (f *replayFile) read(op string, b []byte, args ...interface{}) (int, error) {
+	c, err := f.call(op, nil, args...)
+	if err != nil {
+		return 0, err
+	}
+	copy(b, c.Data)
+	n, _ := c.result(0).(int)
+	return n, c.Err
+}
+
+func (f *replayFile) Chdir() error {
+	return f.run("Chdir")
+}
+
+func (f *replayFile) Chmod(mode os.FileMode) error {
+	return f.run("Chmod", mode)
+}
+
+func (f *replayFile) Chown(uid, gid int) error {
+	return f.run("Chown", uid, gid)
+}
+
+func (f *replayFile) Close() error {
+	return f.run("Close")
+}
+
+func (f *replayFile) Fd() uintptr {
+	c, _ := f.call("Fd", nil)
+	fd, _ := c.result(0).(uintptr)
+	return fd
+}
+
+func (f *replayFile) Lock() error {
+	return f.run("Lock")
+}
+
+func (f *replayFile) LockRange(off, n int64, how int) error {
+	return f.run("LockRange", off, n, how)
+}
+
+func (f *replayFile) Name() string {
+	return f.ref.Name
+}
+
+func (f *replayFile) RLock() error {
+	return f.run("RLock")
+}
+
+func (f *replayFile) Read(b []byte) (n int, err error) {
+	return f.read("Read", b, len(b))
+}
+
+func (f *replayFile) ReadAt(b []byte, off int64) (n int, err error) {
+	return f.read("ReadAt", b, len(b), off)
+}
+
+func (f *replayFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	c, err := f.call("Readdir", nil, n)
+	if err != nil {
+		return nil, err
+	}
+	fi, _ = c.result(0).([]os.FileInfo)
+	return fi, c.Err
+}
+
+func (f *replayFile) Readdirnames(n int) (names []string, err error) {
+	c, err := f.call("Readdirnames", nil, n)
+	if err != nil {
+		return nil, err
+	}
+	names, _ = c.result(0).([]string)
+	return names, c.Err
+}
+
+func (f *replayFile) Seek(offset int64, whence int) (ret int64, err error) {
+	c, err := f.call("Seek", nil, offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	ret, _ = c.result(0).(int64)
+	return ret, c.Err
+}
+
+func (f *replayFile) Stat() (fi os.FileInfo, err error) {
+	c, err := f.call("Stat", nil)
+	if err != nil {
+		return nil, err
+	}
+	fi, _ = c.result(0).(os.FileInfo)
+	return fi, c.Err
+}
+
+func (f *replayFile) Sync() (err error) {
+	return f.run("Sync")
+}
+
+func (f *replayFile) Truncate(size int64) error {
+	return f.run("Truncate", size)
+}
+
+func (f *replayFile) TryLock() error {
+	return f.run("TryLock")
+}
+
+func (f *replayFile) Unlock() error {
+	return f.run("Unlock")
+}
+
+func (f *replayFile) Write(b []byte) (n int, err error) {
+	return f.runInt("Write", nonNil(b), len(b))
+}
+
+func (f *replayFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return f.runInt("WriteAt", nonNil(b), len(b), off)
+}
+
+func (f *replayFile) WriteString(s string) (ret int, err error) {
+	return f.runInt("WriteString", nonNil([]byte(s)), len(s))
+}
+
+// nonNil makes sure the data written is compared with the trace, even when
+// there's none.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
@@ -0,0 +1,161 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// fakeT is a TestingT remembering what's reported to it.
+type fakeT struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+func (t *fakeT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+// copyConfig is the code under test: it copies dir/conf to dir/conf.bak.
+func copyConfig(o OperatingSystem, dir string) error {
+	src, err := o.Open(filepath.Join(dir, "conf"))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := o.Create(filepath.Join(dir, "conf.bak"))
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 4)
+	for {
+		n, err := src.Read(b)
+		dst.Write(b[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+func recordTrace(t *testing.T) (string, []Call) {
+	dir := t.TempDir()
+	o := DefaultOS()
+	f, err := o.Create(filepath.Join(dir, "conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("key=value\n")
+	f.Close()
+
+	var trace bytes.Buffer
+	tw := NewTraceWriter(&trace)
+	rec := Record(o, tw)
+	if err := copyConfig(rec, dir); err != nil {
+		t.Fatalf("failed to copy config, err: %v", err)
+	}
+	if _, err := rec.Stat(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected Stat of a missing file to fail")
+	}
+	if err := tw.Err(); err != nil {
+		t.Fatalf("failed to write trace, err: %v", err)
+	}
+
+	calls, err := ReadTrace(&trace)
+	if err != nil {
+		t.Fatalf("failed to read trace, err: %v", err)
+	}
+	return dir, calls
+}
+
+func Test_Trace_RoundTrip(t *testing.T) {
+	_, calls := recordTrace(t)
+	log := NewCallLog()
+	for _, c := range calls {
+		log.Record(c)
+	}
+
+	read, ok := log.First(OpIs("File.Read"))
+	if !ok || string(read.Data) != "key=" {
+		t.Errorf("expected the data read to be in the trace, was %q", read.Data)
+	}
+
+	stat := calls[len(calls)-1]
+	var pathErr *os.PathError
+	if !errors.As(stat.Err, &pathErr) || !errors.Is(stat.Err, syscall.ENOENT) {
+		t.Errorf("expected the Stat error to be read back as an ENOENT PathError, was %#v", stat.Err)
+	}
+
+	eof, ok := log.Last(OpIs("File.Read"))
+	if !ok || eof.Err != io.EOF {
+		t.Errorf("expected io.EOF to be read back as itself, was %#v", eof.Err)
+	}
+}
+
+func Test_Replay(t *testing.T) {
+	dir, calls := recordTrace(t)
+
+	ft := &fakeT{}
+	o := Replay(ft, calls)
+	if err := copyConfig(o, dir); err != nil {
+		t.Errorf("expected replayed copy to work, err: %v", err)
+	}
+	if _, err := o.Stat(filepath.Join(dir, "missing")); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected replayed Stat to fail with ENOENT, err: %v", err)
+	}
+	ft.finish()
+	if len(ft.errors) != 0 {
+		t.Errorf("expected replay to succeed, got: %v", ft.errors)
+	}
+}
+
+func Test_Replay_Deviation(t *testing.T) {
+	dir, calls := recordTrace(t)
+
+	ft := &fakeT{}
+	o := Replay(ft, calls)
+	if _, err := o.Open(filepath.Join(dir, "other")); !errors.Is(err, ErrUnexpectedCall) {
+		t.Errorf("expected ErrUnexpectedCall, err: %v", err)
+	}
+	if _, err := o.Open(filepath.Join(dir, "conf")); !errors.Is(err, ErrUnexpectedCall) {
+		t.Errorf("expected calls after a deviation to fail, err: %v", err)
+	}
+	ft.finish()
+	if len(ft.errors) != 1 {
+		t.Errorf("expected the deviation to be reported once, got: %v", ft.errors)
+	}
+}
+
+func Test_Replay_Unfinished(t *testing.T) {
+	dir, calls := recordTrace(t)
+
+	ft := &fakeT{}
+	o := Replay(ft, calls)
+	copyConfig(o, dir)
+	ft.finish()
+	if len(ft.errors) != 1 {
+		t.Errorf("expected the missing Stat to be reported, got: %v", ft.errors)
+	}
+}
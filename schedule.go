@@ -0,0 +1,200 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// ErrDeadlock is returned by Scheduler.Wait when every goroutine left is
+// waiting for a lock held by another one.
+var ErrDeadlock = errors.New("fs: all scheduled goroutines are blocked")
+
+// Scheduler runs goroutines one at a time, switching between them at each
+// call they make to a FakeOS created WithScheduler, or to one of its open
+// files. Which goroutine runs next is drawn from the seed of the Scheduler,
+// so that the same seed gives the same interleaving of the calls, and
+// different seeds explore others:
+//
+//	for seed := int64(1); seed <= 100; seed++ {
+//		s := fs.NewScheduler(seed)
+//		o := fs.FakeOS(fs.WithScheduler(s))
+//		s.Go(writer(o))
+//		s.Go(writer(o))
+//		if err := s.Wait(); err != nil {
+//			t.Fatalf("seed %d: %v", seed, err)
+//		}
+//		// check what o holds, reporting the seed and s.Trace()
+//	}
+//
+// A goroutine waiting for a file lock lets the others run. The goroutines
+// must not otherwise wait for each other (through channels, mutexes, ...)
+// and, while they run, they must be the only ones using the FakeOS.
+type Scheduler struct {
+	lock    sync.Mutex
+	rand    *rand.Rand
+	tasks   []*task // the goroutines left, in the order they were started
+	current *task
+	lastID  int
+	trace   []string
+	done    chan struct{}
+	err     error
+}
+
+type task struct {
+	id      int
+	run     chan struct{}
+	blocked bool // waiting for a lock
+}
+
+// NewScheduler returns a Scheduler drawing the order of the goroutines from
+// seed.
+func NewScheduler(seed int64) *Scheduler {
+	return &Scheduler{rand: rand.New(rand.NewSource(seed))}
+}
+
+// WithScheduler makes every call to a FakeOS, and to its open files, let s
+// switch to another of its goroutines. Environment and process calls
+// aren't switching points.
+func WithScheduler(s *Scheduler) FakeOption {
+	return func(d *fakeOS) {
+		d.sched = s
+	}
+}
+
+// Go starts fn in a goroutine run by s. It only runs once Wait is called,
+// or when s switches to it if called by one of the goroutines of s.
+func (s *Scheduler) Go(fn func()) {
+	s.lock.Lock()
+	s.lastID++
+	t := &task{id: s.lastID, run: make(chan struct{}, 1)}
+	s.tasks = append(s.tasks, t)
+	s.lock.Unlock()
+
+	go func() {
+		<-t.run
+		defer s.exit(t)
+		fn()
+	}()
+}
+
+// Wait runs the goroutines started with Go until they all return. It
+// returns ErrDeadlock if they all end up waiting for each other, leaving
+// them blocked.
+func (s *Scheduler) Wait() error {
+	s.lock.Lock()
+	if len(s.tasks) == 0 {
+		s.lock.Unlock()
+		return nil
+	}
+	s.done, s.err = make(chan struct{}), nil
+	s.current = s.pick()
+	s.current.run <- struct{}{}
+	done := s.done
+	s.lock.Unlock()
+
+	<-done
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Trace returns the calls made by the goroutines, in the order they were
+// run, as "<goroutine> <call>", goroutines being numbered from 1 in the
+// order they were started.
+func (s *Scheduler) Trace() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.trace...)
+}
+
+// yield is called by the running goroutine before making the call op, it
+// returns once s switches back to it. When blocked, the goroutine waits for
+// a lock and isn't switched back to before one is released.
+func (s *Scheduler) yield(op string, blocked bool) {
+	s.lock.Lock()
+	t := s.current
+	if t == nil {
+		// not running, e.g. setting things up before Wait
+		s.lock.Unlock()
+		return
+	}
+	s.trace = append(s.trace, fmt.Sprintf("%d %s", t.id, op))
+	t.blocked = blocked
+
+	next := s.pick()
+	if next == nil {
+		s.fail()
+		s.lock.Unlock()
+		<-t.run // never
+		return
+	}
+	if next == t {
+		s.lock.Unlock()
+		return
+	}
+	s.current = next
+	next.run <- struct{}{}
+	s.lock.Unlock()
+	<-t.run
+}
+
+// wake lets the goroutines waiting for a lock run again, as one was
+// released.
+func (s *Scheduler) wake() {
+	s.lock.Lock()
+	for _, t := range s.tasks {
+		t.blocked = false
+	}
+	s.lock.Unlock()
+}
+
+func (s *Scheduler) exit(t *task) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.trace = append(s.trace, fmt.Sprintf("%d exit", t.id))
+	for i, other := range s.tasks {
+		if other == t {
+			s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+			break
+		}
+	}
+	if len(s.tasks) == 0 {
+		s.current = nil
+		close(s.done)
+		return
+	}
+
+	next := s.pick()
+	if next == nil {
+		s.fail()
+		return
+	}
+	s.current = next
+	next.run <- struct{}{}
+}
+
+// pick draws the goroutine to run next among those not blocked, it returns
+// nil if there are none. Must be called with s.lock held.
+func (s *Scheduler) pick() *task {
+	var runnable []*task
+	for _, t := range s.tasks {
+		if !t.blocked {
+			runnable = append(runnable, t)
+		}
+	}
+	if len(runnable) == 0 {
+		return nil
+	}
+	return runnable[s.rand.Intn(len(runnable))]
+}
+
+// fail stops s as its goroutines are deadlocked. Must be called with s.lock
+// held.
+func (s *Scheduler) fail() {
+	s.err = ErrDeadlock
+	s.current = nil
+	s.tasks = nil
+	close(s.done)
+}
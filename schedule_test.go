@@ -0,0 +1,138 @@
+package fs
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// increment adds one to the counter in the file at name, without locking
+// unless lock is set.
+func increment(o OperatingSystem, name string, lock bool) func() {
+	return func() {
+		f, err := o.OpenFile(name, O_RDWR, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		if lock {
+			f.Lock()
+			defer f.Unlock()
+		}
+
+		b := make([]byte, 16)
+		n, _ := f.ReadAt(b, 0)
+		count, _ := strconv.Atoi(string(b[:n]))
+		f.Truncate(0)
+		f.WriteAt([]byte(strconv.Itoa(count+1)), 0)
+	}
+}
+
+// runCounter has three goroutines increment a counter under s, and returns
+// the final count.
+func runCounter(t *testing.T, s *Scheduler, lock bool) int {
+	t.Helper()
+	o := FakeOS(WithScheduler(s))
+	if err := WriteFile(o, "/counter", []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Go(increment(o, "/counter", lock))
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ReadFile(o, "/counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _ := strconv.Atoi(string(data))
+	return count
+}
+
+func Test_Scheduler_Deterministic(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		s1, s2 := NewScheduler(seed), NewScheduler(seed)
+		count1, count2 := runCounter(t, s1, false), runCounter(t, s2, false)
+		if count1 != count2 || !reflect.DeepEqual(s1.Trace(), s2.Trace()) {
+			t.Errorf("seed %d: two runs differ, counted %d and %d", seed, count1, count2)
+		}
+	}
+}
+
+func Test_Scheduler_Explore(t *testing.T) {
+	var lost, kept []int64
+	for seed := int64(1); seed <= 50; seed++ {
+		if runCounter(t, NewScheduler(seed), false) == 3 {
+			kept = append(kept, seed)
+		} else {
+			lost = append(lost, seed)
+		}
+	}
+	if len(lost) == 0 || len(kept) == 0 {
+		t.Fatalf("updates were lost with seeds %v, kept with seeds %v", lost, kept)
+	}
+
+	// a failing seed fails again
+	if count := runCounter(t, NewScheduler(lost[0]), false); count == 3 {
+		t.Errorf("replaying seed %d didn't lose an update", lost[0])
+	}
+
+	// and locking fixes it for every interleaving
+	for seed := int64(1); seed <= 50; seed++ {
+		if count := runCounter(t, NewScheduler(seed), true); count != 3 {
+			t.Errorf("seed %d: counted %d while locking", seed, count)
+		}
+	}
+}
+
+func Test_Scheduler_Deadlock(t *testing.T) {
+	lockBoth := func(o OperatingSystem, first, second string) func() {
+		return func() {
+			a, _ := o.Open(first)
+			b, _ := o.Open(second)
+			a.Lock()
+			b.Lock()
+			b.Close()
+			a.Close()
+		}
+	}
+
+	deadlocked := 0
+	for seed := int64(1); seed <= 20; seed++ {
+		s := NewScheduler(seed)
+		o := FakeOS(WithScheduler(s))
+		WriteFile(o, "/a", nil, 0644)
+		WriteFile(o, "/b", nil, 0644)
+		s.Go(lockBoth(o, "/a", "/b"))
+		s.Go(lockBoth(o, "/b", "/a"))
+
+		switch err := s.Wait(); {
+		case errors.Is(err, ErrDeadlock):
+			deadlocked++
+		case err != nil:
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+	}
+	if deadlocked == 0 || deadlocked == 20 {
+		t.Errorf("%d runs out of 20 deadlocked", deadlocked)
+	}
+}
+
+func Test_Scheduler_NotRunning(t *testing.T) {
+	s := NewScheduler(1)
+	o := FakeOS(WithScheduler(s))
+
+	// calls made outside of the goroutines of s go through
+	if err := WriteFile(o, "/f", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Errorf("waiting for nothing: %v", err)
+	}
+	if trace := s.Trace(); len(trace) != 0 {
+		t.Errorf("traced %v", trace)
+	}
+}
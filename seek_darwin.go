@@ -0,0 +1,8 @@
+package fs
+
+// Seek whence values finding the data and the holes of sparse files,
+// see lseek(2). Not all systems support them.
+const (
+	SEEK_DATA int = 4 // seek to the next data at or after the offset
+	SEEK_HOLE int = 3 // seek to the next hole at or after the offset
+)
@@ -0,0 +1,9 @@
+package fs
+
+// fileStat is the system specific information of a file put to use, see
+// sysStat.
+type fileStat struct {
+	dev, ino, nlink uint64
+	uid, gid        int
+	blocks          int64 // of 512 bytes
+}
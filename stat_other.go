@@ -0,0 +1,18 @@
+//go:build !unix
+
+package fs
+
+import "os"
+
+// sysStat returns the system specific information of fi, which off unix
+// only FakeOS has.
+func sysStat(fi os.FileInfo) (fileStat, bool) {
+	if fi == nil {
+		return fileStat{}, false
+	}
+	st, ok := fi.Sys().(*fileStat)
+	if !ok {
+		return fileStat{}, false
+	}
+	return *st, true
+}
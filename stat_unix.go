@@ -0,0 +1,27 @@
+//go:build unix
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysStat returns the system specific information of fi, if any.
+func sysStat(fi os.FileInfo) (fileStat, bool) {
+	if fi == nil {
+		return fileStat{}, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		dev:    uint64(st.Dev),
+		ino:    uint64(st.Ino),
+		nlink:  uint64(st.Nlink),
+		uid:    int(st.Uid),
+		gid:    int(st.Gid),
+		blocks: int64(st.Blocks),
+	}, true
+}
@@ -0,0 +1,135 @@
+package fs
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+)
+
+// ExtractTar extracts the tar archive read from r to the directory dest
+// on o, creating it if needed. Modes, modification times, symbolic and
+// hard links are restored, and so is ownership where o lets us. A
+// compressed archive is recognized through the registered Codecs, see
+// RegisterCodec and WithCodec.
+//
+// Entries escaping dest fail with ErrInsecurePath unless AllowUnsafePaths
+// is given, and so do the entries that are neither regular files,
+// directories nor links with ENOTSUP. Headers holding metadata, as the
+// global one git archive writes, are skipped.
+func ExtractTar(o OperatingSystem, r io.Reader, dest string, opts ...ArchiveOption) error {
+	c := newArchiveConfig(opts)
+	br := bufio.NewReader(r)
+	codec := c.codec
+	if detected, ok := detectCodec(br); ok && codec == nil {
+		codec = &detected
+	}
+	r = br
+	if codec != nil {
+		zr, err := codec.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	e := &extractor{o: o, dest: dest, unsafe: c.unsafe}
+	if err := o.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := fromUnixMode(uint32(h.Mode & 07777))
+		switch h.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			// metadata, as the commit id git archive starts with, rather
+			// than entries
+		case tar.TypeDir:
+			err = e.dir(h.Name, mode, h.ModTime, h.Uid, h.Gid)
+		case tar.TypeReg:
+			err = e.file(h.Name, mode, h.ModTime, h.Uid, h.Gid, tr)
+		case tar.TypeSymlink:
+			err = e.symlink(h.Name, h.Linkname, h.Uid, h.Gid)
+		case tar.TypeLink:
+			err = e.link(h.Name, h.Linkname)
+		default:
+			err = unsupported(h.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+// WriteTar writes the tree rooted at root on o to w as a tar archive, in
+// lexical order, names being relative to root. The files sharing their
+// contents are written as hard links. The archive is compressed when
+// WithCodec is given.
+func WriteTar(o OperatingSystem, root string, w io.Writer, opts ...ArchiveOption) (err error) {
+	c := newArchiveConfig(opts)
+	if c.codec != nil {
+		zw, err := c.codec.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err1 := zw.Close(); err == nil {
+				err = err1
+			}
+		}()
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+	err = walkArchive(o, root, true, func(e archiveEntry) error {
+		uid, gid := owner(e.fi)
+		h := &tar.Header{
+			Name:    e.name,
+			Mode:    int64(toUnixMode(e.fi.Mode())),
+			Uid:     uid,
+			Gid:     gid,
+			ModTime: e.fi.ModTime(),
+		}
+		if uid < 0 {
+			h.Uid, h.Gid = 0, 0
+		}
+
+		switch {
+		case e.fi.IsDir():
+			h.Typeflag = tar.TypeDir
+		case e.hard:
+			h.Typeflag, h.Linkname = tar.TypeLink, e.link
+		case e.link != "":
+			h.Typeflag, h.Linkname = tar.TypeSymlink, e.link
+		default:
+			h.Typeflag, h.Size = tar.TypeReg, e.fi.Size()
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		f, err := o.Open(e.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,127 @@
+package fs
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errPatternHasSeparator = errors.New("pattern contains path separator")
+
+// randomSource is implemented by the OperatingSystems drawing temporary
+// names from their own random source, as FakeOS does (see WithSeed). The
+// wrappers draw from what they wrap, so that the temporary names of a
+// seeded FakeOS stay the same through them.
+type randomSource interface {
+	random() uint32
+}
+
+func (d *fakeOS) random() uint32 {
+	d.randLock.Lock()
+	r := d.rand.Uint32()
+	d.randLock.Unlock()
+	return r
+}
+
+// randomOf draws from the random source of o, if it has one.
+func randomOf(o OperatingSystem) uint32 {
+	if s, ok := o.(randomSource); ok {
+		return s.random()
+	}
+	return rand.Uint32()
+}
+
+// nextRandom returns the random part of a temporary name on o.
+func nextRandom(o OperatingSystem) string {
+	return strconv.FormatUint(uint64(randomOf(o)), 10)
+}
+
+// prefixAndSuffix splits pattern at its last "*", the part replaced by the
+// random string.
+func prefixAndSuffix(pattern string) (prefix, suffix string, err error) {
+	for i := 0; i < len(pattern); i++ {
+		if os.IsPathSeparator(pattern[i]) {
+			return "", "", errPatternHasSeparator
+		}
+	}
+	if pos := strings.LastIndexByte(pattern, '*'); pos != -1 {
+		prefix, suffix = pattern[:pos], pattern[pos+1:]
+	} else {
+		prefix = pattern
+	}
+	return prefix, suffix, nil
+}
+
+// tempName returns the path of a temporary file made of dir, prefix, the
+// random string and suffix, dir defaulting to o.TempDir().
+func tempName(o OperatingSystem, dir, prefix, suffix string) string {
+	if dir == "" {
+		dir = o.TempDir()
+	}
+	if len(dir) > 0 && os.IsPathSeparator(dir[len(dir)-1]) {
+		return dir + prefix + nextRandom(o) + suffix
+	}
+	return dir + string(PathSeparator) + prefix + nextRandom(o) + suffix
+}
+
+// CreateTemp creates a new temporary file in the directory dir on o, as
+// os.CreateTemp does: the file is named after pattern, its last "*" being
+// replaced by a random string, and opened for reading and writing. Names
+// already taken are retried with another random string. The random
+// strings of a FakeOS, wrapped or not, come from its seeded source, see
+// WithSeed.
+func CreateTemp(o OperatingSystem, dir, pattern string) (File, error) {
+	prefix, suffix, err := prefixAndSuffix(pattern)
+	if err != nil {
+		return nil, &os.PathError{Op: "createtemp", Path: pattern, Err: err}
+	}
+
+	try := 0
+	for {
+		name := tempName(o, dir, prefix, suffix)
+		f, err := o.OpenFile(name, O_RDWR|O_CREATE|O_EXCL, 0600)
+		if o.IsExist(err) {
+			if try++; try < 10000 {
+				continue
+			}
+			return nil, &os.PathError{
+				Op:   "createtemp",
+				Path: prefix + "*" + suffix,
+				Err:  os.ErrExist,
+			}
+		}
+		return f, err
+	}
+}
+
+// MkdirTemp creates a new temporary directory in the directory dir on o
+// and returns its path, as os.MkdirTemp does. It's named after pattern as
+// with CreateTemp.
+func MkdirTemp(o OperatingSystem, dir, pattern string) (string, error) {
+	prefix, suffix, err := prefixAndSuffix(pattern)
+	if err != nil {
+		return "", &os.PathError{Op: "mkdirtemp", Path: pattern, Err: err}
+	}
+
+	try := 0
+	for {
+		name := tempName(o, dir, prefix, suffix)
+		err := o.Mkdir(name, 0700)
+		if err == nil {
+			return name, nil
+		}
+		if o.IsExist(err) {
+			if try++; try < 10000 {
+				continue
+			}
+			return "", &os.PathError{
+				Op:   "mkdirtemp",
+				Path: dir + string(PathSeparator) + prefix + "*" + suffix,
+				Err:  os.ErrExist,
+			}
+		}
+		return "", err
+	}
+}
@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_CreateTemp(t *testing.T) {
+	o := FakeOS()
+	f, err := CreateTemp(o, "", "app-*.log")
+	if err != nil {
+		t.Fatalf("failed to create, err: %v", err)
+	}
+	name := f.Name()
+	if dir, base := filepath.Split(name); dir != "/tmp/" || !strings.HasPrefix(base, "app-") || !strings.HasSuffix(base, ".log") {
+		t.Errorf("unexpected name %q", name)
+	}
+	if _, err := f.WriteString("started"); err != nil {
+		t.Errorf("expected the file to be writable, err: %v", err)
+	}
+	if fi, _ := o.Stat(name); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", fi.Mode())
+	}
+
+	// the same seed gives the same names, taken ones are skipped
+	other := FakeOS()
+	other.Mkdir("/tmp/"+strings.TrimPrefix(name, "/tmp/"), 0755)
+	f2, err := CreateTemp(other, "/tmp", "app-*.log")
+	if err != nil {
+		t.Fatalf("failed to create, err: %v", err)
+	}
+	if f2.Name() == name {
+		t.Errorf("expected the taken name to be retried")
+	}
+	if f3, _ := CreateTemp(FakeOS(), "/tmp", "app-*.log"); f3.Name() != name {
+		t.Errorf("expected %q with the same seed, got %q", name, f3.Name())
+	}
+	if f4, _ := CreateTemp(FakeOS(WithSeed(42)), "/tmp", "app-*.log"); f4.Name() == name {
+		t.Errorf("expected another name with another seed")
+	}
+
+	if _, err := CreateTemp(o, "", "logs/*"); err == nil {
+		t.Errorf("expected a pattern with a separator to fail")
+	}
+	if _, err := CreateTemp(o, "/missing", "*"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, err: %v", err)
+	}
+}
+
+func Test_CreateTemp_Wrapped(t *testing.T) {
+	f, err := CreateTemp(FakeOS(), "/tmp", "app-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Base(f.Name())
+
+	for name, wrap := range map[string]func(o OperatingSystem) OperatingSystem{
+		"basepath": func(o OperatingSystem) OperatingSystem { return BasePath(o, "/") },
+		"overlay":  func(o OperatingSystem) OperatingSystem { return Overlay(FakeOS(WithSeed(7)), o) },
+		"mount":    func(o OperatingSystem) OperatingSystem { return NewMountTable(o) },
+		"record":   func(o OperatingSystem) OperatingSystem { return Record(o, NewCallLog()) },
+		"instrument": func(o OperatingSystem) OperatingSystem {
+			return Instrument(BasePath(o, "/"), nil)
+		},
+	} {
+		f, err := CreateTemp(wrap(FakeOS()), "/tmp", "app-*.log")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := filepath.Base(f.Name()); got != want {
+			t.Errorf("%s: expected the seeded name %q, got %q", name, want, got)
+		}
+	}
+
+	// nothing can be created through ReadOnly, but names are drawn all the same
+	if got := nextRandom(ReadOnly(FakeOS())); "app-"+got+".log" != want {
+		t.Errorf("readonly: expected the seeded name %q, got %q", want, got)
+	}
+}
+
+func Test_MkdirTemp(t *testing.T) {
+	for name, o := range map[string]OperatingSystem{
+		"fake":    FakeOS(),
+		"default": DefaultOS(),
+	} {
+		dir := ""
+		if name == "default" {
+			dir = t.TempDir()
+		}
+
+		tmp, err := MkdirTemp(o, dir, "build")
+		if err != nil {
+			t.Fatalf("%s: failed to create, err: %v", name, err)
+		}
+		fi, err := o.Stat(tmp)
+		if err != nil || !fi.IsDir() || fi.Mode().Perm() != 0700 {
+			t.Errorf("%s: expected a 0700 directory at %q, err: %v", name, tmp, err)
+		}
+		if !strings.HasPrefix(filepath.Base(tmp), "build") {
+			t.Errorf("%s: expected %q to start with the pattern", name, tmp)
+		}
+
+		f, err := CreateTemp(o, tmp, "*.o")
+		if err != nil {
+			t.Fatalf("%s: failed to create, err: %v", name, err)
+		}
+		f.Close()
+		if filepath.Dir(f.Name()) != tmp {
+			t.Errorf("%s: expected %q to be in %q", name, f.Name(), tmp)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A trace is a list of Calls stored as JSON, one call per line, so that
+// calls recorded during a real run can be replayed later on.
+
+type traceCall struct {
+	Seq       int64         `json:"seq"`
+	Op        string        `json:"op"`
+	Handle    int64         `json:"handle,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	Args      []traceValue  `json:"args,omitempty"`
+	Results   []traceValue  `json:"results,omitempty"`
+	Err       *traceError   `json:"err,omitempty"`
+	Data      []byte        `json:"data,omitempty"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+	Goroutine int64         `json:"goroutine,omitempty"`
+}
+
+// traceValue holds one argument or result, only the field matching its
+// type is set (none of them for nil).
+type traceValue struct {
+	String    *string          `json:"string,omitempty"`
+	Strings   *[]string        `json:"strings,omitempty"`
+	Int       *int             `json:"int,omitempty"`
+	Ints      *[]int           `json:"ints,omitempty"`
+	Int64     *int64           `json:"int64,omitempty"`
+	Uintptr   *uintptr         `json:"uintptr,omitempty"`
+	Bool      *bool            `json:"bool,omitempty"`
+	Mode      *os.FileMode     `json:"mode,omitempty"`
+	Time      *time.Time       `json:"time,omitempty"`
+	Handle    *HandleRef       `json:"handle,omitempty"`
+	FileInfo  *traceFileInfo   `json:"fileinfo,omitempty"`
+	FileInfos *[]traceFileInfo `json:"fileinfos,omitempty"`
+}
+
+// traceFileInfo is how an os.FileInfo is stored in, and read back from, a
+// trace. Sys always returns nil.
+type traceFileInfo struct {
+	N string      `json:"name"`
+	S int64       `json:"size"`
+	M os.FileMode `json:"mode"`
+	T time.Time   `json:"modTime"`
+}
+
+func (fi *traceFileInfo) Name() string {
+	return fi.N
+}
+
+func (fi *traceFileInfo) Size() int64 {
+	return fi.S
+}
+
+func (fi *traceFileInfo) Mode() os.FileMode {
+	return fi.M
+}
+
+func (fi *traceFileInfo) ModTime() time.Time {
+	return fi.T
+}
+
+func (fi *traceFileInfo) IsDir() bool {
+	return fi.M.IsDir()
+}
+
+func (fi *traceFileInfo) Sys() interface{} {
+	return nil
+}
+
+func newTraceFileInfo(fi os.FileInfo) traceFileInfo {
+	return traceFileInfo{N: fi.Name(), S: fi.Size(), M: fi.Mode(), T: fi.ModTime()}
+}
+
+func encodeValue(v interface{}) (traceValue, error) {
+	var tv traceValue
+	switch v := v.(type) {
+	case nil:
+	case string:
+		tv.String = &v
+	case []string:
+		tv.Strings = &v
+	case int:
+		tv.Int = &v
+	case []int:
+		tv.Ints = &v
+	case int64:
+		tv.Int64 = &v
+	case uintptr:
+		tv.Uintptr = &v
+	case bool:
+		tv.Bool = &v
+	case os.FileMode:
+		tv.Mode = &v
+	case time.Time:
+		tv.Time = &v
+	case HandleRef:
+		tv.Handle = &v
+	case os.FileInfo:
+		fi := newTraceFileInfo(v)
+		tv.FileInfo = &fi
+	case []os.FileInfo:
+		fis := make([]traceFileInfo, len(v))
+		for i, fi := range v {
+			fis[i] = newTraceFileInfo(fi)
+		}
+		tv.FileInfos = &fis
+	default:
+		return tv, fmt.Errorf("fs: can't store a %T in a trace", v)
+	}
+	return tv, nil
+}
+
+func (tv traceValue) value() interface{} {
+	switch {
+	case tv.String != nil:
+		return *tv.String
+	case tv.Strings != nil:
+		return *tv.Strings
+	case tv.Int != nil:
+		return *tv.Int
+	case tv.Ints != nil:
+		return *tv.Ints
+	case tv.Int64 != nil:
+		return *tv.Int64
+	case tv.Uintptr != nil:
+		return *tv.Uintptr
+	case tv.Bool != nil:
+		return *tv.Bool
+	case tv.Mode != nil:
+		return *tv.Mode
+	case tv.Time != nil:
+		return *tv.Time
+	case tv.Handle != nil:
+		return *tv.Handle
+	case tv.FileInfo != nil:
+		return os.FileInfo(tv.FileInfo)
+	case tv.FileInfos != nil:
+		fis := make([]os.FileInfo, len(*tv.FileInfos))
+		for i := range *tv.FileInfos {
+			fis[i] = &(*tv.FileInfos)[i]
+		}
+		return fis
+	}
+	return nil
+}
+
+func encodeValues(vs []interface{}) ([]traceValue, error) {
+	if len(vs) == 0 {
+		return nil, nil
+	}
+	tvs := make([]traceValue, len(vs))
+	for i, v := range vs {
+		tv, err := encodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		tvs[i] = tv
+	}
+	return tvs, nil
+}
+
+func decodeValues(tvs []traceValue) []interface{} {
+	if len(tvs) == 0 {
+		return nil
+	}
+	vs := make([]interface{}, len(tvs))
+	for i, tv := range tvs {
+		vs[i] = tv.value()
+	}
+	return vs
+}
+
+// traceErrors are the sentinel errors that are read back as themselves.
+var traceErrors = map[string]error{
+	"EOF":              io.EOF,
+	"ErrUnexpectedEOF": io.ErrUnexpectedEOF,
+	"ErrClosed":        os.ErrClosed,
+	"ErrNotExist":      os.ErrNotExist,
+	"ErrExist":         os.ErrExist,
+	"ErrPermission":    os.ErrPermission,
+	"ErrInvalid":       os.ErrInvalid,
+	"fs.ErrInvalid":    ErrInvalid,
+	"fs.ErrPermission": ErrPermission,
+	"fs.ErrExist":      ErrExist,
+	"fs.ErrNotExist":   ErrNotExist,
+}
+
+// traceError is how an error is stored in a trace. Path, link and syscall
+// errors, errnos and the errors in traceErrors keep their type, any other
+// error is read back as an error with the same message.
+type traceError struct {
+	Kind     string      `json:"kind"`
+	Op       string      `json:"op,omitempty"`
+	Path     string      `json:"path,omitempty"`
+	Old      string      `json:"old,omitempty"`
+	New      string      `json:"new,omitempty"`
+	Syscall  string      `json:"syscall,omitempty"`
+	Errno    uintptr     `json:"errno,omitempty"`
+	Sentinel string      `json:"sentinel,omitempty"`
+	Msg      string      `json:"msg,omitempty"`
+	Err      *traceError `json:"err,omitempty"`
+}
+
+func encodeError(err error) *traceError {
+	if err == nil {
+		return nil
+	}
+	for name, sentinel := range traceErrors {
+		if err == sentinel {
+			return &traceError{Kind: "sentinel", Sentinel: name}
+		}
+	}
+
+	switch e := err.(type) {
+	case *os.PathError:
+		return &traceError{Kind: "path", Op: e.Op, Path: e.Path, Err: encodeError(e.Err)}
+	case *os.LinkError:
+		return &traceError{Kind: "link", Op: e.Op, Old: e.Old, New: e.New, Err: encodeError(e.Err)}
+	case *os.SyscallError:
+		return &traceError{Kind: "syscall", Syscall: e.Syscall, Err: encodeError(e.Err)}
+	case syscall.Errno:
+		return &traceError{Kind: "errno", Errno: uintptr(e), Msg: e.Error()}
+	}
+	return &traceError{Kind: "text", Msg: err.Error()}
+}
+
+func (te *traceError) error() error {
+	if te == nil {
+		return nil
+	}
+
+	switch te.Kind {
+	case "sentinel":
+		if err, ok := traceErrors[te.Sentinel]; ok {
+			return err
+		}
+	case "path":
+		return &os.PathError{Op: te.Op, Path: te.Path, Err: te.Err.error()}
+	case "link":
+		return &os.LinkError{Op: te.Op, Old: te.Old, New: te.New, Err: te.Err.error()}
+	case "syscall":
+		return &os.SyscallError{Syscall: te.Syscall, Err: te.Err.error()}
+	case "errno":
+		return syscall.Errno(te.Errno)
+	}
+	return errors.New(te.Msg)
+}
+
+func encodeCall(c Call) (*traceCall, error) {
+	args, err := encodeValues(c.Args)
+	if err != nil {
+		return nil, err
+	}
+	results, err := encodeValues(c.Results)
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceCall{
+		Seq:       c.Seq,
+		Op:        c.Op,
+		Handle:    c.Handle,
+		Path:      c.Path,
+		Args:      args,
+		Results:   results,
+		Err:       encodeError(c.Err),
+		Data:      c.Data,
+		Start:     c.Start,
+		Duration:  c.Duration,
+		Goroutine: c.Goroutine,
+	}, nil
+}
+
+func (tc *traceCall) call() Call {
+	return Call{
+		Seq:       tc.Seq,
+		Op:        tc.Op,
+		Handle:    tc.Handle,
+		Path:      tc.Path,
+		Args:      decodeValues(tc.Args),
+		Results:   decodeValues(tc.Results),
+		Err:       tc.Err.error(),
+		Data:      tc.Data,
+		Start:     tc.Start,
+		Duration:  tc.Duration,
+		Goroutine: tc.Goroutine,
+	}
+}
+
+// TraceWriter is a CallSink writing every call it's given to a trace.
+type TraceWriter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+	err  error
+}
+
+// NewTraceWriter returns a TraceWriter writing to w.
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	return &TraceWriter{enc: json.NewEncoder(w)}
+}
+
+// Record writes c to the trace, unless writing a previous call failed.
+func (tw *TraceWriter) Record(c Call) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.err != nil {
+		return
+	}
+	tc, err := encodeCall(c)
+	if err != nil {
+		tw.err = err
+		return
+	}
+	tw.err = tw.enc.Encode(tc)
+}
+
+// Err returns the first error met while writing the trace.
+func (tw *TraceWriter) Err() error {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	return tw.err
+}
+
+// WriteTrace writes calls to w as a trace.
+func WriteTrace(w io.Writer, calls []Call) error {
+	tw := NewTraceWriter(w)
+	for _, c := range calls {
+		tw.Record(c)
+	}
+	return tw.Err()
+}
+
+// ReadTrace reads back the calls of a trace written by WriteTrace or a
+// TraceWriter, ordered by Seq.
+func ReadTrace(r io.Reader) ([]Call, error) {
+	var calls []Call
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var tc traceCall
+		err := dec.Decode(&tc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, tc.call())
+	}
+
+	// calls are written as they complete, which may be out of order
+	sort.SliceStable(calls, func(i, j int) bool {
+		return calls[i].Seq < calls[j].Seq
+	})
+	return calls, nil
+}
@@ -0,0 +1,225 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// A txtar archive is a comment followed by files, each one being a
+// "-- name --" header line followed by its contents. The header may also
+// describe what isn't a regular file, or a mode other than the default:
+//
+//	-- bin/run mode=0755 --
+//	-- cache/ --                 an empty directory
+//	-- secret/ mode=0700 --      a directory with a mode
+//	-- tmp/ mode=1777 --         special bits are as for chmod
+//	-- latest -> v1.2.0 --       a symbolic link
+//
+// As txtar contents are lines, a file that doesn't end with a newline gains
+// one going through an archive.
+
+// FromTxtar returns a FakeOS holding the files of the txtar archive data,
+// names being relative to the root directory. Directories are created as
+// needed with mode 0755, files get mode 0644 unless their header says
+// otherwise.
+func FromTxtar(data []byte) (OperatingSystem, error) {
+	o := FakeOS()
+	var (
+		h       *txtarHeader
+		content []byte
+	)
+	flush := func() error {
+		if h == nil {
+			return nil
+		}
+		return h.create(o, content)
+	}
+
+	for n := 1; len(data) > 0; n++ {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		if name, ok := txtarMarker(line); ok {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			next, err := parseTxtarHeader(name)
+			if err != nil {
+				return nil, fmt.Errorf("fs: txtar line %d: %v", n, err)
+			}
+			h, content = next, nil
+			continue
+		}
+		if h != nil {
+			content = append(content, line...)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// txtarMarker returns the header of line if it's a file marker.
+func txtarMarker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if len(s) < len("-- x --") || !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") {
+		return "", false
+	}
+	return strings.TrimSpace(s[3 : len(s)-3]), true
+}
+
+// txtarHeader is what the header of a file in a txtar archive says.
+type txtarHeader struct {
+	name   string
+	dir    bool
+	target string // for links
+	mode   os.FileMode
+}
+
+func parseTxtarHeader(s string) (*txtarHeader, error) {
+	h := &txtarHeader{mode: 0644}
+	if i := strings.LastIndex(s, " mode="); i >= 0 {
+		mode, err := strconv.ParseUint(s[i+len(" mode="):], 8, 32)
+		if err != nil || mode&^07777 != 0 {
+			return nil, fmt.Errorf("invalid mode in %q", s)
+		}
+		s, h.mode = strings.TrimSpace(s[:i]), fromUnixMode(uint32(mode))
+	} else if strings.HasSuffix(s, "/") {
+		h.mode = 0755
+	}
+	if i := strings.Index(s, " -> "); i >= 0 {
+		s, h.target = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(" -> "):])
+	}
+	if strings.HasSuffix(s, "/") {
+		s, h.dir = strings.TrimSuffix(s, "/"), true
+	}
+
+	if s == "" || (h.dir && h.target != "") {
+		return nil, fmt.Errorf("invalid file %q", s)
+	}
+	h.name = string(filepath.Separator) + filepath.FromSlash(s)
+	return h, nil
+}
+
+func (h *txtarHeader) create(o OperatingSystem, content []byte) error {
+	if h.dir {
+		if err := o.MkdirAll(h.name, 0755); err != nil {
+			return err
+		}
+		return o.Chmod(h.name, h.mode)
+	}
+
+	if err := o.MkdirAll(filepath.Dir(h.name), 0755); err != nil {
+		return err
+	}
+	if h.target != "" {
+		return o.Symlink(h.target, h.name)
+	}
+	if err := WriteFile(o, h.name, content, h.mode); err != nil {
+		return err
+	}
+	return o.Chmod(h.name, h.mode)
+}
+
+// ToTxtar returns the tree rooted at root on o as a txtar archive, names
+// being relative to root, as FromTxtar reads it. Directories are only
+// listed when empty or when their mode isn't 0755, files mentioning their
+// mode when it isn't 0644. What's neither a regular file, a directory nor
+// a link is left out.
+func ToTxtar(o OperatingSystem, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := Walk(o, root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		header := filepath.ToSlash(rel)
+		mode := toUnixMode(fi.Mode())
+
+		switch {
+		case fi.IsDir():
+			infos, err := readDir(o, path)
+			if err != nil {
+				return err
+			}
+			if len(infos) > 0 && mode == 0755 {
+				return nil
+			}
+			header += "/"
+			if mode != 0755 {
+				header += fmt.Sprintf(" mode=%04o", mode)
+			}
+			fmt.Fprintf(&buf, "-- %s --\n", header)
+
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := o.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "-- %s -> %s --\n", header, target)
+
+		case fi.Mode().IsRegular():
+			data, err := ReadFile(o, path)
+			if err != nil {
+				return err
+			}
+			if mode != 0644 {
+				header += fmt.Sprintf(" mode=%04o", mode)
+			}
+			fmt.Fprintf(&buf, "-- %s --\n", header)
+			buf.Write(data)
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toUnixMode returns the permissions and special bits of mode as chmod(2)
+// takes them.
+func toUnixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
+
+// fromUnixMode is the reverse of toUnixMode.
+func fromUnixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m) & os.ModePerm
+	if m&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&syscall.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,107 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const fixture = `A comment, ignored.
+
+-- go.mod --
+module example.com/app
+-- cmd/app/main.go --
+package main
+
+func main() {}
+-- bin/run.sh mode=0755 --
+#!/bin/sh
+exec app "$@"
+-- cache/ --
+-- secret/ mode=0700 --
+-- current -> cmd/app --
+`
+
+func Test_FromTxtar(t *testing.T) {
+	o, err := FromTxtar([]byte(fixture))
+	if err != nil {
+		t.Fatalf("failed to load, err: %v", err)
+	}
+
+	if got, _ := ReadFile(o, "/cmd/app/main.go"); string(got) != "package main\n\nfunc main() {}\n" {
+		t.Errorf("unexpected contents %q", got)
+	}
+	for name, mode := range map[string]os.FileMode{
+		"/go.mod":      0644,
+		"/bin/run.sh":  0755,
+		"/bin":         os.ModeDir | 0755,
+		"/cache":       os.ModeDir | 0755,
+		"/secret":      os.ModeDir | 0700,
+		"/current":     os.ModeSymlink | 0777,
+		"/cmd/app/foo": 0,
+	} {
+		fi, err := o.Lstat(name)
+		if mode == 0 {
+			if err == nil {
+				t.Errorf("expected %s not to exist", name)
+			}
+			continue
+		}
+		if err != nil || fi.Mode() != mode {
+			t.Errorf("expected %s to have mode %v, got %v, err: %v", name, mode, fi, err)
+		}
+	}
+	if target, _ := o.Readlink("/current"); target != "cmd/app" {
+		t.Errorf("expected the link to point to cmd/app, got %q", target)
+	}
+
+	for _, bad := range []string{"-- f mode=999 --\n", "-- d/ -> x --\n", "--  --\n-- / --\n"} {
+		if _, err := FromTxtar([]byte(bad)); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+}
+
+func Test_ToTxtar(t *testing.T) {
+	o, err := FromTxtar([]byte(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(o, "/go.sum", []byte("no newline"), 0644)
+	o.Chmod("/go.sum", 0644)
+
+	got, err := ToTxtar(o, "/")
+	if err != nil {
+		t.Fatalf("failed to dump, err: %v", err)
+	}
+	want := strings.Join([]string{
+		"-- bin/run.sh mode=0755 --",
+		"#!/bin/sh",
+		`exec app "$@"`,
+		"-- cache/ --",
+		"-- cmd/app/main.go --",
+		"package main",
+		"",
+		"func main() {}",
+		"-- current -> cmd/app --",
+		"-- go.mod --",
+		"module example.com/app",
+		"-- go.sum --",
+		"no newline",
+		"-- secret/ mode=0700 --",
+		"-- tmp/ mode=1777 --",
+		"",
+	}, "\n")
+	if string(got) != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// and back
+	back, err := FromTxtar(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := Diff(o, back, "/", "/", IgnoreAttrs(AttrModTime|AttrContent)); err != nil || len(changes) != 0 {
+		t.Errorf("expected the round trip to keep the tree, got %v, err: %v", changes, err)
+	}
+}
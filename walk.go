@@ -0,0 +1,302 @@
+package fs
+
+import (
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var (
+	// SkipDir, returned by a walk function, skips the directory it was
+	// called for, or the rest of the directory holding the file it was
+	// called for.
+	SkipDir = filepath.SkipDir
+
+	// SkipAll, returned by a walk function, stops the walk, which then
+	// returns nil.
+	SkipAll = filepath.SkipAll
+)
+
+// WalkOption configures Walk and WalkDir.
+type WalkOption func(*walker)
+
+// FollowSymlinks makes a walk descend into the directories symbolic links
+// point to, reporting them under the name of the link. A link to one of
+// the directories being walked is reported with ELOOP rather than being
+// followed again.
+func FollowSymlinks() WalkOption {
+	return func(w *walker) {
+		w.follow = true
+	}
+}
+
+type walker struct {
+	o      OperatingSystem
+	follow bool
+
+	// the directories from the root to the one being walked, to detect
+	// cycles when following links
+	parents []os.FileInfo
+}
+
+func newWalker(o OperatingSystem, opts []WalkOption) *walker {
+	w := &walker{o: o}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Walk walks the file tree rooted at root on o as filepath.Walk does,
+// calling fn for each file or directory in lexical order. Symbolic links
+// aren't followed unless FollowSymlinks is given.
+func Walk(o OperatingSystem, root string, fn filepath.WalkFunc, opts ...WalkOption) error {
+	w := newWalker(o, opts)
+	info, err := w.stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, info, fn)
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (w *walker) walk(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	infos, err := w.enter(path, info)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	defer w.leave()
+
+	for _, fi := range infos {
+		name := filepath.Join(path, fi.Name())
+		fi, err := w.resolve(name, fi)
+		if err != nil {
+			if err := fn(name, fi, err); err != nil && err != SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(name, fi, fn); err != nil {
+			if !fi.IsDir() || err != SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkDir walks the file tree rooted at root on o as filepath.WalkDir
+// does, calling fn for each file or directory in lexical order. Symbolic
+// links aren't followed unless FollowSymlinks is given.
+func WalkDir(o OperatingSystem, root string, fn iofs.WalkDirFunc, opts ...WalkOption) error {
+	w := newWalker(o, opts)
+	info, err := w.stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walkDir(root, info, fn)
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (w *walker) walkDir(path string, info os.FileInfo, fn iofs.WalkDirFunc) error {
+	d := iofs.FileInfoToDirEntry(info)
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	infos, err := w.enter(path, info)
+	if err != nil {
+		if err := fn(path, d, err); err != SkipDir {
+			return err
+		}
+		return nil
+	}
+	defer w.leave()
+
+	for _, fi := range infos {
+		name := filepath.Join(path, fi.Name())
+		fi, err := w.resolve(name, fi)
+		if err != nil {
+			if err := fn(name, iofs.FileInfoToDirEntry(fi), err); err != nil {
+				if err == SkipDir {
+					break
+				}
+				return err
+			}
+			continue
+		}
+		if err := w.walkDir(name, fi, fn); err != nil {
+			if err == SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stat returns the FileInfo of the root of a walk.
+func (w *walker) stat(path string) (os.FileInfo, error) {
+	if w.follow {
+		return w.o.Stat(path)
+	}
+	return w.o.Lstat(path)
+}
+
+// resolve returns the FileInfo of what the link at path points to when
+// following links, fi otherwise. A dangling link is reported as itself.
+func (w *walker) resolve(path string, fi os.FileInfo) (os.FileInfo, error) {
+	if !w.follow || fi.Mode()&os.ModeSymlink == 0 {
+		return fi, nil
+	}
+
+	target, err := w.o.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fi, nil
+		}
+		return fi, err
+	}
+	return target, nil
+}
+
+// enter reads the directory at path, sorted by name, and pushes it on the
+// directories being walked. It fails with ELOOP if it's already being
+// walked, leave must be called once done otherwise.
+func (w *walker) enter(path string, info os.FileInfo) ([]os.FileInfo, error) {
+	for _, parent := range w.parents {
+		if w.o.SameFile(parent, info) {
+			return nil, &os.PathError{
+				Op:   "walk",
+				Path: path,
+				Err:  syscall.Errno(syscall.ELOOP),
+			}
+		}
+	}
+
+	infos, err := readDir(w.o, path)
+	if err != nil {
+		return nil, err
+	}
+	w.parents = append(w.parents, info)
+	return infos, nil
+}
+
+func (w *walker) leave() {
+	w.parents = w.parents[:len(w.parents)-1]
+}
+
+// readDir returns the entries of the directory at path sorted by name.
+func readDir(o OperatingSystem, path string) ([]os.FileInfo, error) {
+	f, err := o.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// Glob returns the names of the files on o matching pattern, as
+// filepath.Glob does, or nil if there are none. A "**" element matches any
+// number of directories, including none, without following symbolic
+// links. Matches are sorted directory by directory and I/O errors are
+// ignored, the only possible error is ErrBadPattern.
+func Glob(o OperatingSystem, pattern string) ([]string, error) {
+	elems := strings.Split(pattern, string(filepath.Separator))
+	for _, elem := range elems {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var dir string
+	if filepath.IsAbs(pattern) {
+		dir, elems = string(filepath.Separator), elems[1:]
+	}
+	g := &globber{o: o, seen: map[string]bool{}}
+	g.glob(dir, elems)
+	return g.matches, nil
+}
+
+type globber struct {
+	o       OperatingSystem
+	matches []string
+	seen    map[string]bool // as "**" may match the same file several times
+}
+
+// glob adds the files under dir matching elems, the elements of a pattern.
+func (g *globber) glob(dir string, elems []string) {
+	for len(elems) > 0 && elems[0] == "" {
+		elems = elems[1:] // repeated separators
+	}
+	if len(elems) == 0 {
+		if dir != "" && !g.seen[dir] {
+			g.seen[dir] = true
+			g.matches = append(g.matches, dir)
+		}
+		return
+	}
+
+	elem := elems[0]
+	if elem == "**" {
+		g.glob(dir, elems[1:])
+		for _, fi := range g.readDir(dir) {
+			if fi.IsDir() {
+				g.glob(filepath.Join(dir, fi.Name()), elems)
+			}
+		}
+		return
+	}
+
+	if !hasMeta(elem) {
+		name := filepath.Join(dir, elem)
+		if _, err := g.o.Lstat(name); err == nil {
+			g.glob(name, elems[1:])
+		}
+		return
+	}
+
+	for _, fi := range g.readDir(dir) {
+		if ok, _ := filepath.Match(elem, fi.Name()); ok {
+			g.glob(filepath.Join(dir, fi.Name()), elems[1:])
+		}
+	}
+}
+
+func (g *globber) readDir(dir string) []os.FileInfo {
+	if dir == "" {
+		dir = "."
+	}
+	infos, _ := readDir(g.o, dir)
+	return infos
+}
+
+func hasMeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
@@ -0,0 +1,189 @@
+package fs
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func walkTree(t *testing.T) OperatingSystem {
+	t.Helper()
+
+	o := FakeOS()
+	for _, dir := range []string{"/src/app/internal", "/src/app/vendor/lib", "/src/docs"} {
+		if err := o.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{
+		"/src/app/main.go",
+		"/src/app/main_test.go",
+		"/src/app/internal/util.go",
+		"/src/app/vendor/lib/lib.go",
+		"/src/docs/README.md",
+	} {
+		if _, err := o.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return o
+}
+
+func Test_Walk(t *testing.T) {
+	o := walkTree(t)
+
+	var got []string
+	err := Walk(o, "/src", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == "vendor" {
+			return SkipDir
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk, err: %v", err)
+	}
+
+	want := []string{
+		"/src",
+		"/src/app",
+		"/src/app/internal",
+		"/src/app/internal/util.go",
+		"/src/app/main.go",
+		"/src/app/main_test.go",
+		"/src/docs",
+		"/src/docs/README.md",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	err = Walk(o, "/missing", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected ENOENT, err: %v", err)
+	}
+}
+
+func Test_WalkDir_Skip(t *testing.T) {
+	o := walkTree(t)
+
+	var got []string
+	err := WalkDir(o, "/src", func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, path)
+		switch d.Name() {
+		case "main.go":
+			return SkipDir // the rest of /src/app
+		case "README.md":
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk, err: %v", err)
+	}
+
+	want := []string{
+		"/src",
+		"/src/app",
+		"/src/app/internal",
+		"/src/app/internal/util.go",
+		"/src/app/main.go",
+		"/src/docs",
+		"/src/docs/README.md",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func Test_WalkDir_FollowSymlinks(t *testing.T) {
+	o := walkTree(t)
+	o.Symlink("/src/docs", "/src/app/docs")
+	o.Symlink("/src", "/src/docs/loop")
+	o.Symlink("/missing", "/src/docs/dangling")
+
+	walk := func(opts ...WalkOption) (paths []string, loops []string) {
+		err := WalkDir(o, "/src/docs", func(path string, d iofs.DirEntry, err error) error {
+			if errors.Is(err, syscall.ELOOP) {
+				loops = append(loops, path)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.ToSlash(path))
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatalf("failed to walk, err: %v", err)
+		}
+		return paths, loops
+	}
+
+	paths, _ := walk()
+	want := []string{"/src/docs", "/src/docs/README.md", "/src/docs/dangling", "/src/docs/loop"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	// following, loop leads to /src and both of its links to /src/docs
+	// back to where the walk started
+	paths, loops := walk(FollowSymlinks())
+	if len(paths) != 14 || paths[12] != "/src/docs/loop/app/vendor/lib/lib.go" {
+		t.Errorf("expected the links to be followed, got %v", paths)
+	}
+	if want := []string{"/src/docs/loop/app/docs", "/src/docs/loop/docs"}; !reflect.DeepEqual(loops, want) {
+		t.Errorf("expected loops at %v, got %v", want, loops)
+	}
+}
+
+func Test_Glob(t *testing.T) {
+	o := walkTree(t)
+	o.Chdir("/src/app")
+
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"main.go", "main_test.go"}},
+		{"/src/*/*.md", []string{"/src/docs/README.md"}},
+		{"/src/**/*.go", []string{
+			"/src/app/main.go",
+			"/src/app/main_test.go",
+			"/src/app/internal/util.go",
+			"/src/app/vendor/lib/lib.go",
+		}},
+		{"**/lib", []string{"vendor/lib"}},
+		{"/src/app/**", []string{
+			"/src/app",
+			"/src/app/internal",
+			"/src/app/vendor",
+			"/src/app/vendor/lib",
+		}},
+		{"internal/util.go", []string{"internal/util.go"}},
+		{"*.rs", nil},
+	} {
+		got, err := Glob(o, test.pattern)
+		if err != nil {
+			t.Errorf("failed to glob %q, err: %v", test.pattern, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %v, got %v", test.pattern, test.want, got)
+		}
+	}
+
+	if _, err := Glob(o, "[-]"); err != filepath.ErrBadPattern {
+		t.Errorf("expected ErrBadPattern, err: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// EventOp describes what happened to a file, an Event may combine several.
+type EventOp uint32
+
+const (
+	EventCreate EventOp = 1 << iota
+	EventWrite
+	EventRemove
+	EventRename
+	EventChmod
+)
+
+func (op EventOp) String() string {
+	var ops []string
+	for _, o := range []struct {
+		op   EventOp
+		name string
+	}{
+		{EventCreate, "CREATE"},
+		{EventWrite, "WRITE"},
+		{EventRemove, "REMOVE"},
+		{EventRename, "RENAME"},
+		{EventChmod, "CHMOD"},
+	} {
+		if op&o.op != 0 {
+			ops = append(ops, o.name)
+		}
+	}
+	return strings.Join(ops, "|")
+}
+
+// Event is a change made to the file at Name. A Rename is reported as a
+// Rename of the old name followed by a Create of the new one.
+type Event struct {
+	Name string
+	Op   EventOp
+}
+
+func (e Event) String() string {
+	return e.Op.String() + " " + e.Name
+}
+
+// Watcher delivers the changes made to a file, or to the files of a
+// directory.
+type Watcher interface {
+	// Events delivers the changes, in the order they were made. The channel
+	// is closed once the Watcher is.
+	Events() <-chan Event
+
+	// Errors delivers the errors met while watching, e.g. when changes were
+	// lost. The channel is closed once the Watcher is.
+	Errors() <-chan error
+
+	// Close stops watching.
+	Close() error
+}
+
+// Watchable is implemented by the OperatingSystems able to report changes,
+// as FakeOS and, on Linux, DefaultOS.
+type Watchable interface {
+	// Watch watches the file or directory at path. Watching a directory
+	// reports the changes made to it and to the files in it, or in any of
+	// its subdirectories if recursive.
+	Watch(path string, recursive bool) (Watcher, error)
+}
+
+// Watch watches path on o, see Watchable. It fails with ENOTSUP if o
+// can't report changes.
+func Watch(o OperatingSystem, path string, recursive bool) (Watcher, error) {
+	if w, ok := o.(Watchable); ok {
+		return w.Watch(path, recursive)
+	}
+	return nil, &os.PathError{
+		Op:   "watch",
+		Path: path,
+		Err:  syscall.Errno(syscall.ENOTSUP),
+	}
+}
+
+// watcher is a Watcher queueing what it's told about, so that whoever
+// reports changes never waits on the receiving end.
+type watcher struct {
+	events chan Event
+	errors chan error
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []interface{} // Events and errors
+	closed  bool
+	done    chan struct{}
+	onClose func() error
+}
+
+func newWatcher(onClose func() error) *watcher {
+	w := &watcher{
+		events:  make(chan Event),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+	w.cond = sync.NewCond(&w.lock)
+	go w.pump()
+	return w
+}
+
+func (w *watcher) Events() <-chan Event {
+	return w.events
+}
+
+func (w *watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// push queues an Event or an error.
+func (w *watcher) push(v interface{}) {
+	w.lock.Lock()
+	if !w.closed {
+		w.queue = append(w.queue, v)
+		w.cond.Signal()
+	}
+	w.lock.Unlock()
+}
+
+func (w *watcher) pump() {
+	defer close(w.errors)
+	defer close(w.events)
+
+	for {
+		w.lock.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.lock.Unlock()
+			return
+		}
+		v := w.queue[0]
+		w.queue = w.queue[1:]
+		w.lock.Unlock()
+
+		switch v := v.(type) {
+		case Event:
+			select {
+			case w.events <- v:
+			case <-w.done:
+				return
+			}
+		case error:
+			select {
+			case w.errors <- v:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *watcher) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.queue = nil
+	close(w.done)
+	w.cond.Signal()
+	w.lock.Unlock()
+
+	if w.onClose != nil {
+		return w.onClose()
+	}
+	return nil
+}
+
+// watchList is the set of watches of an OperatingSystem reporting its own
+// changes, as FakeOS and OverlayOS.
+type watchList struct {
+	lock    sync.Mutex
+	watches []*pathWatch
+}
+
+// pathWatch is a Watcher in a watchList.
+type pathWatch struct {
+	*watcher
+	name      string // as given to Watch
+	path      string // clean and absolute
+	recursive bool
+}
+
+// add watches the file or directory at path, reporting it as name.
+func (l *watchList) add(name, path string, recursive bool) Watcher {
+	w := &pathWatch{name: name, path: path, recursive: recursive}
+	w.watcher = newWatcher(func() error {
+		l.remove(w)
+		return nil
+	})
+	l.lock.Lock()
+	l.watches = append(l.watches, w)
+	l.lock.Unlock()
+	return w
+}
+
+func (l *watchList) remove(w *pathWatch) {
+	l.lock.Lock()
+	for i, other := range l.watches {
+		if other == w {
+			l.watches = append(l.watches[:i], l.watches[i+1:]...)
+			break
+		}
+	}
+	l.lock.Unlock()
+}
+
+// notify tells the watches concerned that op happened to the file at path.
+func (l *watchList) notify(path string, op EventOp) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, w := range l.watches {
+		if path != w.path && filepath.Dir(path) != w.path && !(w.recursive && under(path, w.path)) {
+			continue
+		}
+
+		name := w.name
+		if path != w.path {
+			name = filepath.Join(w.name, strings.TrimPrefix(path, w.path))
+		}
+		w.push(Event{Name: name, Op: op})
+	}
+}
+
+// empty reports whether nothing is being watched, sparing the work of
+// finding out what to notify.
+func (l *watchList) empty() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.watches) == 0
+}
+
+// watchSource is a Watcher relayed by relayWatch, the files it reports
+// being renamed through name. Those name doesn't accept are dropped.
+type watchSource struct {
+	Watcher
+	name func(string) (string, bool)
+}
+
+// relayWatch returns a Watcher relaying the Events and errors of sources,
+// closing it closes them all. Events from different sources may be
+// delivered out of order.
+func relayWatch(sources ...watchSource) Watcher {
+	w := newWatcher(func() error {
+		var first error
+		for _, s := range sources {
+			if err := s.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+	for _, s := range sources {
+		go w.relay(s)
+	}
+	return w
+}
+
+// relay pushes what s delivers until it's closed.
+func (w *watcher) relay(s watchSource) {
+	events, errors := s.Events(), s.Errors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if e.Name, ok = s.name(e.Name); ok {
+				w.push(e)
+			}
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			w.push(err)
+		}
+	}
+}
+
+// rebase returns the function renaming the files under from as being
+// under to, rejecting the others.
+func rebase(from, to string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch {
+		case name == from:
+			return to, true
+		case !under(name, from):
+			return "", false
+		}
+		return filepath.Join(to, strings.TrimPrefix(name, from)), true
+	}
+}
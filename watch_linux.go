@@ -0,0 +1,164 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVE_SELF | syscall.IN_ATTRIB
+
+// inotifyWatch is a Watcher built on inotify.
+type inotifyWatch struct {
+	*watcher
+	fd        int
+	f         *os.File
+	recursive bool
+	root      int32 // watch descriptor of the path given to Watch
+
+	lock  sync.Mutex
+	paths map[int32]string // by watch descriptor
+}
+
+// Watch reports the changes made to the file or directory at path through
+// inotify, see Watchable. Subdirectories are watched as they're created
+// when recursive, changes made in a subdirectory before it's watched are
+// missed.
+func (d *defaultOS) Watch(path string, recursive bool) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	// a non-blocking file goes through the runtime poller, so that Close
+	// interrupts a pending Read
+	w := &inotifyWatch{
+		fd:        fd,
+		f:         os.NewFile(uintptr(fd), "inotify"),
+		recursive: recursive,
+		paths:     map[int32]string{},
+	}
+	if w.root, err = w.add(path); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	if recursive {
+		if err := w.addTree(path); err != nil {
+			w.f.Close()
+			return nil, err
+		}
+	}
+
+	w.watcher = newWatcher(w.f.Close)
+	go w.read()
+	return w, nil
+}
+
+// add watches path, w.f.Fd isn't used as it would make w.f blocking.
+func (w *inotifyWatch) add(path string) (int32, error) {
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		return 0, &os.PathError{Op: "watch", Path: path, Err: err}
+	}
+
+	w.lock.Lock()
+	w.paths[int32(wd)] = path
+	w.lock.Unlock()
+	return int32(wd), nil
+}
+
+// addTree watches the subdirectories of the directory at path.
+func (w *inotifyWatch) addTree(path string) error {
+	return filepath.Walk(path, func(sub string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if sub == path || !fi.IsDir() {
+			return nil
+		}
+		_, err = w.add(sub)
+		return err
+	})
+}
+
+func (w *inotifyWatch) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.push(err)
+			}
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+			off += syscall.SizeofInotifyEvent + int(raw.Len)
+			w.handle(raw, string(bytes.TrimRight(name, "\x00")))
+		}
+	}
+}
+
+func (w *inotifyWatch) handle(raw *syscall.InotifyEvent, name string) {
+	if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.push(errors.New("fs: inotify queue overflow, changes were lost"))
+		return
+	}
+
+	w.lock.Lock()
+	path, ok := w.paths[raw.Wd]
+	if raw.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, raw.Wd)
+	}
+	w.lock.Unlock()
+	if !ok {
+		return
+	}
+	if name != "" {
+		path = filepath.Join(path, name)
+	}
+
+	var op EventOp
+	if raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		op |= EventCreate
+	}
+	if raw.Mask&syscall.IN_MODIFY != 0 {
+		op |= EventWrite
+	}
+	if raw.Mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		op |= EventRemove
+	}
+	if raw.Mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0 {
+		op |= EventRename
+	}
+	if raw.Mask&syscall.IN_ATTRIB != 0 {
+		op |= EventChmod
+	}
+	if op == 0 {
+		return
+	}
+
+	// watch new subdirectories, the event for the directory itself is
+	// reported by the parent's watch (unlike the IN_DELETE_SELF of the
+	// subdirectory, which is dropped)
+	if w.recursive && op&EventCreate != 0 && raw.Mask&syscall.IN_ISDIR != 0 {
+		if _, err := w.add(path); err == nil {
+			w.addTree(path)
+		}
+	}
+	if name == "" && raw.Wd != w.root {
+		return
+	}
+	w.push(Event{Name: path, Op: op})
+}
@@ -0,0 +1,59 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_DefaultOS_Watch(t *testing.T) {
+	dir := t.TempDir()
+	o := DefaultOS()
+
+	w, err := Watch(o, dir, true)
+	if err != nil {
+		t.Fatalf("failed to watch, err: %v", err)
+	}
+	defer w.Close()
+
+	conf := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(conf, []byte("debug=true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{conf, EventCreate}, {conf, EventWrite}}
+	if got := nextEvents(t, w, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	sub := filepath.Join(dir, "conf.d")
+	if err := o.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextEvents(t, w, 1); got[0] != (Event{sub, EventCreate}) {
+		t.Errorf("expected the new directory to be reported, got %v", got)
+	}
+
+	// the new directory is watched too
+	inner := filepath.Join(sub, "extra.conf")
+	if err := o.Chmod(conf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := o.Create(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	want = []Event{{conf, EventChmod}, {inner, EventCreate}}
+	if got := nextEvents(t, w, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if err := o.Rename(conf, conf+".old"); err != nil {
+		t.Fatal(err)
+	}
+	want = []Event{{conf, EventRename}, {conf + ".old", EventCreate}}
+	if got := nextEvents(t, w, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Watch fails with ENOTSUP, changes can only be reported on Linux.
+func (d *defaultOS) Watch(path string, recursive bool) (Watcher, error) {
+	return nil, &os.PathError{
+		Op:   "watch",
+		Path: path,
+		Err:  syscall.Errno(syscall.ENOTSUP),
+	}
+}
@@ -0,0 +1,244 @@
+package fs
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// nextEvents returns the next n events of w, failing t if they don't come.
+func nextEvents(t *testing.T, w Watcher, n int) []Event {
+	t.Helper()
+
+	var events []Event
+	for len(events) < n {
+		select {
+		case ev := <-w.Events():
+			events = append(events, ev)
+		case err := <-w.Errors():
+			t.Fatalf("unexpected watch error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", events)
+		}
+	}
+	return events
+}
+
+func Test_FakeOS_Watch(t *testing.T) {
+	o := FakeOS()
+	o.MkdirAll("/etc/app/conf.d", 0755)
+
+	w, err := Watch(o, "/etc/app", false)
+	if err != nil {
+		t.Fatalf("failed to watch, err: %v", err)
+	}
+	defer w.Close()
+
+	f, _ := o.Create("/etc/app/app.conf")
+	f.WriteString("debug=true")
+	f.Chmod(0600)
+	f.Close()
+	o.Create("/etc/app/conf.d/ignored") // not recursive
+	o.Rename("/etc/app/app.conf", "/etc/app/app.conf.old")
+	o.Remove("/etc/app/app.conf.old")
+
+	want := []Event{
+		{"/etc/app/app.conf", EventCreate},
+		{"/etc/app/app.conf", EventWrite},
+		{"/etc/app/app.conf", EventChmod},
+		{"/etc/app/app.conf", EventRename},
+		{"/etc/app/app.conf.old", EventCreate},
+		{"/etc/app/app.conf.old", EventRemove},
+	}
+	if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func Test_FakeOS_Watch_OpenFiles(t *testing.T) {
+	o := FakeOS()
+	o.MkdirAll("/srv/logs", 0755)
+	f, _ := o.Create("/srv/logs/app.log")
+	defer f.Close()
+
+	w, err := Watch(o, "/srv", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// writes through the open file follow its name
+	o.Rename("/srv/logs/app.log", "/srv/logs/app.log.1")
+	f.WriteString("rotated")
+	o.Rename("/srv/logs", "/srv/old")
+	f.WriteString("moved")
+	o.Link("/srv/old/app.log.1", "/srv/app.log")
+	o.Remove("/srv/old/app.log.1")
+	f.WriteString("linked")
+	o.Remove("/srv/app.log")
+	f.WriteString("removed")
+	o.Mkdir("/srv/new", 0755)
+
+	want := []Event{
+		{"/srv/logs/app.log", EventRename},
+		{"/srv/logs/app.log.1", EventCreate},
+		{"/srv/logs/app.log.1", EventWrite},
+		{"/srv/logs", EventRename},
+		{"/srv/old", EventCreate},
+		{"/srv/old/app.log.1", EventWrite},
+		{"/srv/app.log", EventCreate},
+		{"/srv/old/app.log.1", EventRemove},
+		{"/srv/app.log", EventWrite},
+		{"/srv/app.log", EventRemove},
+		{"/srv/new", EventCreate},
+	}
+	if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func Test_FakeOS_WatchRecursive(t *testing.T) {
+	o := FakeOS()
+	o.MkdirAll("/srv/www", 0755)
+
+	w, err := Watch(o, "/srv", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.MkdirAll("/srv/www/static", 0755)
+	o.Create("/srv/www/static/app.js")
+	o.RemoveAll("/srv/www")
+
+	want := []Event{
+		{"/srv/www/static", EventCreate},
+		{"/srv/www/static/app.js", EventCreate},
+		{"/srv/www/static/app.js", EventRemove},
+		{"/srv/www/static", EventRemove},
+		{"/srv/www", EventRemove},
+	}
+	if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Errorf("expected events to be closed along with the watcher")
+	}
+}
+
+func Test_Watch_Unsupported(t *testing.T) {
+	unwatchable := struct{ OperatingSystem }{FakeOS()}
+	if _, err := Watch(ReadOnly(unwatchable), "/", false); !errors.Is(err, syscall.ENOTSUP) {
+		t.Errorf("expected ENOTSUP, err: %v", err)
+	}
+	if _, err := Watch(FakeOS(), "/missing", false); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("expected ENOENT, err: %v", err)
+	}
+}
+
+func Test_Wrappers_Watch(t *testing.T) {
+	fake := FakeOS()
+	fake.MkdirAll("/srv/www", 0755)
+	fake.MkdirAll("/mnt/data", 0755)
+	mounted := FakeOS()
+
+	m := NewMountTable(fake)
+	m.Mount("/mnt/data", mounted)
+
+	for _, tc := range []struct {
+		name  string
+		o     OperatingSystem
+		watch string
+		want  string
+	}{
+		{"ReadOnly", ReadOnly(fake), "/srv", "/srv/www/static"},
+		{"Record", Record(fake, NewCallLog()), "/srv", "/srv/www/static"},
+		{"BasePath", BasePath(fake, "/srv"), "/", "/www/static"},
+		{"BasePathRelative", BasePath(fake, "/srv"), "www", "www/static"},
+		{"Mount", m, "/srv", "/srv/www/static"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := Watch(tc.o, tc.watch, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			fake.Mkdir("/srv/www/static", 0755)
+			fake.Mkdir("/outside", 0755)
+			fake.Remove("/srv/www/static")
+			fake.Remove("/outside")
+
+			want := []Event{{tc.want, EventCreate}, {tc.want, EventRemove}}
+			if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func Test_MountTable_Watch_Mounts(t *testing.T) {
+	mounted := FakeOS()
+	m := NewMountTable(FakeOS())
+	m.MkdirAll("/mnt/data", 0755)
+	m.Mount("/mnt/data", mounted)
+
+	w, err := Watch(m, "/mnt", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	mounted.Mkdir("/db", 0755)
+
+	want := []Event{{"/mnt/data/db", EventCreate}}
+	if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := Watch(BasePath(FakeOS(), "/srv"), "/../etc", false); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected EPERM, err: %v", err)
+	}
+}
+
+func Test_OverlayOS_Watch(t *testing.T) {
+	lower := FakeOS()
+	lower.MkdirAll("/etc/app", 0755)
+	WriteFile(lower, "/etc/app/app.conf", []byte("debug=false"), 0644)
+	o := Overlay(lower, FakeOS())
+
+	w, err := Watch(o, "/etc", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	f, _ := o.OpenFile("/etc/app/app.conf", O_WRONLY, 0)
+	f.WriteString("debug=true")
+	f.Close()
+	o.Chmod("/etc/app/app.conf", 0600)
+	o.Rename("/etc/app/app.conf", "/etc/app/app.conf.old")
+	o.RemoveAll("/etc/app")
+	lower.Mkdir("/etc/unseen", 0755) // not through the overlay
+
+	want := []Event{
+		{"/etc/app/app.conf", EventWrite},
+		{"/etc/app/app.conf", EventChmod},
+		{"/etc/app/app.conf", EventRename},
+		{"/etc/app/app.conf.old", EventCreate},
+		{"/etc/app/app.conf.old", EventRemove},
+		{"/etc/app", EventRemove},
+	}
+	if got := nextEvents(t, w, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	select {
+	case ev := <-w.Events():
+		t.Errorf("unexpected event %v", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package fs
+
+import "syscall"
+
+// errWouldBlock is the error of a lock that would have to wait, when asked
+// not to with LOCK_NB.
+const errWouldBlock = syscall.EWOULDBLOCK
@@ -0,0 +1,8 @@
+package fs
+
+import "syscall"
+
+// errWouldBlock is the error of a lock that would have to wait, when asked
+// not to with LOCK_NB. WASI has no EWOULDBLOCK, only EAGAIN which means
+// the same.
+const errWouldBlock = syscall.EAGAIN
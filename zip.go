@@ -0,0 +1,96 @@
+package fs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+)
+
+// ExtractZip extracts the zip archive read from r, of size bytes, to the
+// directory dest on o as ExtractTar does. Zip archives don't record
+// ownership nor hard links.
+func ExtractZip(o OperatingSystem, r io.ReaderAt, size int64, dest string, opts ...ArchiveOption) error {
+	c := newArchiveConfig(opts)
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	e := &extractor{o: o, dest: dest, unsafe: c.unsafe}
+	if err := o.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err := extractZipFile(e, f); err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+func extractZipFile(e *extractor, f *zip.File) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return e.dir(f.Name, mode&^os.ModeType, f.Modified, -1, -1)
+	}
+	if mode&os.ModeType != 0 && mode&os.ModeSymlink == 0 {
+		return unsupported(f.Name)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return e.symlink(f.Name, string(target), -1, -1)
+	}
+	return e.file(f.Name, mode, f.Modified, -1, -1, r)
+}
+
+// WriteZip writes the tree rooted at root on o to w as a zip archive, as
+// WriteTar does, the files being deflated. Hard links are written as
+// copies.
+func WriteZip(o OperatingSystem, root string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(o, root, false, func(e archiveEntry) error {
+		h, err := zip.FileInfoHeader(e.fi)
+		if err != nil {
+			return err
+		}
+		h.Name = e.name
+		h.Modified = e.fi.ModTime()
+		if e.fi.Mode().IsRegular() {
+			h.Method = zip.Deflate
+		}
+
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.fi.IsDir():
+			return nil
+		case e.link != "":
+			_, err = io.WriteString(fw, e.link)
+			return err
+		}
+
+		f, err := o.Open(e.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}